@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage/audit"
+)
+
+// auditDatabase reports the inconsistencies of the whole database and
+// deletes them with -repair. It fails while any is left, so it can gate a
+// CI job as check-zone does.
+func auditDatabase(cfg *config.Config) error {
+	db := openSqlite(cfg)
+	defer db.Close()
+	if err := db.CreateTable(); err != nil {
+		return err
+	}
+	report, err := audit.Run(context.Background(), db, cfg.Repair)
+	if err != nil {
+		return err
+	}
+	for _, f := range report.Findings {
+		switch f.Kind {
+		case audit.EmptyKey:
+			fmt.Printf("%s: key %d of %s has no content", f.Kind, f.KeyID, f.Zone)
+		case audit.DuplicateZone:
+			fmt.Printf("%s: zone %d %s, merge by hand", f.Kind, f.DomainID, f.Zone)
+		default:
+			fmt.Printf("%s: %d rows of missing zone %d", f.Kind, f.Count, f.DomainID)
+		}
+		if f.Repaired {
+			fmt.Print(", deleted")
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d findings, %d rows deleted\n", len(report.Findings), report.Repaired)
+	unrepaired := 0
+	for _, f := range report.Findings {
+		if !f.Repaired {
+			unrepaired++
+		}
+	}
+	if unrepaired > 0 {
+		return stacktrace.Newf("%d findings left", unrepaired)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+)
+
+func backup(cfg *config.Config) error {
+	db := openSqlite(cfg)
+	defer db.Close()
+	snapshot, err := db.Backup(context.Background(), cfg.BackupDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s  %s\n", snapshot.Checksum, snapshot.Path)
+	return nil
+}
+
+// restore must run while go-pdns is stopped.
+func restore(cfg *config.Config) error {
+	if len(cfg.Args) != 1 {
+		return stacktrace.New("usage: restore [flags] <snapshot>")
+	}
+	if err := sqlite.Restore(cfg.Args[0], cfg.DataSource); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s\n", cfg.DataSource, cfg.Args[0])
+	return nil
+}
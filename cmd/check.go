@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/service"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// checkZone prints the issues of zones and fails when any has errors, so
+// it can gate a CI job.
+func checkZone(cfg *config.Config) error {
+	if len(cfg.Args) == 0 {
+		return stacktrace.New("usage: check-zone [flags] <zone>...")
+	}
+	svc, db, err := openService(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	failed := 0
+	for _, arg := range cfg.Args {
+		zone, err := service.ParseDNSName(arg)
+		if err != nil {
+			return err
+		}
+		report, err := svc.CheckZone(context.Background(), zone)
+		if err != nil {
+			return stacktrace.Newf("check %s: %w", zone, err)
+		}
+		for _, issue := range report.Issues {
+			owner := issue.Name
+			if issue.Type != "" {
+				owner += " " + issue.Type
+			}
+			fmt.Printf("[%s] %s: %s\n", issue.Level, owner, issue.Message)
+		}
+		fmt.Printf("%s checked %d records, %d errors, %d warnings\n", report.Zone, report.Records, report.Errors, report.Warnings)
+		if report.Errors > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return stacktrace.Newf("%d zones with errors", failed)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/copier"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+)
+
+// copyStorage fills the -db database from -from, e.g. a stock PowerDNS
+// gsqlite3 database. Secrets are encrypted when a key-encryption-key is set.
+func copyStorage(cfg *config.Config) error {
+	if cfg.From == "" {
+		return stacktrace.New("usage: copy -from <source> [flags]")
+	}
+	var src storage.Store
+	switch cfg.FromStorage {
+	case "memory":
+		store, err := memory.LoadFile(cfg.From)
+		if err != nil {
+			return err
+		}
+		src = store
+	case "sqlite":
+		// the source is read from a migrated snapshot, its schema is left as it is
+		db, closeSource, err := sqlite.OpenMigrated(context.Background(), cfg.From, sqlite.WithBusyTimeout(cfg.BusyTimeout))
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		stg, err := decorate(cfg, db)
+		if err != nil {
+			return err
+		}
+		src = catalog.New(stg)
+	default:
+		return stacktrace.Newf("unknown storage %s", cfg.FromStorage)
+	}
+	db := openSqlite(cfg)
+	defer db.Close()
+	if err := db.CreateTable(); err != nil {
+		return err
+	}
+	stg, err := decorate(cfg, db)
+	if err != nil {
+		return err
+	}
+	report, err := copier.Copy(context.Background(), catalog.New(stg), src)
+	if report != nil && report.Destination != nil {
+		for i, table := range report.Source {
+			fmt.Printf("%-15s %8d -> %-8d %s\n", table.Name, table.Rows, report.Destination[i].Rows, table.Checksum)
+		}
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !debug
+// +build !debug
+
+package main
+
+import (
+	"github.com/ivan-bokov/go-pdns/internal/handler"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// withFaults injects faults into debug builds only.
+func withFaults(stg storage.IStorage) (storage.IStorage, []handler.Option) {
+	return stg, nil
+}
@@ -0,0 +1,17 @@
+//go:build debug
+// +build debug
+
+package main
+
+import (
+	"github.com/ivan-bokov/go-pdns/internal/handler"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/fault"
+)
+
+// withFaults puts the fault injector right above the database, so retries
+// and transactions see the faults as real storage errors.
+func withFaults(stg storage.IStorage) (storage.IStorage, []handler.Option) {
+	f := fault.New(stg)
+	return f, []handler.Option{handler.WithFaults(f)}
+}
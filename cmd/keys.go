@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage/crypt"
+)
+
+// rewrapKeys moves every secret under the current key-encryption-key,
+// the previous one is passed in -kek-retired-files.
+func rewrapKeys(cfg *config.Config) error {
+	kr, err := keyring(cfg)
+	if err != nil {
+		return err
+	}
+	if kr == nil {
+		return stacktrace.New("no key-encryption-key configured")
+	}
+	db := openSqlite(cfg)
+	defer db.Close()
+	n, err := crypt.Rewrap(context.Background(), db, kr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("re-wrapped %d secrets\n", n)
+	return nil
+}
@@ -1,27 +1,111 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
-	"github.com/ivan-bokov/go-pdns/internal/handler"
+	"github.com/ivan-bokov/go-pdns/internal/config"
 	"github.com/ivan-bokov/go-pdns/internal/service"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/crypt"
 	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
 )
 
+var commands = map[string]func(cfg *config.Config) error{
+	"serve":        serve,
+	"rewrap-keys":  rewrapKeys,
+	"backup":       backup,
+	"restore":      restore,
+	"copy":         copyStorage,
+	"split-prio":   splitPriorities,
+	"rectify-zone": rectifyZone,
+	"check-zone":   checkZone,
+	"audit":        auditDatabase,
+}
+
 func main() {
-	err := os.Remove("sql.db")
+	name := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", name)
+		os.Exit(2)
+	}
+	cfg, err := config.Parse(name, args)
 	if err != nil {
-		panic(err)
+		os.Exit(2)
+	}
+	if err = command(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func openSqlite(cfg *config.Config) *sqlite.Sqlite {
+	return sqlite.New(cfg.DataSource,
+		sqlite.WithBusyTimeout(cfg.BusyTimeout),
+		sqlite.WithReaders(cfg.Readers),
+		sqlite.WithGroupCommit(cfg.GroupWindow, cfg.GroupMax),
+	)
+}
+
+// openService serves the -db database to the maintenance commands, the
+// caller closes the database.
+func openService(cfg *config.Config) (*service.Service, *sqlite.Sqlite, error) {
+	db := openSqlite(cfg)
+	if err := db.CreateTable(); err != nil {
+		db.Close()
+		return nil, nil, err
 	}
-	storage := sqlite.New("sql.db")
-	err = storage.CreateTable()
+	stg, err := decorate(cfg, db)
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, nil, err
+	}
+	return service.New(stg, cfg.DNSSEC, service.WithPDNSVersion(cfg.PDNSVersion)), db, nil
+}
+
+// keyring returns nil when no key-encryption-key is configured.
+func keyring(cfg *config.Config) (*crypt.Keyring, error) {
+	var kek *crypt.KEK
+	var err error
+	switch {
+	case cfg.KEKFile != "":
+		kek, err = crypt.LoadKEKFile(cfg.KEKFile)
+	case os.Getenv(cfg.KEKEnv) != "":
+		kek, err = crypt.LoadKEKEnv(cfg.KEKEnv)
+	default:
+		return nil, nil
 	}
-	svc := service.New(storage, true)
-	handlerHTTP := handler.New(svc)
-	err = handlerHTTP.InitRoutes().Run()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	retired := make([]*crypt.KEK, 0)
+	for _, path := range strings.Split(cfg.KEKRetiredFiles, ",") {
+		if path == "" {
+			continue
+		}
+		k, err := crypt.LoadKEKFile(path)
+		if err != nil {
+			return nil, err
+		}
+		retired = append(retired, k)
+	}
+	return crypt.NewKeyring(kek, retired...), nil
+}
+
+func decorate(cfg *config.Config, stg storage.IStorage) (storage.IStorage, error) {
+	kr, err := keyring(cfg)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	if kr != nil {
+		stg = crypt.New(stg, kr)
 	}
+	return stg, nil
 }
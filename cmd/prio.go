@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/service"
+)
+
+// splitPriorities moves MX and SRV priorities written into the content,
+// before they were split on write, into the prio column.
+func splitPriorities(cfg *config.Config) error {
+	svc, db, err := openService(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := service.WithActor(context.Background(), "split-prio")
+	n, err := svc.SplitPriorities(ctx)
+	fmt.Printf("moved the priority of %d records\n", n)
+	return err
+}
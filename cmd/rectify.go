@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/service"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// rectifyZone repairs ordernames, auth flags and empty non-terminals of
+// zones edited outside go-pdns.
+func rectifyZone(cfg *config.Config) error {
+	if len(cfg.Args) == 0 {
+		return stacktrace.New("usage: rectify-zone [flags] <zone>...")
+	}
+	svc, db, err := openService(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := service.WithActor(context.Background(), "rectify-zone")
+	for _, arg := range cfg.Args {
+		zone, err := service.ParseDNSName(arg)
+		if err != nil {
+			return err
+		}
+		result, err := svc.RectifyZone(ctx, zone)
+		if err != nil {
+			return stacktrace.Newf("rectify %s: %w", zone, err)
+		}
+		for _, rrset := range result.Updated {
+			fmt.Printf("%s updated %s %s ordername=%q auth=%t\n", result.Zone, rrset.Name, rrset.Type, rrset.OrderName, rrset.Auth)
+		}
+		for _, name := range result.AddedENTs {
+			fmt.Printf("%s added empty non-terminal %s\n", result.Zone, name)
+		}
+		for _, name := range result.RemovedENTs {
+			fmt.Printf("%s removed empty non-terminal %s\n", result.Zone, name)
+		}
+		fmt.Printf("%s rectified, %d changes\n", result.Zone, len(result.Updated)+len(result.AddedENTs)+len(result.RemovedENTs))
+	}
+	return nil
+}
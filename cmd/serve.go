@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/config"
+	"github.com/ivan-bokov/go-pdns/internal/handler"
+	"github.com/ivan-bokov/go-pdns/internal/service"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/ivan-bokov/go-pdns/internal/storage/middleware"
+	"github.com/ivan-bokov/go-pdns/internal/storage/retry"
+	"go.uber.org/zap"
+)
+
+func serve(cfg *config.Config) error {
+	opts := []service.Option{
+		service.WithTimeouts(service.Timeouts{
+			Default:    cfg.Timeout,
+			Lookup:     cfg.LookupTimeout,
+			List:       cfg.ListTimeout,
+			FeedRecord: cfg.FeedRecordTimeout,
+		}),
+		service.WithPDNSVersion(cfg.PDNSVersion),
+		service.WithZoneCacheTTL(cfg.ZoneCacheTTL),
+		service.WithTransactionIdleTimeout(cfg.TransactionIdleTimeout),
+	}
+	handlerOpts := []handler.Option{
+		handler.WithAdminToken(cfg.AdminToken),
+	}
+	var svc *service.Service
+	switch cfg.Storage {
+	case "memory":
+		store, err := openMemory(cfg)
+		if err != nil {
+			return err
+		}
+		svc = service.New(nil, cfg.DNSSEC, append(opts, service.WithStore(store))...)
+	case "sqlite":
+		db := openSqlite(cfg)
+		defer db.Close()
+		if err := db.CreateTable(); err != nil {
+			return err
+		}
+		faulty, faultOpts := withFaults(db)
+		observed, metricsOpts, err := observe(cfg, faulty)
+		if err != nil {
+			return err
+		}
+		retrying := retry.New(observed, retry.Policy{
+			MaxAttempts: cfg.RetryAttempts,
+			BaseDelay:   cfg.RetryBaseDelay,
+			MaxDelay:    cfg.RetryMaxDelay,
+		})
+		stg, err := decorate(cfg, retrying)
+		if err != nil {
+			return err
+		}
+		svc = service.New(stg, cfg.DNSSEC, append(opts, service.WithRetry(retrying))...)
+		handlerOpts = append(handlerOpts,
+			handler.WithBackup(db, cfg.BackupDir),
+			handler.WithRetryStats(retrying),
+			handler.WithWriteStats(db),
+		)
+		handlerOpts = append(handlerOpts, faultOpts...)
+		handlerOpts = append(handlerOpts, metricsOpts...)
+	default:
+		return stacktrace.Newf("unknown storage %s", cfg.Storage)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svc.RunCompaction(ctx, time.Hour, service.CompactionPolicy{
+		MaxAge:   cfg.JournalMaxAge,
+		KeepLast: cfg.JournalKeepLast,
+	})
+	handlerHTTP := handler.New(svc, handlerOpts...)
+	return handlerHTTP.InitRoutes().Run(cfg.Listen)
+}
+
+func openMemory(cfg *config.Config) (*memory.Store, error) {
+	if cfg.Seed == "" {
+		return memory.New(), nil
+	}
+	return memory.LoadFile(cfg.Seed)
+}
+
+// observe puts the metrics, error log and slow-query log middleware enabled
+// in cfg around stg.
+func observe(cfg *config.Config, stg storage.IStorage) (storage.IStorage, []handler.Option, error) {
+	mws := make([]middleware.Middleware, 0, 3)
+	opts := make([]handler.Option, 0, 1)
+	if cfg.StorageMetrics {
+		metrics := middleware.NewMetrics()
+		mws = append(mws, metrics.Middleware())
+		opts = append(opts, handler.WithStorageMetrics(metrics))
+	}
+	if cfg.StorageLogErrors || cfg.SlowQuery > 0 {
+		logger, err := zap.NewProduction()
+		if err != nil {
+			return nil, nil, stacktrace.Wrap(err)
+		}
+		if cfg.StorageLogErrors {
+			mws = append(mws, middleware.Logging(logger))
+		}
+		if cfg.SlowQuery > 0 {
+			mws = append(mws, middleware.SlowLog(logger, cfg.SlowQuery))
+		}
+	}
+	return middleware.Chain(stg, mws...), opts, nil
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"flag"
+	"runtime"
+	"time"
+)
+
+type Config struct {
+	// Args are the positional arguments left after flags
+	Args []string
+
+	Listen     string
+	Storage    string
+	DataSource string
+	Seed       string
+	DNSSEC     bool
+	// PDNSVersion is the major version of the PowerDNS served
+	PDNSVersion int
+
+	From        string
+	FromStorage string
+
+	AdminToken string
+	BackupDir  string
+	// Repair lets audit delete what it finds
+	Repair bool
+
+	JournalMaxAge   time.Duration
+	JournalKeepLast int
+
+	KEKFile         string
+	KEKEnv          string
+	KEKRetiredFiles string
+
+	BusyTimeout time.Duration
+	Readers     int
+	GroupWindow time.Duration
+	GroupMax    int
+
+	StorageMetrics   bool
+	StorageLogErrors bool
+	SlowQuery        time.Duration
+
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	Timeout           time.Duration
+	LookupTimeout     time.Duration
+	ListTimeout       time.Duration
+	FeedRecordTimeout time.Duration
+
+	ZoneCacheTTL time.Duration
+
+	TransactionIdleTimeout time.Duration
+}
+
+func Parse(name string, args []string) (*Config, error) {
+	cfg := new(Config)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.Listen, "listen", ":8080", "HTTP listen address")
+	fs.StringVar(&cfg.Storage, "storage", "sqlite", "storage backend: sqlite or memory")
+	fs.StringVar(&cfg.DataSource, "db", "sql.db", "SQLite database file")
+	fs.StringVar(&cfg.Seed, "seed", "", "JSON file the memory storage starts from")
+	fs.StringVar(&cfg.From, "from", "", "copy source: SQLite database file, or seed file with -from-storage memory")
+	fs.StringVar(&cfg.FromStorage, "from-storage", "sqlite", "copy source storage backend: sqlite or memory")
+	fs.BoolVar(&cfg.DNSSEC, "dnssec", true, "enable DNSSEC methods")
+	fs.IntVar(&cfg.PDNSVersion, "pdns-version", 4, "PowerDNS major version, before 4 MX and SRV priorities are answered in prio")
+	fs.StringVar(&cfg.AdminToken, "admin-token", "", "X-API-Key of the admin API, empty disables it")
+	fs.StringVar(&cfg.BackupDir, "backup-dir", "backups", "directory for database snapshots")
+	fs.BoolVar(&cfg.Repair, "repair", false, "audit deletes the orphans and empty keys it finds, take a backup first")
+	fs.DurationVar(&cfg.JournalMaxAge, "journal-max-age", 30*24*time.Hour, "delete journal entries older than this, 0 keeps them")
+	fs.IntVar(&cfg.JournalKeepLast, "journal-keep-last", 0, "keep at most this many journal entries, 0 is unlimited")
+	fs.StringVar(&cfg.KEKFile, "kek-file", "", "file with the key-encryption-key for DNSSEC keys and TSIG secrets")
+	fs.StringVar(&cfg.KEKEnv, "kek-env", "GO_PDNS_KEK", "environment variable with the key-encryption-key, used without -kek-file")
+	fs.StringVar(&cfg.KEKRetiredFiles, "kek-retired-files", "", "comma separated files with retired key-encryption-keys")
+	fs.DurationVar(&cfg.BusyTimeout, "sqlite-busy-timeout", 5*time.Second, "SQLite busy timeout")
+	fs.IntVar(&cfg.Readers, "sqlite-readers", runtime.NumCPU(), "SQLite read-only connection pool size")
+	fs.DurationVar(&cfg.GroupWindow, "sqlite-group-window", 0, "how long a write waits for more writes to commit with, 0 groups only writes already queued")
+	fs.IntVar(&cfg.GroupMax, "sqlite-group-max", 128, "most writes committed in one transaction, 0 turns group commit off")
+	fs.BoolVar(&cfg.StorageMetrics, "storage-metrics", true, "collect per statement metrics, shown by admin/stats")
+	fs.BoolVar(&cfg.StorageLogErrors, "storage-log-errors", true, "log failed statements with redacted arguments")
+	fs.DurationVar(&cfg.SlowQuery, "slow-query", time.Second, "log statements slower than this, 0 disables the log")
+	fs.IntVar(&cfg.RetryAttempts, "retry-attempts", 5, "attempts of a storage call failing with a transient error")
+	fs.DurationVar(&cfg.RetryBaseDelay, "retry-base-delay", 10*time.Millisecond, "first storage retry backoff")
+	fs.DurationVar(&cfg.RetryMaxDelay, "retry-max-delay", 500*time.Millisecond, "longest storage retry backoff")
+	fs.DurationVar(&cfg.Timeout, "timeout", 5*time.Second, "default storage timeout")
+	fs.DurationVar(&cfg.LookupTimeout, "lookup-timeout", 2*time.Second, "lookup storage timeout")
+	fs.DurationVar(&cfg.ListTimeout, "list-timeout", time.Minute, "list storage timeout")
+	fs.DurationVar(&cfg.FeedRecordTimeout, "feedrecord-timeout", 10*time.Second, "feedrecord storage timeout")
+	fs.DurationVar(&cfg.ZoneCacheTTL, "zone-cache-ttl", 10*time.Second, "how long zone names are cached to find the zone of lookups without zone id, zones changed by other processes are seen this late, 0 disables the cache")
+	fs.DurationVar(&cfg.TransactionIdleTimeout, "transaction-idle-timeout", 5*time.Minute, "abort a PowerDNS transaction left without calls for this long, 0 never does")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Args = fs.Args()
+	return cfg, nil
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/fault"
+	"github.com/ivan-bokov/go-pdns/internal/storage/middleware"
+	"github.com/ivan-bokov/go-pdns/internal/storage/retry"
+)
+
+type Backuper interface {
+	Backup(ctx context.Context, dir string) (*storage.Snapshot, error)
+}
+
+type RetryStats interface {
+	Stats() retry.Stats
+}
+
+type WriteStats interface {
+	WriteStats() storage.WriteStats
+}
+
+type StorageMetrics interface {
+	Snapshot() map[string]middleware.StmtStats
+}
+
+type adminConfig struct {
+	token      string
+	backuper   Backuper
+	backupDir  string
+	retryStats RetryStats
+	writeStats WriteStats
+	metrics    StorageMetrics
+	faults     *fault.Storage
+}
+
+type Option func(h *Handler)
+
+// WithAdminToken enables the admin API, requests must carry the token in
+// the X-API-Key header.
+func WithAdminToken(token string) Option {
+	return func(h *Handler) {
+		h.admin.token = token
+	}
+}
+
+func WithBackup(b Backuper, dir string) Option {
+	return func(h *Handler) {
+		h.admin.backuper = b
+		h.admin.backupDir = dir
+	}
+}
+
+func WithRetryStats(r RetryStats) Option {
+	return func(h *Handler) {
+		h.admin.retryStats = r
+	}
+}
+
+func WithWriteStats(w WriteStats) Option {
+	return func(h *Handler) {
+		h.admin.writeStats = w
+	}
+}
+
+func WithStorageMetrics(m StorageMetrics) Option {
+	return func(h *Handler) {
+		h.admin.metrics = m
+	}
+}
+
+func (h *Handler) initAdminRoutes(r *gin.Engine) {
+	if h.admin.token == "" {
+		return
+	}
+	admin := r.Group("admin", h.adminAuth())
+	admin.GET("changes", h.changes)
+	admin.GET("stats", h.stats)
+	admin.POST("rectify/:zone", h.rectifyZone)
+	admin.GET("check/:zone", h.checkZone)
+	if h.admin.backuper != nil {
+		admin.POST("backup", h.backup)
+	}
+	h.initFaultRoutes(admin)
+}
+
+func (h *Handler) adminAuth() gin.HandlerFunc {
+	return func(g *gin.Context) {
+		key := g.GetHeader("X-API-Key")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(h.admin.token)) != 1 {
+			g.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"result": false})
+			return
+		}
+		g.Next()
+	}
+}
+
+func (h *Handler) backup(g *gin.Context) {
+	snapshot, err := h.admin.backuper.Backup(g.Request.Context(), h.admin.backupDir)
+	if err != nil {
+		log.Println(fmt.Sprintf("[ERROR]: backup: %v", err))
+		g.JSON(http.StatusInternalServerError, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": snapshot})
+}
+
+func (h *Handler) rectifyZone(g *gin.Context) {
+	zone, ok := dnsName(g, "zone")
+	if !ok {
+		return
+	}
+	result, err := h.svc.RectifyZone(g.Request.Context(), zone)
+	if errors.Is(err, storage.ErrNotFound) {
+		g.JSON(http.StatusNotFound, gin.H{"result": false})
+		return
+	}
+	if err != nil {
+		log.Println(fmt.Sprintf("[ERROR]: rectify %s: %v", zone, err))
+		g.JSON(http.StatusInternalServerError, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": result})
+}
+
+func (h *Handler) checkZone(g *gin.Context) {
+	zone, ok := dnsName(g, "zone")
+	if !ok {
+		return
+	}
+	report, err := h.svc.CheckZone(g.Request.Context(), zone)
+	if errors.Is(err, storage.ErrNotFound) {
+		g.JSON(http.StatusNotFound, gin.H{"result": false})
+		return
+	}
+	if err != nil {
+		log.Println(fmt.Sprintf("[ERROR]: check %s: %v", zone, err))
+		g.JSON(http.StatusInternalServerError, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": report})
+}
+
+func (h *Handler) stats(g *gin.Context) {
+	stats := gin.H{}
+	if h.admin.retryStats != nil {
+		stats["retry"] = h.admin.retryStats.Stats()
+	}
+	if h.admin.writeStats != nil {
+		stats["writes"] = h.admin.writeStats.WriteStats()
+	}
+	if h.admin.metrics != nil {
+		stats["storage"] = h.admin.metrics.Snapshot()
+	}
+	g.JSON(200, gin.H{"result": stats})
+}
+
+func (h *Handler) changes(g *gin.Context) {
+	var err error
+	var since int64
+	domainID, limit := -1, 1000
+	if v := g.Query("since"); v != "" {
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			g.JSON(http.StatusBadRequest, gin.H{"result": false})
+			return
+		}
+	}
+	if v := g.Query("zone_id"); v != "" {
+		if domainID, err = strconv.Atoi(v); err != nil {
+			g.JSON(http.StatusBadRequest, gin.H{"result": false})
+			return
+		}
+	}
+	if v := g.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			g.JSON(http.StatusBadRequest, gin.H{"result": false})
+			return
+		}
+	}
+	changes, err := h.svc.ChangesSince(g.Request.Context(), since, domainID, limit)
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": changes})
+}
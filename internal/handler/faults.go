@@ -0,0 +1,9 @@
+//go:build !debug
+// +build !debug
+
+package handler
+
+import "github.com/gin-gonic/gin"
+
+// initFaultRoutes registers the fault injection API in debug builds only.
+func (h *Handler) initFaultRoutes(admin *gin.RouterGroup) {}
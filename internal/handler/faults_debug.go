@@ -0,0 +1,52 @@
+//go:build debug
+// +build debug
+
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ivan-bokov/go-pdns/internal/storage/fault"
+)
+
+// WithFaults exposes the rules of f under admin/faults.
+func WithFaults(f *fault.Storage) Option {
+	return func(h *Handler) {
+		h.admin.faults = f
+	}
+}
+
+func (h *Handler) initFaultRoutes(admin *gin.RouterGroup) {
+	if h.admin.faults == nil {
+		return
+	}
+	admin.GET("faults", h.faultRules)
+	admin.PUT("faults", h.setFault)
+	admin.DELETE("faults", h.resetFaults)
+	admin.DELETE("faults/:stmt", h.clearFault)
+}
+
+func (h *Handler) faultRules(g *gin.Context) {
+	g.JSON(200, gin.H{"result": h.admin.faults.Rules()})
+}
+
+func (h *Handler) setFault(g *gin.Context) {
+	var rule fault.Rule
+	if err := g.ShouldBindJSON(&rule); err != nil || rule.Stmt == "" {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	h.admin.faults.Set(rule)
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) resetFaults(g *gin.Context) {
+	h.admin.faults.Reset()
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) clearFault(g *gin.Context) {
+	h.admin.faults.Clear(g.Param("stmt"))
+	g.JSON(200, gin.H{"result": true})
+}
@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,14 +14,52 @@ import (
 	"github.com/ivan-bokov/go-pdns/internal/service"
 )
 
+const streamFlushEvery = 1000
+
 type Handler struct {
-	svc *service.Service
+	svc   *service.Service
+	admin adminConfig
 }
 
-func New(svc *service.Service) *Handler {
-	return &Handler{
+func New(svc *service.Service, opts ...Option) *Handler {
+	h := &Handler{
 		svc: svc,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// dnsName parses a path parameter, answering 400 when it is not a name.
+func dnsName(g *gin.Context, param string) (service.DNSName, bool) {
+	name, err := service.ParseDNSName(g.Param(param))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return name, false
+	}
+	return name, true
+}
+
+// rejected answers 400 to a failed write. The reason of invalid content
+// goes to the log field, which PowerDNS writes to its own log.
+func rejected(g *gin.Context, err error) {
+	var invalid *service.ContentError
+	if errors.As(err, &invalid) {
+		g.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"result": false, "log": []string{invalid.Error()}})
+		return
+	}
+	g.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"result": false})
+}
+
+// qType parses a path parameter, answering 400 when it is not a type.
+func qType(g *gin.Context, param string) (service.QType, bool) {
+	qtype, err := service.ParseQType(g.Param(param))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return qtype, false
+	}
+	return qtype, true
 }
 
 func (h *Handler) noImplementation(g *gin.Context) {
@@ -34,40 +75,58 @@ func (h *Handler) logAllResponse() gin.HandlerFunc {
 			log.Println(fmt.Sprintf("[ERROR]: %#v", err))
 		}
 		g.Request.Body.Close()
+		g.Request.Body = io.NopCloser(bytes.NewReader(body))
 		log.Println(fmt.Sprintf("URI:%s Method:%s Headers: %#v Body:%s", uri, method, g.Request.Header, string(body)))
 		g.Next()
 	}
 }
 
+// actor tags changes made by a request with X-Actor, or the client address.
+func (h *Handler) actor() gin.HandlerFunc {
+	return func(g *gin.Context) {
+		actor := g.GetHeader("X-Actor")
+		if actor == "" {
+			actor = "remote:" + g.ClientIP()
+		}
+		g.Request = g.Request.WithContext(service.WithActor(g.Request.Context(), actor))
+		g.Next()
+	}
+}
+
 func (h *Handler) InitRoutes() *gin.Engine {
 	r := gin.Default()
 	r.Use(h.logAllResponse())
+	r.Use(h.actor())
 	r.GET("lookup/:qname/:qtype", h.lookup)    //++++
 	r.GET("list/:domain_id/:zonename", h.list) // ++++
 	r.GET("getbeforeandafternamesabsolute/:domain_id/:qname", h.getbeforeandafternamesabsolute)
 	r.GET("getalldomainmetadata/:name", h.getAllDomainMetadata) // ++++
 	r.GET("getdomainmetadata/:name/:kind", h.noImplementation)
 	r.PATCH("setdomainmetadata/:name/:kind", h.setDomainMetadata) //++++
-	r.GET("getdomainkeys/:name/:kind", h.noImplementation)
+	r.GET("getdomainkeys/:name", h.getDomainKeys)
+	r.GET("getdomainkeys/:name/:kind", h.getDomainKeys)
 	r.PUT("adddomainkey/:name", h.addDomainKey) //+++?
 	r.DELETE("removedomainkey/:name/:id", h.noImplementation)
 	r.POST("activatedomainkey/:name/:id", h.noImplementation)
 	r.POST("deactivatedomainkey/:name/:id", h.noImplementation)
 	r.POST("publishdomainkey/:name/:id", h.noImplementation)
 	r.POST("unpublishdomainkey/:name/:id", h.noImplementation)
-	r.GET("gettsigkey/:name", h.noImplementation)
+	r.GET("gettsigkey/:name", h.getTSIGKey)
+	r.PATCH("settsigkey/:name", h.setTSIGKey)
+	r.DELETE("deletetsigkey/:name", h.deleteTSIGKey)
+	r.GET("gettsigkeys", h.getTSIGKeys)
 	r.GET("getdomaininfo/:name", h.getDomainInfo) // ++++
 	r.PATCH("setnotified/:id", h.setNotified)     // ++++
 	r.GET("isMaster/:name/:ip", h.noImplementation)
 	r.POST("supermasterbackend/:ip/:domain", h.noImplementation)
 	r.POST("createslavedomain/:ip/:domain", h.createSlaveDomain) //++++
-	r.PATCH("replacerrset/:domain_id/:qname/:qtype", h.noImplementation)
+	r.PATCH("replacerrset/:domain_id/:qname/:qtype", h.replaceRRSet)
 	r.PATCH("feedrecord/:trxid", h.feedRecord) //++--
 	r.PATCH("feedents/:domain_id", h.noImplementation)
 	r.PATCH("feedEnts3/:domain_id/:domain", h.noImplementation)
-	r.POST("starttransaction/:domain_id/:domain", h.noImplementation)
-	r.POST("committransaction/:trxid", h.noImplementation)
-	r.POST("aborttransaction/:trxid", h.noImplementation)
+	r.POST("starttransaction/:domain_id/:domain", h.startTransaction)
+	r.POST("committransaction/:trxid", h.commitTransaction)
+	r.POST("aborttransaction/:trxid", h.abortTransaction)
 	r.POST("calculatesoaserial/:domain", h.noImplementation)
 	r.POST("directBackendCmd", h.noImplementation)
 	r.GET("getAllDomains", h.getAllDomains) // ++++
@@ -76,6 +135,8 @@ func (h *Handler) InitRoutes() *gin.Engine {
 	r.GET("getUnfreshSlaveInfos", h.noImplementation)
 	r.PATCH("setFresh/:id", h.setFresh) // ++++
 
+	h.initAdminRoutes(r)
+
 	return r
 }
 
@@ -86,69 +147,132 @@ func (h *Handler) getAllDomains(g *gin.Context) {
 		disabled, err = strconv.ParseBool(g.Query("includeDisabled"))
 		if err != nil {
 			g.JSON(http.StatusBadRequest, gin.H{"result": false})
+			return
 		}
 	}
-	di, err := h.svc.GetAllDomains(disabled)
+	di, err := h.svc.GetAllDomains(g.Request.Context(), disabled)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
+		return
 	}
 	g.JSON(200, gin.H{"result": di})
 }
 
 func (h *Handler) lookup(g *gin.Context) {
-	qtype := g.Param("qtype")
-	qname := g.Param("qname")
+	qtype, ok := qType(g, "qtype")
+	if !ok {
+		return
+	}
+	qname, ok := dnsName(g, "qname")
+	if !ok {
+		return
+	}
 	zoneID := -1
 	var err error
 	if g.Request.Header.Get("X-RemoteBackend-zone-id") != "" {
 		zoneID, err = strconv.Atoi(g.Request.Header.Get("X-RemoteBackend-zone-id"))
 		if err != nil {
 			g.JSON(http.StatusBadRequest, gin.H{"result": false})
+			return
 		}
 	}
-	listRR, err := h.svc.Lookup(qtype, qname, zoneID)
+	listRR, err := h.svc.Lookup(g.Request.Context(), qtype, qname, zoneID)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
+		return
 	}
 	g.JSON(200, gin.H{"result": listRR})
 }
 func (h *Handler) getDomainInfo(g *gin.Context) {
-	name := g.Param("name")
-	di, err := h.svc.GetDomainInfo(name)
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
+	di, err := h.svc.GetDomainInfo(g.Request.Context(), name)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
+		return
 	}
 	g.JSON(200, gin.H{"result": di})
 }
 
 func (h *Handler) list(g *gin.Context) {
-	zonename := g.Param("zonename")
+	zonename, ok := dnsName(g, "zonename")
+	if !ok {
+		return
+	}
 	domainID := -1
 	var err error
 	if g.Request.Header.Get("X-RemoteBackend-domain-id") != "" {
 		domainID, err = strconv.Atoi(g.Request.Header.Get("X-RemoteBackend-domain-id"))
 		if err != nil {
 			g.JSON(http.StatusBadRequest, gin.H{"result": false})
+			return
 		}
 	}
 	if g.Param("domain_id") != "" {
 		domainID, err = strconv.Atoi(g.Param("domain_id"))
 		if err != nil {
 			g.JSON(http.StatusBadRequest, gin.H{"result": false})
+			return
 		}
 	}
-	listRR, err := h.svc.List(zonename, domainID, false)
+	it, err := h.svc.List(g.Request.Context(), zonename, domainID, false)
 	if err != nil {
 		g.JSON(200, gin.H{"result": make([]string, 0)})
+		return
 	}
-	g.JSON(200, gin.H{"result": listRR})
+	defer it.Close()
+	h.streamRecords(g, it)
 }
+
+// streamRecords writes {"result":[...]} while reading the iterator. On a
+// failure in the middle it drops the connection, so PowerDNS fails the
+// listing instead of accepting a truncated zone. Over HTTP/2 the JSON is
+// left unterminated, which fails it as well.
+func (h *Handler) streamRecords(g *gin.Context, it *service.RecordIterator) {
+	g.Header("Content-Type", "application/json; charset=utf-8")
+	g.Status(200)
+	w := g.Writer
+	enc := json.NewEncoder(w)
+	_, err := io.WriteString(w, `{"result":[`)
+	for n := 0; err == nil && it.Next(); n++ {
+		if n > 0 {
+			if _, err = io.WriteString(w, ","); err != nil {
+				break
+			}
+		}
+		err = enc.Encode(it.Record())
+		if n%streamFlushEvery == 0 {
+			w.Flush()
+		}
+	}
+	if err == nil {
+		err = it.Err()
+	}
+	if err != nil {
+		log.Println(fmt.Sprintf("[ERROR]: list: %v", err))
+		g.Abort()
+		// only HTTP/1 connections can be hijacked
+		if g.Request.ProtoMajor == 1 {
+			if conn, _, err := w.Hijack(); err == nil {
+				_ = conn.Close()
+			}
+		}
+		return
+	}
+	_, _ = io.WriteString(w, "]}")
+}
+
 func (h *Handler) getAllDomainMetadata(g *gin.Context) {
-	name := g.Param("name")
-	var err error
-	meta, err := h.svc.GetAllDomainMetadata(name)
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
+	meta, err := h.svc.GetAllDomainMetadata(g.Request.Context(), name)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
+		return
 	}
 	g.JSON(200, gin.H{"result": meta})
 }
@@ -159,7 +283,10 @@ func (h *Handler) getbeforeandafternamesabsolute(g *gin.Context) {
 }
 
 func (h *Handler) setDomainMetadata(g *gin.Context) {
-	name := g.Param("name")
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
 	kind := g.Param("kind")
 	type valueMetadata struct {
 		Value []string `json:"value,omitempty" form:"value"`
@@ -169,7 +296,7 @@ func (h *Handler) setDomainMetadata(g *gin.Context) {
 		g.JSON(http.StatusBadRequest, gin.H{"result": false})
 		return
 	}
-	err := h.svc.SetDomainMetadata(name, kind, values.Value)
+	err := h.svc.SetDomainMetadata(g.Request.Context(), name, kind, values.Value)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
 		return
@@ -178,7 +305,10 @@ func (h *Handler) setDomainMetadata(g *gin.Context) {
 }
 
 func (h *Handler) addDomainKey(g *gin.Context) {
-	name := g.Param("name")
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
 	key := new(service.KeyData)
 	var err error
 	if flags, ok := g.GetPostForm("flags"); ok {
@@ -206,7 +336,72 @@ func (h *Handler) addDomainKey(g *gin.Context) {
 		key.Content = content
 	}
 
-	err = h.svc.AddDomainKey(name, key)
+	err = h.svc.AddDomainKey(g.Request.Context(), name, key)
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) getDomainKeys(g *gin.Context) {
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
+	keys, err := h.svc.GetDomainKeys(g.Request.Context(), name)
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": keys})
+}
+
+func (h *Handler) getTSIGKey(g *gin.Context) {
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
+	key, err := h.svc.GetTSIGKey(g.Request.Context(), name)
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": gin.H{"algorithm": key.Algorithm, "content": key.Content}})
+}
+
+func (h *Handler) getTSIGKeys(g *gin.Context) {
+	keys, err := h.svc.GetTSIGKeys(g.Request.Context())
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": keys})
+}
+
+func (h *Handler) setTSIGKey(g *gin.Context) {
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
+	err := h.svc.SetTSIGKey(g.Request.Context(), &service.TSIGKey{
+		Name:      name.String(),
+		Algorithm: g.PostForm("algorithm"),
+		Content:   g.PostForm("content"),
+	})
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) deleteTSIGKey(g *gin.Context) {
+	name, ok := dnsName(g, "name")
+	if !ok {
+		return
+	}
+	err := h.svc.DeleteTSIGKey(g.Request.Context(), name)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
 		return
@@ -215,6 +410,11 @@ func (h *Handler) addDomainKey(g *gin.Context) {
 }
 
 func (h *Handler) feedRecord(g *gin.Context) {
+	trxID, err := strconv.Atoi(g.Param("trxid"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
 	m := make(map[string]string)
 	var ok bool
 	if m, ok = g.GetPostFormMap("rr"); !ok {
@@ -231,16 +431,108 @@ func (h *Handler) feedRecord(g *gin.Context) {
 		g.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"result": false})
 		return
 	}
-	err = h.svc.FeedRecord(&service.DNSResourceRecord{
+	// PowerDNS before 4 sends the MX and SRV priority apart
+	var prio int
+	if m["prio"] != "" {
+		if prio, err = strconv.Atoi(m["prio"]); err != nil {
+			g.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"result": false})
+			return
+		}
+	}
+	err = h.svc.FeedTransactionRecord(g.Request.Context(), trxID, &service.DNSResourceRecord{
 		Qname:   m["qname"],
 		Content: m["content"],
 		TTL:     ttl,
 		Qtype:   m["qtype"],
 		Auth:    auth,
 		Qclass:  m["qclass"],
-	}, "")
+		Prio:    prio,
+	}, m["ordername"])
 	if err != nil {
-		g.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"result": false})
+		rejected(g, err)
+		return
+	}
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) replaceRRSet(g *gin.Context) {
+	domainID, err := strconv.Atoi(g.Param("domain_id"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	qname, ok := dnsName(g, "qname")
+	if !ok {
+		return
+	}
+	qtype, ok := qType(g, "qtype")
+	if !ok {
+		return
+	}
+	trxID, err := strconv.Atoi(g.PostForm("trxid"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	rrset, err := parseRRSet(g.Request.PostForm)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	err = h.svc.ReplaceRRSet(g.Request.Context(), trxID, domainID, qname, qtype, rrset)
+	if err != nil {
+		rejected(g, err)
+		return
+	}
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) startTransaction(g *gin.Context) {
+	domainID, err := strconv.Atoi(g.Param("domain_id"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	trxID, err := strconv.Atoi(g.PostForm("trxid"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	domain, ok := dnsName(g, "domain")
+	if !ok {
+		return
+	}
+	err = h.svc.StartTransaction(g.Request.Context(), trxID, domainID, domain)
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) commitTransaction(g *gin.Context) {
+	trxID, err := strconv.Atoi(g.Param("trxid"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	err = h.svc.CommitTransaction(g.Request.Context(), trxID)
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
+		return
+	}
+	g.JSON(200, gin.H{"result": true})
+}
+
+func (h *Handler) abortTransaction(g *gin.Context) {
+	trxID, err := strconv.Atoi(g.Param("trxid"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"result": false})
+		return
+	}
+	err = h.svc.AbortTransaction(g.Request.Context(), trxID)
+	if err != nil {
+		g.JSON(200, gin.H{"result": false})
 		return
 	}
 	g.JSON(200, gin.H{"result": true})
@@ -248,8 +540,11 @@ func (h *Handler) feedRecord(g *gin.Context) {
 
 func (h *Handler) createSlaveDomain(g *gin.Context) {
 	ip := g.Param("ip")
-	domain := g.Param("domain")
-	err := h.svc.CreateSlaveDomain(ip, domain)
+	domain, ok := dnsName(g, "domain")
+	if !ok {
+		return
+	}
+	err := h.svc.CreateSlaveDomain(g.Request.Context(), ip, domain)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
 		return
@@ -263,7 +558,7 @@ func (h *Handler) setFresh(g *gin.Context) {
 		g.JSON(http.StatusBadRequest, gin.H{"result": false})
 		return
 	}
-	err = h.svc.SetFresh(id)
+	err = h.svc.SetFresh(g.Request.Context(), id)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
 		return
@@ -285,7 +580,7 @@ func (h *Handler) setNotified(g *gin.Context) {
 			return
 		}
 	}
-	err = h.svc.SetNotified(id, serial)
+	err = h.svc.SetNotified(g.Request.Context(), id, serial)
 	if err != nil {
 		g.JSON(200, gin.H{"result": false})
 		return
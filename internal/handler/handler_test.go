@@ -0,0 +1,108 @@
+//go:build cgo
+// +build cgo
+
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ivan-bokov/go-pdns/internal/service"
+	"github.com/ivan-bokov/go-pdns/internal/storage/fault"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_ListFailsMidStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	db := sqlite.New(":memory:")
+	defer db.Close()
+	assert.Equal(t, db.CreateTable(), nil)
+	faulty := fault.New(db)
+	svc := service.New(faulty, false)
+	zone := service.MustParseDNSName("stream.test.")
+	assert.Equal(t, svc.CreateSlaveDomain(ctx, "192.0.2.1", zone), nil)
+	info, err := svc.GetDomainInfo(ctx, zone)
+	assert.Equal(t, err, nil)
+	for i := 0; i < 5; i++ {
+		rr := &service.DNSResourceRecord{DomainID: info.ID, Qname: fmt.Sprintf("h%d.stream.test.", i), Qtype: "A", Content: "192.0.2.1", TTL: 300}
+		assert.Equal(t, svc.FeedRecord(ctx, rr, ""), nil)
+	}
+	server := httptest.NewServer(New(svc).InitRoutes())
+	defer server.Close()
+	list := func() (*http.Response, []byte, error) {
+		resp, err := http.Get(fmt.Sprintf("%s/list/%d/stream.test.", server.URL, info.ID))
+		if !assert.Equal(t, err, nil) {
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp, body, err
+	}
+
+	_, body, err := list()
+	assert.Equal(t, err, nil)
+	var result struct {
+		Result []interface{} `json:"result"`
+	}
+	assert.Equal(t, decodeStrict(body, &result), nil, string(body))
+	assert.Equal(t, len(result.Result), 5)
+
+	// the records read are sent, then the connection is dropped
+	faulty.Set(fault.Rule{Stmt: "list-query", PartialRate: 1, PartialRows: 2})
+	resp, body, err := list()
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), err)
+	assert.NotEqual(t, decodeStrict(body, &result), nil, string(body))
+}
+
+// decodeStrict unmarshals body into v, failing on an object repeating a
+// key where encoding/json keeps the last value.
+func decodeStrict(body []byte, v interface{}) error {
+	if err := uniqueKeys(json.NewDecoder(bytes.NewReader(body))); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func uniqueKeys(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		keys := make(map[string]bool)
+		for dec.More() {
+			if tok, err = dec.Token(); err != nil {
+				return err
+			}
+			key := tok.(string)
+			if keys[key] {
+				return fmt.Errorf("duplicate key %q", key)
+			}
+			keys[key] = true
+			if err = uniqueKeys(dec); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for dec.More() {
+			if err = uniqueKeys(dec); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+	return err
+}
@@ -1,5 +1,15 @@
 package handler
 
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/ivan-bokov/go-pdns/internal/service"
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
 type addDomainKeyForm struct {
 	Flags     int    `form:"flags"`
 	Active    bool   `form:"active"`
@@ -9,3 +19,59 @@ type addDomainKeyForm struct {
 
 type feedRecordForm struct {
 }
+
+var rrsetKey = regexp.MustCompile(`^rrset\[(\d+)\]\[(\w+)\]$`)
+
+// parseRRSet reads the records posted as rrset[N][field].
+func parseRRSet(form url.Values) ([]*service.DNSResourceRecord, error) {
+	fields := make(map[int]map[string]string)
+	for key, values := range form {
+		m := rrsetKey.FindStringSubmatch(key)
+		if m == nil || len(values) == 0 {
+			continue
+		}
+		i, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		if fields[i] == nil {
+			fields[i] = make(map[string]string)
+		}
+		fields[i][m[2]] = values[0]
+	}
+	indexes := make([]int, 0, len(fields))
+	for i := range fields {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	rrset := make([]*service.DNSResourceRecord, 0, len(indexes))
+	for _, i := range indexes {
+		m := fields[i]
+		ttl, err := strconv.Atoi(m["ttl"])
+		if err != nil {
+			return nil, stacktrace.Newf("rrset[%d]: %w", i, err)
+		}
+		var auth bool
+		if m["auth"] != "" {
+			if auth, err = strconv.ParseBool(m["auth"]); err != nil {
+				return nil, stacktrace.Newf("rrset[%d]: %w", i, err)
+			}
+		}
+		var prio int
+		if m["prio"] != "" {
+			if prio, err = strconv.Atoi(m["prio"]); err != nil {
+				return nil, stacktrace.Newf("rrset[%d]: %w", i, err)
+			}
+		}
+		rrset = append(rrset, &service.DNSResourceRecord{
+			Qname:   m["qname"],
+			Qtype:   m["qtype"],
+			Qclass:  m["qclass"],
+			Content: m["content"],
+			TTL:     ttl,
+			Auth:    auth,
+			Prio:    prio,
+		})
+	}
+	return rrset, nil
+}
@@ -0,0 +1,53 @@
+//go:build cgo
+// +build cgo
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+)
+
+func BenchmarkRecordWriter(b *testing.B) {
+	for _, size := range []int{10000, 1000000} {
+		b.Run(fmt.Sprintf("zone-%d", size), func(b *testing.B) {
+			stg := sqlite.New(filepath.Join(b.TempDir(), "bench.db"))
+			defer stg.Close()
+			if err := stg.CreateTable(); err != nil {
+				b.Fatal(err)
+			}
+			svc := New(stg, true)
+			ctx := context.Background()
+			if err := svc.CreateSlaveDomain(ctx, "10.0.0.1", MustParseDNSName("bench.test.")); err != nil {
+				b.Fatal(err)
+			}
+			info, err := svc.GetDomainInfo(ctx, MustParseDNSName("bench.test."))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			start := time.Now()
+			for n := 0; n < b.N; n++ {
+				w, err := svc.NewRecordWriter(ctx, info.ID)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for i := 0; i < size; i++ {
+					rr := &DNSResourceRecord{Qname: fmt.Sprintf("h%d.bench.test.", i), Qtype: "A", Content: "127.0.0.1", TTL: 300}
+					if err = w.Write(ctx, rr, ""); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err = w.Commit(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(size*b.N)/time.Since(start).Seconds(), "records/s")
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+const (
+	CheckError   = "error"
+	CheckWarning = "warning"
+)
+
+// CheckIssue is one problem CheckZone found, Type is empty when it is
+// about the zone or a name rather than an RRset.
+type CheckIssue struct {
+	Level   string `json:"level"`
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+}
+
+// CheckReport is the result of CheckZone, the zone is fine to serve when
+// it has no errors.
+type CheckReport struct {
+	Zone     string       `json:"zone"`
+	Records  int          `json:"records"`
+	Errors   int          `json:"errors"`
+	Warnings int          `json:"warnings"`
+	Issues   []CheckIssue `json:"issues"`
+}
+
+func (r *CheckReport) add(level string, name string, qtype string, format string, a ...interface{}) {
+	r.Issues = append(r.Issues, CheckIssue{Level: level, Name: name, Type: qtype, Message: fmt.Sprintf(format, a...)})
+	if level == CheckError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+}
+
+// checkedRRSet holds the enabled records of one RRset of the zone.
+type checkedRRSet struct {
+	name    DNSName
+	qtype   QType
+	records []*storage.Record
+	// contents are normalized, with the priority in front
+	contents []string
+}
+
+// CheckZone reads the enabled records of zone and reports what
+// pdnsutil check-zone would: SOA and apex NS, CNAME and other data, names
+// out of the zone, TTLs within RRsets, content, MX and SRV targets that
+// are CNAMEs, glue of in-zone NS and, with DNSSEC, keys and ordernames.
+// It changes nothing.
+func (s *Service) CheckZone(ctx context.Context, zone DNSName) (*CheckReport, error) {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.List)
+	defer cancel()
+	name := zone.Canonical()
+	r := s.repos()
+	id, err := r.Zones.ID(ctx, name.String())
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	report := &CheckReport{Zone: name.String(), Issues: make([]CheckIssue, 0)}
+	rrsets, err := readCheckedRRSets(ctx, r, id, name, report)
+	if err != nil {
+		return nil, err
+	}
+	checkApex(name, rrsets, report)
+	checkRRSets(name, rrsets, report)
+	if s.dnssec {
+		if err = checkDNSSEC(ctx, r, id, name, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// readCheckedRRSets groups the records of the zone into RRsets sorted in
+// canonical order, reporting the records that cannot be part of one.
+func readCheckedRRSets(ctx context.Context, r *storage.Repositories, id int, zone DNSName, report *CheckReport) ([]*checkedRRSet, error) {
+	it, err := r.Records.List(ctx, id, false)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	defer it.Close()
+	byKey := make(map[string]*checkedRRSet)
+	rrsets := make([]*checkedRRSet, 0)
+	for it.Next() {
+		rr := it.Record()
+		if rr.Type == "" {
+			// empty non-terminals are checked with the ordernames
+			continue
+		}
+		report.Records++
+		name, err := ParseDNSName(rr.Name)
+		if err != nil {
+			report.add(CheckError, rr.Name, rr.Type, "invalid name")
+			continue
+		}
+		name = name.Canonical()
+		if !name.IsPartOf(zone) {
+			report.add(CheckError, name.String(), rr.Type, "out of zone")
+			continue
+		}
+		qtype, err := ParseQType(rr.Type)
+		if err != nil || qtype.IsQueryOnly() {
+			report.add(CheckError, name.String(), rr.Type, "invalid type")
+			continue
+		}
+		if qtype.IsApexOnly() && !name.Equal(zone) {
+			report.add(CheckError, name.String(), qtype.String(), "only allowed at the apex")
+		}
+		content := rr.Content
+		if qtype.HasPriority() {
+			prio, rest, _ := storedPriority(qtype, rr)
+			content = strconv.Itoa(prio) + " " + rest
+		}
+		normalized, err := NormalizeContent(qtype, content)
+		var invalid *ContentError
+		if errors.As(err, &invalid) {
+			report.add(CheckError, name.String(), qtype.String(), "invalid content %q: %s", rr.Content, invalid.Reason)
+			continue
+		}
+		key := name.String() + " " + qtype.String()
+		rrset, ok := byKey[key]
+		if !ok {
+			rrset = &checkedRRSet{name: name, qtype: qtype}
+			byKey[key] = rrset
+			rrsets = append(rrsets, rrset)
+		}
+		rrset.records = append(rrset.records, rr)
+		rrset.contents = append(rrset.contents, normalized)
+	}
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(rrsets, func(i, j int) bool {
+		if c := rrsets[i].name.Compare(rrsets[j].name); c != 0 {
+			return c < 0
+		}
+		return rrsets[i].qtype < rrsets[j].qtype
+	})
+	return rrsets, nil
+}
+
+func checkApex(zone DNSName, rrsets []*checkedRRSet, report *CheckReport) {
+	soa, ns := 0, 0
+	for _, rrset := range rrsets {
+		if !rrset.name.Equal(zone) {
+			continue
+		}
+		switch rrset.qtype {
+		case TypeSOA:
+			soa = len(rrset.records)
+		case TypeNS:
+			ns = len(rrset.records)
+		}
+	}
+	switch {
+	case soa == 0:
+		report.add(CheckError, zone.String(), TypeSOA.String(), "no SOA at the apex")
+	case soa > 1:
+		report.add(CheckError, zone.String(), TypeSOA.String(), "%d SOA records at the apex", soa)
+	}
+	if ns == 0 {
+		report.add(CheckError, zone.String(), TypeNS.String(), "no NS at the apex")
+	}
+}
+
+func checkRRSets(zone DNSName, rrsets []*checkedRRSet, report *CheckReport) {
+	types := make(map[string]map[QType]bool)
+	for _, rrset := range rrsets {
+		key := rrset.name.String()
+		if types[key] == nil {
+			types[key] = make(map[QType]bool)
+		}
+		types[key][rrset.qtype] = true
+	}
+	for _, rrset := range rrsets {
+		name, qtype := rrset.name.String(), rrset.qtype.String()
+		for _, rr := range rrset.records[1:] {
+			if rr.TTL != rrset.records[0].TTL {
+				report.add(CheckWarning, name, qtype, "TTL mismatch in RRset, %d and %d", rrset.records[0].TTL, rr.TTL)
+				break
+			}
+		}
+		switch rrset.qtype {
+		case TypeCNAME:
+			if len(rrset.records) > 1 {
+				report.add(CheckError, name, qtype, "%d CNAME records", len(rrset.records))
+			}
+			for other := range types[name] {
+				if other != TypeCNAME && !other.IsDNSSECMeta() {
+					report.add(CheckError, name, qtype, "CNAME and other data")
+					break
+				}
+			}
+		case TypeMX, TypeSRV:
+			for _, content := range rrset.contents {
+				fields := strings.Fields(content)
+				target := fields[len(fields)-1]
+				if target != "." && types[target][TypeCNAME] {
+					report.add(CheckError, name, qtype, "target %s is a CNAME", target)
+				}
+			}
+		case TypeNS:
+			for _, target := range rrset.contents {
+				host, err := ParseDNSName(target)
+				if err != nil || !host.IsPartOf(zone) {
+					continue
+				}
+				if !types[target][TypeA] && !types[target][TypeAAAA] {
+					report.add(CheckError, name, qtype, "no glue for %s", target)
+				}
+			}
+		}
+	}
+}
+
+// checkDNSSEC wants an active KSK once the zone has keys and the
+// ordernames, auth flags and empty non-terminals RectifyZone would set.
+// They only break answers of signed zones, elsewhere they are warnings.
+func checkDNSSEC(ctx context.Context, r *storage.Repositories, id int, zone DNSName, report *CheckReport) error {
+	keys, err := r.Keys.List(ctx, zone.String())
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return stacktrace.Wrap(err)
+	}
+	level := CheckWarning
+	if len(keys) > 0 {
+		level = CheckError
+		ksk := false
+		for _, key := range keys {
+			ksk = ksk || key.Active && key.Flags&1 == 1
+		}
+		if !ksk {
+			report.add(CheckError, zone.String(), "", "no active KSK")
+		}
+	}
+	z, err := newDNSSECZone(ctx, r, id, zone)
+	if err != nil {
+		return err
+	}
+	rrsets, ents, err := readRectifiedNames(ctx, r, z)
+	if err != nil {
+		return err
+	}
+	for _, n := range rrsets {
+		want := &storage.Record{Name: n.name.String(), Type: n.qtype}
+		if err = z.rectify(ctx, r, want); err != nil {
+			return err
+		}
+		switch {
+		case n.orderName != "" && want.OrderName == "":
+			report.add(level, want.Name, want.Type, "orphan ordername %q", n.orderName)
+		case n.mixed || n.orderName != want.OrderName || n.auth != want.Auth:
+			report.add(level, want.Name, want.Type, "ordername or auth not rectified, want %q auth=%t", want.OrderName, want.Auth)
+		}
+	}
+	for _, name := range emptyNonTerminals(z, rrsets) {
+		key := name.String()
+		want := &storage.Record{Name: key}
+		if err = z.rectify(ctx, r, want); err != nil {
+			return err
+		}
+		found, ok := ents[key]
+		delete(ents, key)
+		switch {
+		case !ok:
+			report.add(level, key, "", "missing empty non-terminal")
+		case found.mixed || found.orderName != want.OrderName || found.auth != want.Auth:
+			report.add(level, key, "", "ordername or auth not rectified, want %q auth=%t", want.OrderName, want.Auth)
+		}
+	}
+	stale := make([]string, 0, len(ents))
+	for key := range ents {
+		stale = append(stale, key)
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		report.add(level, key, "", "orphan empty non-terminal")
+	}
+	return nil
+}
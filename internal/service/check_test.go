@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_CheckZone(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	r := store.Repositories()
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 1, Name: "check.test.", Kind: "NATIVE"}), nil)
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 2, Name: "bad.test.", Kind: "NATIVE"}), nil)
+	s := New(nil, true, WithStore(store))
+
+	assert.Equal(t, s.StartTransaction(ctx, 1, 1, MustParseDNSName("check.test.")), nil)
+	for _, rr := range []*DNSResourceRecord{
+		{Qname: "check.test.", Qtype: "SOA", Content: "ns.check.test. h.check.test. 1 2 3 4 5"},
+		{Qname: "check.test.", Qtype: "NS", Content: "ns.check.test."},
+		{Qname: "check.test.", Qtype: "MX", Content: "10 mail.check.test."},
+		{Qname: "ns.check.test.", Qtype: "A", Content: "192.0.2.1"},
+		{Qname: "mail.check.test.", Qtype: "A", Content: "192.0.2.2"},
+		{Qname: "a.b.check.test.", Qtype: "TXT", Content: "\"x\""},
+	} {
+		rr.TTL = 300
+		assert.Equal(t, s.FeedTransactionRecord(ctx, 1, rr, ""), nil, rr.Qname)
+	}
+	assert.Equal(t, s.CommitTransaction(ctx, 1), nil)
+	_, err := s.RectifyZone(ctx, MustParseDNSName("check.test."))
+	assert.Equal(t, err, nil)
+
+	report, err := s.CheckZone(ctx, MustParseDNSName("check.test."))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Records, 6)
+	assert.Equal(t, report.Issues, []CheckIssue{})
+
+	// written by hand, as with SQL
+	_, err = r.Records.Insert(ctx,
+		&storage.Record{DomainID: 2, Name: "bad.test.", Type: "SOA", Content: "ns.bad.test. h.bad.test. 1 2 3 4 5", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "bad.test.", Type: "SOA", Content: "ns.bad.test. h.bad.test. 2 2 3 4 5", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "bad.test.", Type: "MX", Content: "mail.bad.test.", Prio: 10, TTL: 300},
+		&storage.Record{DomainID: 2, Name: "mail.bad.test.", Type: "CNAME", Content: "www.bad.test.", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "mail.bad.test.", Type: "A", Content: "192.0.2.1", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "www.bad.test.", Type: "A", Content: "192.0.2.1", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "www.bad.test.", Type: "A", Content: "192.0.2.300", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "www.bad.test.", Type: "A", Content: "192.0.2.3", TTL: 60},
+		&storage.Record{DomainID: 2, Name: "sub.bad.test.", Type: "NS", Content: "ns.sub.bad.test.", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "sub.bad.test.", Type: "NS", Content: "ns2.sub.bad.test.", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "ns.sub.bad.test.", Type: "AAAA", Content: "2001:db8::1", TTL: 300, OrderName: "sub ns", Auth: true},
+		&storage.Record{DomainID: 2, Name: "www.other.test.", Type: "A", Content: "192.0.2.1", TTL: 300},
+	)
+	assert.Equal(t, err, nil)
+	_, err = r.Keys.Add(ctx, "bad.test.", &storage.Key{Flags: 257, Content: "key"})
+	assert.Equal(t, err, nil)
+
+	report, err = s.CheckZone(ctx, MustParseDNSName("bad.test."))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Records, 12)
+	messages := make(map[string]string)
+	for _, issue := range report.Issues {
+		messages[issue.Name+" "+issue.Type+" "+issue.Message] = issue.Level
+	}
+	for message, level := range map[string]string{
+		"www.other.test. A out of zone":                                                           CheckError,
+		"bad.test. SOA 2 SOA records at the apex":                                                 CheckError,
+		"bad.test. NS no NS at the apex":                                                          CheckError,
+		"bad.test. MX target mail.bad.test. is a CNAME":                                           CheckError,
+		"mail.bad.test. CNAME CNAME and other data":                                               CheckError,
+		"www.bad.test. A TTL mismatch in RRset, 300 and 60":                                       CheckWarning,
+		"sub.bad.test. NS no glue for ns2.sub.bad.test.":                                          CheckError,
+		"bad.test.  no active KSK":                                                                CheckError,
+		"ns.sub.bad.test. AAAA orphan ordername \"sub ns\"":                                       CheckError,
+		"www.bad.test. A invalid content \"192.0.2.300\": \"192.0.2.300\" is not an IPv4 address": CheckError,
+	} {
+		assert.Equal(t, messages[message], level, message)
+	}
+	_, ok := messages["sub.bad.test. NS no glue for ns.sub.bad.test."]
+	assert.False(t, ok)
+	assert.Equal(t, report.Errors+report.Warnings, len(report.Issues))
+
+	_, err = s.CheckZone(ctx, MustParseDNSName("missing.test."))
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,636 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// ContentError tells why content is not valid for its type.
+type ContentError struct {
+	Type    QType
+	Content string
+	Reason  string
+}
+
+func (e *ContentError) Error() string {
+	return fmt.Sprintf("invalid %s content %q: %s", e.Type, e.Content, e.Reason)
+}
+
+// contentCodec validates content split into fields and returns it in
+// presentation format.
+type contentCodec func(fields []string) (string, error)
+
+var contentCodecs = map[QType]contentCodec{
+	TypeA:       codecA,
+	TypeAAAA:    codecAAAA,
+	TypeCNAME:   codecName,
+	TypeNS:      codecName,
+	TypePTR:     codecName,
+	TypeDNAME:   codecName,
+	TypeALIAS:   codecName,
+	TypeMX:      codecMX,
+	TypeSRV:     codecSRV,
+	TypeTXT:     codecTXT,
+	TypeSPF:     codecTXT,
+	TypeSOA:     codecSOA,
+	TypeCAA:     codecCAA,
+	TypeDS:      codecDS,
+	TypeCDS:     codecDS,
+	TypeDNSKEY:  codecDNSKEY,
+	TypeCDNSKEY: codecDNSKEY,
+	TypeTLSA:    codecTLSA,
+	TypeSSHFP:   codecSSHFP,
+	TypeSVCB:    codecSVCB,
+	TypeHTTPS:   codecSVCB,
+	TypeNAPTR:   codecNAPTR,
+	TypeLOC:     codecLOC,
+}
+
+// NormalizeContent validates content of qtype and returns it the way
+// PowerDNS presents it: lowercase fully qualified names, canonical
+// addresses, quoted strings. Content of types without a codec is kept.
+func NormalizeContent(qtype QType, content string) (string, error) {
+	codec, ok := contentCodecs[qtype]
+	if !ok {
+		return content, nil
+	}
+	fields, err := splitFields(content)
+	if err == nil {
+		var normalized string
+		if normalized, err = codec(fields); err == nil {
+			return normalized, nil
+		}
+	}
+	return "", stacktrace.Wrap(&ContentError{Type: qtype, Content: content, Reason: err.Error()})
+}
+
+// splitFields splits content at blanks outside double quotes.
+func splitFields(content string) ([]string, error) {
+	fields := make([]string, 0, 8)
+	var b strings.Builder
+	quoted, escaped := false, false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			if b.Len() > 0 {
+				fields = append(fields, b.String())
+				b.Reset()
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if quoted {
+		return nil, errors.New("unterminated quoted string")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if b.Len() > 0 {
+		fields = append(fields, b.String())
+	}
+	return fields, nil
+}
+
+func want(fields []string, n int, layout string) error {
+	if len(fields) != n {
+		return fmt.Errorf("want %d fields (%s), got %d", n, layout, len(fields))
+	}
+	return nil
+}
+
+func uintField(s string, bits int, what string) (uint64, error) {
+	v, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a %d-bit unsigned number", what, s, bits)
+	}
+	return v, nil
+}
+
+func nameField(s string, what string) (string, error) {
+	name, err := ParseDNSName(s)
+	if err != nil {
+		return "", fmt.Errorf("%s %q is not a domain name", what, s)
+	}
+	return name.Canonical().String(), nil
+}
+
+func hexField(s string, what string) (string, error) {
+	if _, err := hex.DecodeString(s); err != nil || s == "" {
+		return "", fmt.Errorf("%s is not hexadecimal", what)
+	}
+	return strings.ToLower(s), nil
+}
+
+// characterString returns s quoted, it may come quoted or as a bare word.
+// Escapes are kept, \DDD counts as one octet of at most 255.
+func characterString(s string) (string, error) {
+	inner := s
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		inner = s[1 : len(s)-1]
+	}
+	octets := 0
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' {
+			switch {
+			case i+1 == len(inner):
+				return "", fmt.Errorf("bad escape in %s", s)
+			case isDigit(inner[i+1]):
+				if i+3 >= len(inner) || !isDigit(inner[i+2]) || !isDigit(inner[i+3]) {
+					return "", fmt.Errorf("bad escape in %s", s)
+				}
+				if v, _ := strconv.Atoi(inner[i+1 : i+4]); v > 255 {
+					return "", fmt.Errorf("bad escape in %s", s)
+				}
+				i += 3
+			default:
+				i++
+			}
+		} else if inner[i] == '"' {
+			return "", fmt.Errorf("unescaped quote in %s", s)
+		}
+		octets++
+	}
+	if octets > 255 {
+		return "", fmt.Errorf("string of %d octets is longer than 255", octets)
+	}
+	return `"` + inner + `"`, nil
+}
+
+func codecA(fields []string) (string, error) {
+	if err := want(fields, 1, "address"); err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(fields[0])
+	if ip == nil || ip.To4() == nil || strings.Contains(fields[0], ":") {
+		return "", fmt.Errorf("%q is not an IPv4 address", fields[0])
+	}
+	return ip.To4().String(), nil
+}
+
+func ipv6(s string) (string, error) {
+	ip := net.ParseIP(s)
+	if ip == nil || !strings.Contains(s, ":") {
+		return "", fmt.Errorf("%q is not an IPv6 address", s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return "::ffff:" + v4.String(), nil
+	}
+	return ip.String(), nil
+}
+
+func codecAAAA(fields []string) (string, error) {
+	if err := want(fields, 1, "address"); err != nil {
+		return "", err
+	}
+	return ipv6(fields[0])
+}
+
+func codecName(fields []string) (string, error) {
+	if err := want(fields, 1, "target"); err != nil {
+		return "", err
+	}
+	return nameField(fields[0], "target")
+}
+
+func codecMX(fields []string) (string, error) {
+	if err := want(fields, 2, "preference exchange"); err != nil {
+		return "", err
+	}
+	pref, err := uintField(fields[0], 16, "preference")
+	if err != nil {
+		return "", err
+	}
+	exchange, err := nameField(fields[1], "exchange")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %s", pref, exchange), nil
+}
+
+func codecSRV(fields []string) (string, error) {
+	if err := want(fields, 4, "priority weight port target"); err != nil {
+		return "", err
+	}
+	nums := make([]uint64, 3)
+	for i, what := range []string{"priority", "weight", "port"} {
+		v, err := uintField(fields[i], 16, what)
+		if err != nil {
+			return "", err
+		}
+		nums[i] = v
+	}
+	target, err := nameField(fields[3], "target")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %d %d %s", nums[0], nums[1], nums[2], target), nil
+}
+
+func codecTXT(fields []string) (string, error) {
+	if len(fields) == 0 {
+		return "", errors.New("no strings")
+	}
+	out := make([]string, 0, len(fields))
+	for _, f := range fields {
+		s, err := characterString(f)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, s)
+	}
+	return strings.Join(out, " "), nil
+}
+
+func codecSOA(fields []string) (string, error) {
+	if err := want(fields, 7, "mname rname serial refresh retry expire minimum"); err != nil {
+		return "", err
+	}
+	mname, err := nameField(fields[0], "mname")
+	if err != nil {
+		return "", err
+	}
+	rname, err := nameField(fields[1], "rname")
+	if err != nil {
+		return "", err
+	}
+	out := []string{mname, rname}
+	for i, what := range []string{"serial", "refresh", "retry", "expire", "minimum"} {
+		v, err := uintField(fields[i+2], 32, what)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, strconv.FormatUint(v, 10))
+	}
+	return strings.Join(out, " "), nil
+}
+
+func codecCAA(fields []string) (string, error) {
+	if err := want(fields, 3, "flags tag value"); err != nil {
+		return "", err
+	}
+	flags, err := uintField(fields[0], 8, "flags")
+	if err != nil {
+		return "", err
+	}
+	tag := strings.ToLower(fields[1])
+	if tag == "" || len(tag) > 15 || strings.Trim(tag, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+		return "", fmt.Errorf("tag %q is not alphanumeric", fields[1])
+	}
+	value, err := characterString(fields[2])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %s %s", flags, tag, value), nil
+}
+
+// dsDigestSizes are the digest lengths of the known digest types.
+var dsDigestSizes = map[uint64]int{1: 20, 2: 32, 4: 48}
+
+func codecDS(fields []string) (string, error) {
+	if len(fields) < 4 {
+		return "", want(fields, 4, "keytag algorithm digesttype digest")
+	}
+	tag, err := uintField(fields[0], 16, "key tag")
+	if err != nil {
+		return "", err
+	}
+	alg, err := uintField(fields[1], 8, "algorithm")
+	if err != nil {
+		return "", err
+	}
+	digestType, err := uintField(fields[2], 8, "digest type")
+	if err != nil {
+		return "", err
+	}
+	digest, err := hexField(strings.Join(fields[3:], ""), "digest")
+	if err != nil {
+		return "", err
+	}
+	if size, ok := dsDigestSizes[digestType]; ok && len(digest) != size*2 {
+		return "", fmt.Errorf("digest type %d wants %d octets, got %d", digestType, size, len(digest)/2)
+	}
+	return fmt.Sprintf("%d %d %d %s", tag, alg, digestType, digest), nil
+}
+
+func codecDNSKEY(fields []string) (string, error) {
+	if len(fields) < 4 {
+		return "", want(fields, 4, "flags protocol algorithm key")
+	}
+	flags, err := uintField(fields[0], 16, "flags")
+	if err != nil {
+		return "", err
+	}
+	if fields[1] != "3" {
+		return "", fmt.Errorf("protocol %q is not 3", fields[1])
+	}
+	alg, err := uintField(fields[2], 8, "algorithm")
+	if err != nil {
+		return "", err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil || len(key) == 0 {
+		return "", errors.New("public key is not base64")
+	}
+	return fmt.Sprintf("%d 3 %d %s", flags, alg, base64.StdEncoding.EncodeToString(key)), nil
+}
+
+func codecTLSA(fields []string) (string, error) {
+	if len(fields) < 4 {
+		return "", want(fields, 4, "usage selector type data")
+	}
+	nums := make([]uint64, 3)
+	for i, what := range []string{"usage", "selector", "matching type"} {
+		v, err := uintField(fields[i], 8, what)
+		if err != nil {
+			return "", err
+		}
+		nums[i] = v
+	}
+	data, err := hexField(strings.Join(fields[3:], ""), "certificate data")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %d %d %s", nums[0], nums[1], nums[2], data), nil
+}
+
+func codecSSHFP(fields []string) (string, error) {
+	if len(fields) < 3 {
+		return "", want(fields, 3, "algorithm type fingerprint")
+	}
+	alg, err := uintField(fields[0], 8, "algorithm")
+	if err != nil {
+		return "", err
+	}
+	fpType, err := uintField(fields[1], 8, "fingerprint type")
+	if err != nil {
+		return "", err
+	}
+	fp, err := hexField(strings.Join(fields[2:], ""), "fingerprint")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %d %s", alg, fpType, fp), nil
+}
+
+func codecNAPTR(fields []string) (string, error) {
+	if err := want(fields, 6, `order preference "flags" "service" "regexp" replacement`); err != nil {
+		return "", err
+	}
+	order, err := uintField(fields[0], 16, "order")
+	if err != nil {
+		return "", err
+	}
+	pref, err := uintField(fields[1], 16, "preference")
+	if err != nil {
+		return "", err
+	}
+	out := []string{strconv.FormatUint(order, 10), strconv.FormatUint(pref, 10)}
+	for _, f := range fields[2:5] {
+		s, err := characterString(f)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, s)
+	}
+	replacement, err := nameField(fields[5], "replacement")
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append(out, replacement), " "), nil
+}
+
+var svcParamKeys = map[string]int{
+	"mandatory":       0,
+	"alpn":            1,
+	"no-default-alpn": 2,
+	"port":            3,
+	"ipv4hint":        4,
+	"ech":             5,
+	"ipv6hint":        6,
+	"dohpath":         7,
+	"ohttp":           8,
+}
+
+func svcParamKey(s string) (int, string, error) {
+	key := strings.ToLower(s)
+	if n, ok := svcParamKeys[key]; ok {
+		return n, key, nil
+	}
+	if strings.HasPrefix(key, "key") {
+		if n, err := strconv.ParseUint(key[3:], 10, 16); err == nil {
+			for name, known := range svcParamKeys {
+				if known == int(n) {
+					return known, name, nil
+				}
+			}
+			return int(n), "key" + strconv.FormatUint(n, 10), nil
+		}
+	}
+	return 0, "", fmt.Errorf("unknown parameter %q", s)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// svcParam checks the value of the parameter, the result is key=value.
+func svcParam(n int, key string, value string, hasValue bool) (string, error) {
+	if n == 2 {
+		if hasValue {
+			return "", errors.New("no-default-alpn takes no value")
+		}
+		return key, nil
+	}
+	if !hasValue || value == "" {
+		if n <= 8 {
+			return "", fmt.Errorf("%s needs a value", key)
+		}
+		return key, nil
+	}
+	var items []string
+	switch n {
+	case 0:
+		for _, item := range strings.Split(value, ",") {
+			_, name, err := svcParamKey(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, name)
+		}
+	case 1:
+		for _, item := range strings.Split(value, ",") {
+			if item == "" {
+				return "", errors.New("empty alpn protocol")
+			}
+			items = append(items, item)
+		}
+	case 3:
+		if _, err := uintField(value, 16, "port"); err != nil {
+			return "", err
+		}
+		items = []string{value}
+	case 4:
+		for _, item := range strings.Split(value, ",") {
+			a, err := codecA([]string{item})
+			if err != nil {
+				return "", err
+			}
+			items = append(items, a)
+		}
+	case 5:
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+			return "", errors.New("ech is not base64")
+		}
+		items = []string{value}
+	case 6:
+		for _, item := range strings.Split(value, ",") {
+			a, err := ipv6(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, a)
+		}
+	default:
+		return key + `="` + value + `"`, nil
+	}
+	return key + "=" + strings.Join(items, ","), nil
+}
+
+func codecSVCB(fields []string) (string, error) {
+	if len(fields) < 2 {
+		return "", want(fields, 2, "priority target params...")
+	}
+	prio, err := uintField(fields[0], 16, "priority")
+	if err != nil {
+		return "", err
+	}
+	target, err := nameField(fields[1], "target")
+	if err != nil {
+		return "", err
+	}
+	if prio == 0 && len(fields) > 2 {
+		return "", errors.New("alias form (priority 0) takes no parameters")
+	}
+	type param struct {
+		n    int
+		text string
+	}
+	params := make([]param, 0, len(fields)-2)
+	seen := make(map[int]bool)
+	for _, f := range fields[2:] {
+		key, value, hasValue := f, "", false
+		if i := strings.IndexByte(f, '='); i >= 0 {
+			key, value, hasValue = f[:i], f[i+1:], true
+		}
+		n, name, err := svcParamKey(key)
+		if err != nil {
+			return "", err
+		}
+		if seen[n] {
+			return "", fmt.Errorf("duplicate parameter %s", name)
+		}
+		seen[n] = true
+		text, err := svcParam(n, name, unquote(value), hasValue)
+		if err != nil {
+			return "", err
+		}
+		params = append(params, param{n: n, text: text})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].n < params[j].n
+	})
+	out := []string{strconv.FormatUint(prio, 10), target}
+	for _, p := range params {
+		out = append(out, p.text)
+	}
+	return strings.Join(out, " "), nil
+}
+
+// locMeters reads an altitude or size with an optional m suffix.
+func locMeters(s string, what string, min, max float64) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(s), "m"), 64)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%s %q is not a number of meters in [%.2f, %.2f]", what, s, min, max)
+	}
+	return v, nil
+}
+
+// locAngle reads "d [m [s]] hemisphere" from fields, returning the rest.
+func locAngle(fields []string, maxDeg uint64, hemispheres string) (uint64, uint64, float64, byte, []string, error) {
+	var deg, min uint64
+	var sec float64
+	var err error
+	if len(fields) == 0 {
+		return 0, 0, 0, 0, nil, errors.New("missing coordinate")
+	}
+	if deg, err = uintField(fields[0], 8, "degrees"); err != nil || deg > maxDeg {
+		return 0, 0, 0, 0, nil, fmt.Errorf("degrees %q out of [0, %d]", fields[0], maxDeg)
+	}
+	i := 1
+	if i < len(fields) && !strings.ContainsAny(strings.ToUpper(fields[i]), hemispheres) {
+		if min, err = uintField(fields[i], 8, "minutes"); err != nil || min > 59 {
+			return 0, 0, 0, 0, nil, fmt.Errorf("minutes %q out of [0, 59]", fields[i])
+		}
+		i++
+		if i < len(fields) && !strings.ContainsAny(strings.ToUpper(fields[i]), hemispheres) {
+			if sec, err = strconv.ParseFloat(fields[i], 64); err != nil || sec < 0 || sec >= 60 {
+				return 0, 0, 0, 0, nil, fmt.Errorf("seconds %q out of [0, 60)", fields[i])
+			}
+			i++
+		}
+	}
+	if i >= len(fields) || len(fields[i]) != 1 || !strings.Contains(hemispheres, strings.ToUpper(fields[i])) {
+		return 0, 0, 0, 0, nil, fmt.Errorf("want hemisphere %s", strings.Join(strings.Split(hemispheres, ""), " or "))
+	}
+	if deg == maxDeg && (min > 0 || sec > 0) {
+		return 0, 0, 0, 0, nil, fmt.Errorf("coordinate beyond %d degrees", maxDeg)
+	}
+	return deg, min, sec, strings.ToUpper(fields[i])[0], fields[i+1:], nil
+}
+
+func codecLOC(fields []string) (string, error) {
+	latD, latM, latS, latH, rest, err := locAngle(fields, 90, "NS")
+	if err != nil {
+		return "", err
+	}
+	lonD, lonM, lonS, lonH, rest, err := locAngle(rest, 180, "EW")
+	if err != nil {
+		return "", err
+	}
+	if len(rest) == 0 || len(rest) > 4 {
+		return "", errors.New("want altitude and at most size, horizontal and vertical precision")
+	}
+	alt, err := locMeters(rest[0], "altitude", -100000, 42849672.95)
+	if err != nil {
+		return "", err
+	}
+	sizes := []float64{1, 10000, 10}
+	for i, what := range []string{"size", "horizontal precision", "vertical precision"} {
+		if i+1 < len(rest) {
+			if sizes[i], err = locMeters(rest[i+1], what, 0, 90000000); err != nil {
+				return "", err
+			}
+		}
+	}
+	return fmt.Sprintf("%d %d %.3f %c %d %d %.3f %c %.2fm %.2fm %.2fm %.2fm",
+		latD, latM, latS, latH, lonD, lonM, lonS, lonH, alt, sizes[0], sizes[1], sizes[2]), nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeContent(t *testing.T) {
+	for _, tc := range []struct {
+		qtype   QType
+		in, out string
+	}{
+		{TypeA, "192.0.2.1", "192.0.2.1"},
+		{TypeAAAA, "2001:DB8:0:0::1", "2001:db8::1"},
+		{TypeAAAA, "::FFFF:192.0.2.1", "::ffff:192.0.2.1"},
+		{TypeCNAME, "Www.Example.COM", "www.example.com."},
+		{TypeNS, "ns1.example.com.", "ns1.example.com."},
+		{TypePTR, "host.example.com", "host.example.com."},
+		{TypeMX, "10  Mail.Example.com", "10 mail.example.com."},
+		{TypeMX, "0 .", "0 ."},
+		{TypeSRV, "10 20 5060 SIP.example.com", "10 20 5060 sip.example.com."},
+		{TypeTXT, `"v=spf1 -all"`, `"v=spf1 -all"`},
+		{TypeTXT, `hello "big world" \"x`, `"hello" "big world" "\"x"`},
+		{TypeSOA, "NS1.example.com Hostmaster.example.com 1 7200 3600 1209600 300",
+			"ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"},
+		{TypeCAA, `0 ISSUE "letsencrypt.org"`, `0 issue "letsencrypt.org"`},
+		{TypeCAA, "128 iodef mailto:a@example.com", `128 iodef "mailto:a@example.com"`},
+		{TypeDS, "2371 13 2 1F987CC6583E9299 0C8F2A1C3A4CF3A4F1B1A5D7C0A0B3F0E9D8C7B6A5F4E3D2",
+			"2371 13 2 1f987cc6583e92990c8f2a1c3a4cf3a4f1b1a5d7c0a0b3f0e9d8c7b6a5f4e3d2"},
+		{TypeDNSKEY, "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0d xCjjnopKl+GqJxpVXckHAeF+KkxLbxIL fDLUT0rAK9iUzy1L53eKGQ==",
+			"257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ=="},
+		{TypeTLSA, "3 1 1 ABCDEF0123", "3 1 1 abcdef0123"},
+		{TypeSSHFP, "4 2 AB cd", "4 2 abcd"},
+		{TypeNAPTR, `100 10 "S" "SIP+D2U" "" _sip._udp.Example.com`, `100 10 "S" "SIP+D2U" "" _sip._udp.example.com.`},
+		{TypeHTTPS, "1 . ipv6hint=2001:DB8::0001 ALPN=\"h2,h3\" port=443",
+			"1 . alpn=h2,h3 port=443 ipv6hint=2001:db8::1"},
+		{TypeSVCB, "0 Svc.example.com", "0 svc.example.com."},
+		{TypeSVCB, "1 svc.example.com key3=8443 no-default-alpn key1=h2",
+			"1 svc.example.com. alpn=h2 no-default-alpn port=8443"},
+		{TypeLOC, "51 59 N 4 30 E 10m", "51 59 0.000 N 4 30 0.000 E 10.00m 1.00m 10000.00m 10.00m"},
+		{TypeLOC, "42 21 54.5 n 71 6 18 w -24m 30m", "42 21 54.500 N 71 6 18.000 W -24.00m 30.00m 10000.00m 10.00m"},
+		{QType(13), "verbatim  Content", "verbatim  Content"},
+	} {
+		out, err := NormalizeContent(tc.qtype, tc.in)
+		assert.Equal(t, err, nil, tc.in)
+		assert.Equal(t, out, tc.out, tc.in)
+	}
+}
+
+func TestNormalizeContent_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		qtype QType
+		in    string
+	}{
+		{TypeA, "2001:db8::1"},
+		{TypeA, "192.0.2.256"},
+		{TypeA, "192.0.2.1 192.0.2.2"},
+		{TypeAAAA, "192.0.2.1"},
+		{TypeCNAME, "a..example.com"},
+		{TypeCNAME, ""},
+		{TypeMX, "mail.example.com"},
+		{TypeMX, "65536 mail.example.com"},
+		{TypeSRV, "10 20 mail.example.com"},
+		{TypeTXT, `"unterminated`},
+		{TypeTXT, `"\256"`},
+		{TypeSOA, "ns1.example.com. hostmaster.example.com. 1 2 3 4"},
+		{TypeSOA, "ns1.example.com. hostmaster.example.com. 4294967296 2 3 4 5"},
+		{TypeCAA, `0 is-sue "x"`},
+		{TypeDS, "2371 13 2 1f98"},
+		{TypeDS, "2371 13 2 zz"},
+		{TypeDNSKEY, "257 4 13 AAAA"},
+		{TypeDNSKEY, "257 3 13 !!!"},
+		{TypeTLSA, "3 1 1"},
+		{TypeNAPTR, `100 10 "S" "SIP+D2U" ""`},
+		{TypeHTTPS, "0 . alpn=h2"},
+		{TypeHTTPS, "1 . port=http"},
+		{TypeHTTPS, "1 . port=1 port=2"},
+		{TypeHTTPS, "1 . foo=bar"},
+		{TypeLOC, "91 0 N 4 30 E 10m"},
+		{TypeLOC, "51 59 N 4 30 X 10m"},
+		{TypeLOC, "51 59 N 4 30 E"},
+	} {
+		_, err := NormalizeContent(tc.qtype, tc.in)
+		var invalid *ContentError
+		if assert.True(t, errors.As(err, &invalid), tc.in) {
+			assert.Equal(t, invalid.Type, tc.qtype)
+		}
+	}
+}
+
+func TestService_FeedRecordNormalizesContent(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, service.CreateSlaveDomain(ctx, "10.0.0.6", MustParseDNSName("content.test.")), nil)
+	info, err := service.GetDomainInfo(ctx, MustParseDNSName("content.test."))
+	assert.Equal(t, err, nil)
+	rr := &DNSResourceRecord{DomainID: info.ID, Qname: "Alias.Content.test", Qtype: "cname", Content: "Target.Content.TEST", TTL: 60}
+	assert.Equal(t, service.FeedRecord(ctx, rr, ""), nil)
+	rrs, err := service.Lookup(ctx, TypeCNAME, MustParseDNSName("alias.content.test."), info.ID)
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(rrs), 1) {
+		assert.Equal(t, rrs[0].Content, "target.content.test.")
+	}
+
+	// the record of the caller is left as it is, a retry feeds it again
+	mx := &DNSResourceRecord{DomainID: info.ID, Qname: "Content.test", Qtype: "mx", Content: "Mail.Content.TEST", TTL: 60, Prio: 10}
+	fed := *mx
+	assert.Equal(t, service.FeedRecord(ctx, mx, ""), nil)
+	assert.Equal(t, *mx, fed)
+	rrs, err = service.Lookup(ctx, TypeMX, MustParseDNSName("content.test."), info.ID)
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(rrs), 1) {
+		assert.Equal(t, rrs[0].Content, "10 mail.content.test.")
+	}
+
+	bad := &DNSResourceRecord{DomainID: info.ID, Qname: "a.content.test", Qtype: "A", Content: "not-an-ip", TTL: 60}
+	var invalid *ContentError
+	assert.True(t, errors.As(service.FeedRecord(ctx, bad, ""), &invalid))
+}
@@ -1,9 +1,5 @@
 package service
 
-type DNSName struct {
-	name string
-}
-
 type DNSPacket struct {
 }
 
@@ -27,10 +23,17 @@ type ComboAddress struct {
 }
 
 type KeyData struct {
-	Flags     int
-	Active    bool
-	Published bool
-	Content   string
+	ID        int    `json:"id"`
+	Flags     int    `json:"flags"`
+	Active    bool   `json:"active"`
+	Published bool   `json:"published"`
+	Content   string `json:"content"`
+}
+
+type TSIGKey struct {
+	Name      string `json:"name,omitempty"`
+	Algorithm string `json:"algorithm"`
+	Content   string `json:"content"`
 }
 
 type DomainInfo struct {
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+const (
+	maxLabelLength = 63
+	maxNameLength  = 255
+)
+
+// DNSName is an absolute domain name, or a name relative to a zone as made
+// by MakeRelative. Labels are kept unescaped and in their original case,
+// comparisons ignore ASCII case. The zero value is the root.
+type DNSName struct {
+	labels   []string
+	relative bool
+}
+
+// ParseDNSName reads a name in presentation format with \X and \DDD
+// escapes. The trailing dot is optional, every name is absolute.
+func ParseDNSName(s string) (DNSName, error) {
+	if s == "" {
+		return DNSName{}, stacktrace.New("Empty DNS name")
+	}
+	if s == "." {
+		return DNSName{}, nil
+	}
+	labels := make([]string, 0, strings.Count(s, ".")+1)
+	label := make([]byte, 0, maxLabelLength)
+	length := 1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.':
+			if len(label) == 0 {
+				return DNSName{}, stacktrace.Newf("Empty label in %q", s)
+			}
+			labels = append(labels, string(label))
+			length += len(label) + 1
+			label = label[:0]
+			continue
+		case c == '\\' && i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]):
+			v, _ := strconv.Atoi(s[i+1 : i+4])
+			if v > 255 {
+				return DNSName{}, stacktrace.Newf("Bad escape \\%s in %q", s[i+1:i+4], s)
+			}
+			c = byte(v)
+			i += 3
+		case c == '\\':
+			if i+1 == len(s) || isDigit(s[i+1]) {
+				return DNSName{}, stacktrace.Newf("Bad escape in %q", s)
+			}
+			c = s[i+1]
+			i++
+		}
+		if len(label) == maxLabelLength {
+			return DNSName{}, stacktrace.Newf("Label longer than %d octets in %q", maxLabelLength, s)
+		}
+		label = append(label, c)
+	}
+	if len(label) > 0 {
+		labels = append(labels, string(label))
+		length += len(label) + 1
+	}
+	if length > maxNameLength {
+		return DNSName{}, stacktrace.Newf("Name longer than %d octets: %q", maxNameLength, s)
+	}
+	return DNSName{labels: labels}, nil
+}
+
+// MustParseDNSName is ParseDNSName for names known to be valid.
+func MustParseDNSName(s string) DNSName {
+	n, err := ParseDNSName(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// String returns the presentation format, absolute names with the trailing
+// dot. Dots and backslashes in labels are escaped, bytes out of the
+// printable ASCII range are written as \DDD.
+func (n DNSName) String() string {
+	if len(n.labels) == 0 {
+		if n.relative {
+			return ""
+		}
+		return "."
+	}
+	var b strings.Builder
+	for i, label := range n.labels {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		writeLabel(&b, label)
+	}
+	if !n.relative {
+		b.WriteByte('.')
+	}
+	return b.String()
+}
+
+func writeLabel(b *strings.Builder, label string) {
+	for j := 0; j < len(label); j++ {
+		c := label[j]
+		switch {
+		case c == '.' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c > 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(b, "\\%03d", c)
+		}
+	}
+}
+
+// Canonical returns n in lowercase, the form names are stored and
+// looked up in.
+func (n DNSName) Canonical() DNSName {
+	labels := make([]string, len(n.labels))
+	for i, label := range n.labels {
+		labels[i] = toLowerASCII(label)
+	}
+	return DNSName{labels: labels, relative: n.relative}
+}
+
+func toLowerASCII(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 'A' && s[i] <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				if b[j] >= 'A' && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+func (n DNSName) IsRoot() bool {
+	return len(n.labels) == 0 && !n.relative
+}
+
+func (n DNSName) IsRelative() bool {
+	return n.relative
+}
+
+func (n DNSName) CountLabels() int {
+	return len(n.labels)
+}
+
+// Labels returns the unescaped labels, leftmost first.
+func (n DNSName) Labels() []string {
+	labels := make([]string, len(n.labels))
+	copy(labels, n.labels)
+	return labels
+}
+
+// Parent strips the leftmost label, the root is its own parent.
+func (n DNSName) Parent() DNSName {
+	if len(n.labels) == 0 {
+		return n
+	}
+	return DNSName{labels: n.labels[1:], relative: n.relative}
+}
+
+func (n DNSName) Equal(other DNSName) bool {
+	if n.relative != other.relative || len(n.labels) != len(other.labels) {
+		return false
+	}
+	for i := range n.labels {
+		if !equalFoldASCII(n.labels[i], other.labels[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPartOf reports whether n equals zone or is below it.
+func (n DNSName) IsPartOf(zone DNSName) bool {
+	if n.relative != zone.relative || len(n.labels) < len(zone.labels) {
+		return false
+	}
+	offset := len(n.labels) - len(zone.labels)
+	for i := range zone.labels {
+		if !equalFoldASCII(n.labels[offset+i], zone.labels[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MakeRelative strips zone off n, false when n is not part of zone. The
+// zone apex becomes the empty relative name.
+func (n DNSName) MakeRelative(zone DNSName) (DNSName, bool) {
+	if n.relative || !n.IsPartOf(zone) {
+		return DNSName{}, false
+	}
+	return DNSName{labels: n.labels[:len(n.labels)-len(zone.labels)], relative: true}, true
+}
+
+// Compare orders names canonically as DNSSEC does (RFC 4034 6.1): label by
+// label from the rightmost one, each compared as lowercase octets, a
+// missing label first. It returns -1, 0 or +1.
+func (n DNSName) Compare(other DNSName) int {
+	i, j := len(n.labels)-1, len(other.labels)-1
+	for ; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := compareLabels(n.labels[i], other.labels[j]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case i >= 0:
+		return 1
+	case j >= 0:
+		return -1
+	}
+	return 0
+}
+
+func compareLabels(a, b string) int {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		ca, cb := lowerASCII(a[k]), lowerASCII(b[k])
+		if ca != cb {
+			if ca < cb {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+func equalFoldASCII(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := 0; k < len(a); k++ {
+		if lowerASCII(a[k]) != lowerASCII(b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDNSName(t *testing.T) {
+	for in, want := range map[string]string{
+		".":                 ".",
+		"example.com":       "example.com.",
+		"Example.COM.":      "Example.COM.",
+		`a\.b.example.com.`: `a\.b.example.com.`,
+		`a\046b.example.`:   `a\.b.example.`,
+		`sp\032ace.test.`:   `sp\032ace.test.`,
+		`\065.test.`:        "A.test.",
+	} {
+		n, err := ParseDNSName(in)
+		assert.Equal(t, err, nil, in)
+		assert.Equal(t, n.String(), want, in)
+	}
+	for _, in := range []string{
+		"",
+		"a..b.",
+		".a.",
+		`a\`,
+		`a\25.test.`,
+		`a\256.test.`,
+		strings.Repeat("a", 64) + ".test.",
+		strings.Repeat(strings.Repeat("a", 63)+".", 4),
+	} {
+		_, err := ParseDNSName(in)
+		assert.NotEqual(t, err, nil, in)
+	}
+	_, err := ParseDNSName(strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 61) + ".")
+	assert.Equal(t, err, nil)
+}
+
+func TestDNSName_Labels(t *testing.T) {
+	n := MustParseDNSName("WWW.Example.com.")
+	zone := MustParseDNSName("example.COM")
+	assert.Equal(t, n.Canonical().String(), "www.example.com.")
+	assert.Equal(t, n.Labels(), []string{"WWW", "Example", "com"})
+	assert.Equal(t, n.CountLabels(), 3)
+	assert.True(t, n.Parent().Equal(zone))
+	assert.True(t, n.IsPartOf(zone))
+	assert.True(t, zone.IsPartOf(zone))
+	assert.False(t, zone.IsPartOf(n))
+	assert.False(t, MustParseDNSName("wexample.com.").IsPartOf(zone))
+	assert.True(t, n.IsPartOf(DNSName{}))
+	assert.True(t, DNSName{}.Parent().IsRoot())
+
+	rel, ok := n.MakeRelative(zone)
+	assert.True(t, ok)
+	assert.True(t, rel.IsRelative())
+	assert.Equal(t, rel.String(), "WWW")
+	apex, ok := zone.MakeRelative(zone)
+	assert.True(t, ok)
+	assert.Equal(t, apex.String(), "")
+	_, ok = zone.MakeRelative(n)
+	assert.False(t, ok)
+}
+
+func TestDNSName_Compare(t *testing.T) {
+	// RFC 4034 section 6.1
+	want := []string{
+		"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.",
+		`zABC.a.EXAMPLE.`, "z.example.", `\001.z.example.`, "*.z.example.", `\200.z.example.`,
+	}
+	names := make([]DNSName, 0, len(want))
+	for i := len(want) - 1; i >= 0; i-- {
+		names = append(names, MustParseDNSName(want[i]))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i].Compare(names[j]) < 0
+	})
+	got := make([]string, 0, len(names))
+	for _, n := range names {
+		got = append(got, n.String())
+	}
+	assert.Equal(t, got, want)
+	assert.Equal(t, MustParseDNSName("A.example.").Compare(MustParseDNSName("a.EXAMPLE")), 0)
+}
+
+func TestService_LookupCaseInsensitive(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, service.CreateSlaveDomain(ctx, "10.0.0.5", MustParseDNSName("Case.TEST")), nil)
+	info, err := service.GetDomainInfo(ctx, MustParseDNSName("case.test."))
+	assert.Equal(t, err, nil)
+	rr := &DNSResourceRecord{DomainID: info.ID, Qname: "WWW.Case.Test", Qtype: "A", Content: "192.0.2.1", TTL: 60}
+	assert.Equal(t, service.FeedRecord(ctx, rr, ""), nil)
+	assert.NotEqual(t, service.FeedRecord(ctx, &DNSResourceRecord{Qname: "bad..case.test.", Qtype: "A"}, ""), nil)
+
+	rrs, err := service.Lookup(ctx, TypeA, MustParseDNSName("www.CASE.test."), -1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rrs), 1)
+	assert.Equal(t, rrs[0].Qname, "WWW.Case.Test.")
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// nsec3Param is the NSEC3PARAM metadata of a zone, SHA-1 is the only hash.
+type nsec3Param struct {
+	iterations int
+	salt       []byte
+}
+
+// parseNSEC3Param reads "algorithm flags iterations salt", a salt of "-"
+// is empty.
+func parseNSEC3Param(s string) (*nsec3Param, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return nil, stacktrace.Newf("Bad NSEC3PARAM %q", s)
+	}
+	if fields[0] != "1" {
+		return nil, stacktrace.Newf("Unsupported NSEC3 hash algorithm %s", fields[0])
+	}
+	iterations, err := strconv.ParseUint(fields[2], 10, 16)
+	if err != nil {
+		return nil, stacktrace.Newf("Bad NSEC3PARAM iterations %q", fields[2])
+	}
+	p := &nsec3Param{iterations: int(iterations)}
+	if fields[3] != "-" {
+		if p.salt, err = hex.DecodeString(fields[3]); err != nil {
+			return nil, stacktrace.Newf("Bad NSEC3PARAM salt %q", fields[3])
+		}
+	}
+	return p, nil
+}
+
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// hash returns the NSEC3 hash of name (RFC 5155 5) in lowercase base32hex.
+func (p *nsec3Param) hash(name DNSName) string {
+	wire := make([]byte, 0, maxNameLength)
+	for _, label := range name.Canonical().labels {
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	wire = append(wire, 0)
+	h := sha1.New()
+	h.Write(wire)
+	h.Write(p.salt)
+	sum := h.Sum(nil)
+	for i := 0; i < p.iterations; i++ {
+		h.Reset()
+		h.Write(sum)
+		h.Write(p.salt)
+		sum = h.Sum(sum[:0])
+	}
+	return strings.ToLower(base32Hex.EncodeToString(sum))
+}
+
+// nsecOrderName returns the labels of name below zone in reverse order,
+// lowercase and separated by spaces, so that ordernames sort like names
+// in the NSEC chain. The apex has the empty ordername.
+func nsecOrderName(name DNSName, zone DNSName) string {
+	relative, _ := name.Canonical().MakeRelative(zone)
+	var b strings.Builder
+	for i := len(relative.labels) - 1; i >= 0; i-- {
+		writeLabel(&b, relative.labels[i])
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// dnssecZone computes the ordername and auth of the records written into
+// one zone. It keeps what it learnt of the delegations of the zone, so it
+// lives as long as the transaction it is used in.
+type dnssecZone struct {
+	id     int
+	name   DNSName
+	nsec3  *nsec3Param
+	narrow bool
+	// delegations tells whether names below the apex have NS records
+	delegations map[string]bool
+	// fresh is set when the transaction emptied the zone, delegations then
+	// knows every NS record it has
+	fresh bool
+	// redelegated lists the names whose delegation changed after records
+	// at or below them were rectified
+	redelegated []DNSName
+}
+
+// loadDNSSECZone finds the zone domainID that qname is part of and reads
+// its NSEC3 settings.
+func loadDNSSECZone(ctx context.Context, r *storage.Repositories, domainID int, qname string) (*dnssecZone, error) {
+	start, err := ParseDNSName(qname)
+	if err != nil {
+		return nil, err
+	}
+	for name := start.Canonical(); ; name = name.Parent() {
+		id, err := r.Zones.ID(ctx, name.String())
+		if err == nil && id == domainID {
+			return newDNSSECZone(ctx, r, id, name)
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, stacktrace.Wrap(err)
+		}
+		if name.IsRoot() {
+			return nil, stacktrace.Newf("%s is not in zone %d", start, domainID)
+		}
+	}
+}
+
+func newDNSSECZone(ctx context.Context, r *storage.Repositories, id int, name DNSName) (*dnssecZone, error) {
+	z := &dnssecZone{id: id, name: name.Canonical(), delegations: make(map[string]bool)}
+	param, err := r.Metadata.Get(ctx, z.name.String(), "NSEC3PARAM")
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, stacktrace.Wrap(err)
+	}
+	if len(param) > 0 && param[0] != "" {
+		if z.nsec3, err = parseNSEC3Param(param[0]); err != nil {
+			return nil, err
+		}
+		narrow, err := r.Metadata.Get(ctx, z.name.String(), "NSEC3NARROW")
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, stacktrace.Wrap(err)
+		}
+		z.narrow = len(narrow) > 0 && narrow[0] == "1"
+	}
+	return z, nil
+}
+
+// orderName is empty in narrow NSEC3 zones, where hashes are computed
+// when answering.
+func (z *dnssecZone) orderName(name DNSName) string {
+	switch {
+	case z.nsec3 == nil:
+		return nsecOrderName(name, z.name)
+	case z.narrow:
+		return ""
+	}
+	return z.nsec3.hash(name)
+}
+
+// delegated reports whether name below the apex has NS records. A fresh
+// zone answers from the NS records written since it was emptied.
+func (z *dnssecZone) delegated(ctx context.Context, r *storage.Repositories, name DNSName) (bool, error) {
+	key := name.String()
+	if known, ok := z.delegations[key]; ok || z.fresh {
+		z.delegations[key] = known
+		return known, nil
+	}
+	ns, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: key, Type: TypeNS.String(), DomainID: z.id})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, stacktrace.Wrap(err)
+	}
+	z.delegations[key] = len(ns) > 0
+	return len(ns) > 0, nil
+}
+
+// setDelegation records that the NS records of name are written, or all
+// deleted when exists is false.
+func (z *dnssecZone) setDelegation(name DNSName, exists bool) {
+	if name.Equal(z.name) {
+		return
+	}
+	key := name.Canonical().String()
+	if known, ok := z.delegations[key]; ok && known != exists {
+		z.redelegated = append(z.redelegated, name.Canonical())
+	}
+	z.delegations[key] = exists
+}
+
+// belowRedelegated reports whether name is at or below a name in
+// redelegated.
+func (z *dnssecZone) belowRedelegated(name DNSName) bool {
+	for _, d := range z.redelegated {
+		if name.IsPartOf(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// rectify sets the ordername and auth of record as DNSSEC needs them: NS
+// records of a delegation and everything below it are not authoritative,
+// names below a delegation are out of the NSEC chain. A record written
+// before the delegation above it is fixed once the delegation is stored,
+// by FeedRecord at once and by RecordWriter on commit or on moving to
+// another zone.
+func (z *dnssecZone) rectify(ctx context.Context, r *storage.Repositories, record *storage.Record) error {
+	name, err := ParseDNSName(record.Name)
+	if err != nil {
+		return err
+	}
+	if !name.IsPartOf(z.name) {
+		return stacktrace.Newf("%s is not in zone %s", name, z.name)
+	}
+	atDelegation, belowDelegation := false, false
+	if !name.Equal(z.name) {
+		if record.Type == TypeNS.String() {
+			z.setDelegation(name, true)
+		}
+		if atDelegation, err = z.delegated(ctx, r, name); err != nil {
+			return err
+		}
+		for parent := name.Parent(); !parent.Equal(z.name); parent = parent.Parent() {
+			if belowDelegation, err = z.delegated(ctx, r, parent); err != nil || belowDelegation {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case belowDelegation:
+		record.Auth, record.OrderName = false, ""
+	case atDelegation:
+		record.Auth = record.Type == TypeDS.String()
+		record.OrderName = z.orderName(name)
+	default:
+		record.Auth = true
+		record.OrderName = z.orderName(name)
+	}
+	return nil
+}
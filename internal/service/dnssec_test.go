@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNSECOrderName(t *testing.T) {
+	zone := MustParseDNSName("example.com.")
+	assert.Equal(t, nsecOrderName(MustParseDNSName("example.com."), zone), "")
+	assert.Equal(t, nsecOrderName(MustParseDNSName("WWW.example.com."), zone), "www")
+	assert.Equal(t, nsecOrderName(MustParseDNSName("a.b\\.c.example.com."), zone), "b\\.c a")
+}
+
+func TestNSEC3Hash(t *testing.T) {
+	// RFC 5155 appendix A
+	p, err := parseNSEC3Param("1 0 12 aabbccdd")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, p.hash(MustParseDNSName("example.")), "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom")
+	assert.Equal(t, p.hash(MustParseDNSName("A.example.")), "35mthgpgcu1qg68fab165klnsnk3dpvl")
+
+	_, err = parseNSEC3Param("2 0 1 -")
+	assert.NotEqual(t, err, nil)
+}
+
+func TestService_DNSSECOrderNameAndAuth(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	assert.Equal(t, store.Repositories().Zones.Create(ctx, &storage.Zone{ID: 1, Name: "sec.test.", Kind: "NATIVE"}), nil)
+	assert.Equal(t, store.Repositories().Zones.Create(ctx, &storage.Zone{ID: 2, Name: "sec3.test.", Kind: "NATIVE"}), nil)
+	s := New(nil, true, WithStore(store))
+	assert.Equal(t, s.SetDomainMetadata(ctx, MustParseDNSName("sec3.test."), "NSEC3PARAM", []string{"1 0 1 ab"}), nil)
+
+	assert.Equal(t, s.StartTransaction(ctx, 1, 1, MustParseDNSName("sec.test.")), nil)
+	for _, rr := range []*DNSResourceRecord{
+		{Qname: "sec.test.", Qtype: "NS", Content: "ns.sec.test."},
+		{Qname: "www.sec.test.", Qtype: "A", Content: "192.0.2.1", Auth: false},
+		{Qname: "sub.sec.test.", Qtype: "NS", Content: "ns.sub.sec.test."},
+		{Qname: "sub.sec.test.", Qtype: "DS", Content: "1 13 2 " + "ab" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcd"},
+		{Qname: "ns.sub.sec.test.", Qtype: "A", Content: "192.0.2.53", Auth: true},
+	} {
+		rr.TTL = 300
+		auth := rr.Auth
+		assert.Equal(t, s.FeedTransactionRecord(ctx, 1, rr, "ignored"), nil, rr.Qname)
+		assert.Equal(t, rr.DomainID, 0, rr.Qname)
+		assert.Equal(t, rr.Auth, auth, rr.Qname)
+	}
+	assert.Equal(t, s.CommitTransaction(ctx, 1), nil)
+
+	for _, want := range []struct {
+		name, qtype, ordername string
+		auth                   bool
+	}{
+		{"sec.test.", "NS", "", true},
+		{"www.sec.test.", "A", "www", true},
+		{"sub.sec.test.", "NS", "sub", false},
+		{"sub.sec.test.", "DS", "sub", true},
+		{"ns.sub.sec.test.", "A", "", false},
+	} {
+		rrs, err := store.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: want.name, Type: want.qtype, DomainID: 1})
+		assert.Equal(t, err, nil)
+		if assert.Equal(t, len(rrs), 1, want.name) {
+			assert.Equal(t, rrs[0].OrderName, want.ordername, want.name)
+			assert.Equal(t, rrs[0].Auth, want.auth, want.name)
+		}
+	}
+
+	rr := &DNSResourceRecord{DomainID: 2, Qname: "www.sec3.test.", Qtype: "A", Content: "192.0.2.1", TTL: 300}
+	assert.Equal(t, s.FeedRecord(ctx, rr, ""), nil)
+	assert.Equal(t, rr.OrderName, "", "the record fed is left alone")
+	rrs, err := store.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: "www.sec3.test.", Type: "A", DomainID: 2})
+	assert.Equal(t, err, nil)
+	p, _ := parseNSEC3Param("1 0 1 ab")
+	if assert.Equal(t, len(rrs), 1) {
+		assert.Equal(t, rrs[0].OrderName, p.hash(MustParseDNSName("www.sec3.test.")))
+		assert.True(t, rrs[0].Auth)
+	}
+
+	outside := &DNSResourceRecord{DomainID: 2, Qname: "www.sec.test.", Qtype: "A", Content: "192.0.2.1", TTL: 300}
+	assert.NotEqual(t, s.FeedRecord(ctx, outside, ""), nil)
+}
+
+func TestService_DNSSECGlueBeforeNS(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	assert.Equal(t, store.Repositories().Zones.Create(ctx, &storage.Zone{ID: 1, Name: "glue.test.", Kind: "NATIVE"}), nil)
+	// the glue is already flushed with batches of one
+	for _, batch := range []int{1, 100} {
+		counted := &lookupCounter{Store: store}
+		s := New(nil, true, WithStore(counted), WithBatchSize(batch))
+		testGlueBeforeNS(t, s, store)
+		// the zone emptied by the transaction needs no lookup of delegations
+		assert.Equal(t, counted.lookups, 0)
+	}
+}
+
+func TestService_DNSSECFeedGlueBeforeNS(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	assert.Equal(t, store.Repositories().Zones.Create(ctx, &storage.Zone{ID: 1, Name: "feed.test.", Kind: "NATIVE"}), nil)
+	s := New(nil, true, WithStore(store))
+	for _, rr := range []*DNSResourceRecord{
+		{Qname: "ns.sub.feed.test.", Qtype: "A", Content: "192.0.2.53"},
+		{Qname: "sub.feed.test.", Qtype: "NS", Content: "ns.sub.feed.test."},
+		{Qname: "sub.feed.test.", Qtype: "NS", Content: "ns2.sub.feed.test."},
+	} {
+		rr.DomainID, rr.TTL = 1, 300
+		assert.Equal(t, s.FeedRecord(ctx, rr, ""), nil, rr.Qname)
+	}
+
+	rrs, err := store.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: "ns.sub.feed.test.", Type: "A", DomainID: 1})
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(rrs), 1) {
+		assert.Equal(t, rrs[0].OrderName, "")
+		assert.Equal(t, rrs[0].Auth, false)
+	}
+	changes, err := s.ChangesSince(ctx, 0, 1, 10)
+	assert.Equal(t, err, nil)
+	ops := make([]string, 0, len(changes))
+	for _, c := range changes {
+		ops = append(ops, c.Operation)
+	}
+	assert.Equal(t, ops, []string{OpInsertRecord, OpInsertRecord, OpRectifyZone, OpInsertRecord})
+}
+
+// lookupCounter counts the record lookups done through Store.
+type lookupCounter struct {
+	storage.Store
+	lookups int
+}
+
+func (c *lookupCounter) Repositories() *storage.Repositories {
+	return c.counted(c.Store.Repositories())
+}
+
+func (c *lookupCounter) Begin(ctx context.Context) (storage.StoreTx, error) {
+	tx, err := c.Store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &countedTx{StoreTx: tx, r: c.counted(tx.Repositories())}, nil
+}
+
+func (c *lookupCounter) counted(r *storage.Repositories) *storage.Repositories {
+	out := *r
+	out.Records = &countedRecords{Records: r.Records, c: c}
+	return &out
+}
+
+type countedTx struct {
+	storage.StoreTx
+	r *storage.Repositories
+}
+
+func (tx *countedTx) Repositories() *storage.Repositories {
+	return tx.r
+}
+
+type countedRecords struct {
+	storage.Records
+	c *lookupCounter
+}
+
+func (r *countedRecords) Lookup(ctx context.Context, q storage.LookupQuery) ([]*storage.Record, error) {
+	r.c.lookups++
+	return r.Records.Lookup(ctx, q)
+}
+
+func testGlueBeforeNS(t *testing.T, s *Service, store storage.Store) {
+	ctx := context.Background()
+	assert.Equal(t, s.StartTransaction(ctx, 1, 1, MustParseDNSName("glue.test.")), nil)
+	for _, rr := range []*DNSResourceRecord{
+		{Qname: "glue.test.", Qtype: "NS", Content: "ns.glue.test."},
+		{Qname: "ns.sub.glue.test.", Qtype: "A", Content: "192.0.2.53"},
+		{Qname: "sub.glue.test.", Qtype: "NS", Content: "ns.sub.glue.test."},
+		{Qname: "www.glue.test.", Qtype: "A", Content: "192.0.2.1"},
+	} {
+		rr.TTL = 300
+		assert.Equal(t, s.FeedTransactionRecord(ctx, 1, rr, ""), nil, rr.Qname)
+	}
+	assert.Equal(t, s.CommitTransaction(ctx, 1), nil)
+
+	for _, want := range []struct {
+		name, qtype, ordername string
+		auth                   bool
+	}{
+		{"ns.sub.glue.test.", "A", "", false},
+		{"sub.glue.test.", "NS", "sub", false},
+		{"www.glue.test.", "A", "www", true},
+	} {
+		rrs, err := store.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: want.name, Type: want.qtype, DomainID: 1})
+		assert.Equal(t, err, nil)
+		if assert.Equal(t, len(rrs), 1, want.name) {
+			assert.Equal(t, rrs[0].OrderName, want.ordername, want.name)
+			assert.Equal(t, rrs[0].Auth, want.auth, want.name)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// RecordIterator reads records row by row, so the caller never holds the
+// whole zone in memory. It must be closed.
+type RecordIterator struct {
+	it      storage.RecordIterator
+	convert func(*storage.Record) *DNSResourceRecord
+	cancel  context.CancelFunc
+	rr      *DNSResourceRecord
+}
+
+func newRecordIterator(it storage.RecordIterator, convert func(*storage.Record) *DNSResourceRecord, cancel context.CancelFunc) *RecordIterator {
+	return &RecordIterator{
+		it:      it,
+		convert: convert,
+		cancel:  cancel,
+	}
+}
+
+func (it *RecordIterator) Next() bool {
+	for it.it.Next() {
+		rr := it.it.Record()
+		if rr.Type == "" {
+			// empty non-terminal
+			continue
+		}
+		it.rr = it.convert(rr)
+		return true
+	}
+	return false
+}
+
+func (it *RecordIterator) Record() *DNSResourceRecord {
+	return it.rr
+}
+
+func (it *RecordIterator) Err() error {
+	return stacktrace.Wrap(it.it.Err())
+}
+
+func (it *RecordIterator) Close() error {
+	err := it.it.Close()
+	if it.cancel != nil {
+		it.cancel()
+	}
+	return stacktrace.Wrap(err)
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Change is an entry of the append-only journal.
+type Change = storage.Change
+
+const (
+	OpInsertRecord  = "insert-record"
+	OpReplaceRRSet  = "replace-rrset"
+	OpDeleteZone    = "delete-zone"
+	OpSetMetadata   = "set-metadata"
+	OpAddKey        = "add-key"
+	OpSetNotified   = "set-notified"
+	OpSetFresh      = "set-fresh"
+	OpCreateDomain  = "create-domain"
+	OpSetTSIGKey    = "set-tsig-key"
+	OpDeleteTSIGKey = "delete-tsig-key"
+	OpRectifyZone   = "rectify-zone"
+)
+
+// CompactionPolicy limits the journal size. Zero fields are not applied.
+type CompactionPolicy struct {
+	MaxAge   time.Duration
+	KeepLast int
+}
+
+type actorKey struct{}
+
+// WithActor records who makes the changes done with ctx.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// mutate runs fn and writes the changes it returns into the journal inside
+// one storage transaction. With a retrier fn may run again in a new
+// transaction, so it starts from scratch every time.
+func (s *Service) mutate(ctx context.Context, fn func(r *storage.Repositories) ([]*Change, error)) error {
+	if s.retrier == nil {
+		return s.mutateOnce(ctx, fn)
+	}
+	return s.retrier.Run(ctx, func() error {
+		return s.mutateOnce(ctx, fn)
+	})
+}
+
+func (s *Service) mutateOnce(ctx context.Context, fn func(r *storage.Repositories) ([]*Change, error)) error {
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	changes, err := fn(tx.Repositories())
+	if err == nil {
+		err = s.journal(ctx, tx.Repositories(), changes...)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return stacktrace.Wrap(tx.Commit())
+}
+
+func (s *Service) journal(ctx context.Context, r *storage.Repositories, changes ...*Change) error {
+	actor := actorFrom(ctx)
+	now := time.Now().UTC().Unix()
+	for _, c := range changes {
+		if c.Actor == "" {
+			c.Actor = actor
+		}
+		if c.Time == 0 {
+			c.Time = now
+		}
+	}
+	return stacktrace.Wrap(r.Changes.Append(ctx, changes...))
+}
+
+// newChange marshals before and after, nil stays empty.
+func newChange(domainID int, operation string, before interface{}, after interface{}) (*Change, error) {
+	c := &Change{
+		DomainID:  domainID,
+		Operation: operation,
+	}
+	var err error
+	if before != nil {
+		if c.Before, err = json.Marshal(before); err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+	}
+	if after != nil {
+		if c.After, err = json.Marshal(after); err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+	}
+	return c, nil
+}
+
+// ChangesSince returns up to limit journal entries with seq greater than
+// seq, of one zone or of all zones when domainID is negative.
+func (s *Service) ChangesSince(ctx context.Context, seq int64, domainID int, limit int) ([]*Change, error) {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.List)
+	defer cancel()
+	changes, err := s.repos().Changes.Since(ctx, seq, domainID, limit)
+	return changes, stacktrace.Wrap(err)
+}
+
+// CompactChanges deletes journal entries outside the policy and returns how
+// many were removed.
+func (s *Service) CompactChanges(ctx context.Context, policy CompactionPolicy) (int, error) {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	removed := 0
+	if policy.MaxAge > 0 {
+		n, err := s.repos().Changes.DeleteBefore(ctx, time.Now().UTC().Add(-policy.MaxAge).Unix())
+		if err != nil {
+			return removed, stacktrace.Wrap(err)
+		}
+		removed += n
+	}
+	if policy.KeepLast > 0 {
+		n, err := s.repos().Changes.KeepLast(ctx, policy.KeepLast)
+		if err != nil {
+			return removed, stacktrace.Wrap(err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// RunCompaction applies the policy every interval until ctx is done.
+func (s *Service) RunCompaction(ctx context.Context, interval time.Duration, policy CompactionPolicy) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.CompactChanges(ctx, policy); err != nil {
+				s.logger.Error("journal compaction", zap.Error(err))
+			}
+		}
+	}
+}
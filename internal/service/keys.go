@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+func (s *Service) GetDomainKeys(ctx context.Context, zone DNSName) ([]*KeyData, error) {
+	if !s.dnssec {
+		return nil, stacktrace.New("Only for DNSSEC")
+	}
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	list, err := s.repos().Keys.List(ctx, zone.Canonical().String())
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	keys := make([]*KeyData, 0, len(list))
+	for _, key := range list {
+		keys = append(keys, &KeyData{
+			ID:        key.ID,
+			Flags:     key.Flags,
+			Active:    key.Active,
+			Published: key.Published,
+			Content:   key.Content,
+		})
+	}
+	return keys, nil
+}
+
+func (s *Service) GetTSIGKey(ctx context.Context, name DNSName) (*TSIGKey, error) {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	key, err := s.repos().TSIG.Get(ctx, name.Canonical().String())
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return &TSIGKey{Name: key.Name, Algorithm: key.Algorithm, Content: key.Secret}, nil
+}
+
+func (s *Service) GetTSIGKeys(ctx context.Context) ([]*TSIGKey, error) {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	list, err := s.repos().TSIG.List(ctx)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	keys := make([]*TSIGKey, 0, len(list))
+	for _, key := range list {
+		keys = append(keys, &TSIGKey{Name: key.Name, Algorithm: key.Algorithm, Content: key.Secret})
+	}
+	return keys, nil
+}
+
+func (s *Service) SetTSIGKey(ctx context.Context, key *TSIGKey) error {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	name, err := ParseDNSName(key.Name)
+	if err != nil {
+		return err
+	}
+	key = &TSIGKey{Name: name.Canonical().String(), Algorithm: key.Algorithm, Content: key.Content}
+	return s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		err := r.TSIG.Set(ctx, &storage.TSIGKey{
+			Name:      key.Name,
+			Algorithm: key.Algorithm,
+			Secret:    key.Content,
+		})
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		// the secret never goes into the journal
+		c, err := newChange(-1, OpSetTSIGKey, nil, &TSIGKey{Name: key.Name, Algorithm: key.Algorithm})
+		return []*Change{c}, err
+	})
+}
+
+func (s *Service) DeleteTSIGKey(ctx context.Context, key DNSName) error {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	name := key.Canonical().String()
+	return s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		n, err := r.TSIG.Delete(ctx, name)
+		if err != nil || n == 0 {
+			return nil, stacktrace.Wrap(err)
+		}
+		c, err := newChange(-1, OpDeleteTSIGKey, &TSIGKey{Name: name}, nil)
+		return []*Change{c}, err
+	})
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type Option func(s *Service)
+
+// Timeouts limit storage calls per method, zero means no limit.
+type Timeouts struct {
+	Default    time.Duration
+	Lookup     time.Duration
+	List       time.Duration
+	FeedRecord time.Duration
+}
+
+func WithTimeouts(t Timeouts) Option {
+	return func(s *Service) {
+		s.timeouts = t
+	}
+}
+
+// WithBatchSize sets how many records RecordWriter buffers before writing.
+func WithBatchSize(n int) Option {
+	return func(s *Service) {
+		s.batchSize = n
+	}
+}
+
+// WithPDNSVersion sets the major version of the PowerDNS served, which
+// decides how MX and SRV priorities are answered.
+func WithPDNSVersion(major int) Option {
+	return func(s *Service) {
+		s.pdnsVersion = major
+	}
+}
+
+// WithStore replaces the statement catalogue built over the storage given
+// to New, for backends that implement the repositories themselves.
+func WithStore(store storage.Store) Option {
+	return func(s *Service) {
+		s.store = store
+	}
+}
+
+// WithZoneCacheTTL sets how long the zone names used to find the zone of
+// lookups without a zone id are cached, 0 looks them up every time. The
+// service drops the cache on its own zone changes, but zones created or
+// deleted in the storage by anything else, such as the copy, restore and
+// audit commands or pdnsutil, are only seen up to ttl later.
+func WithZoneCacheTTL(ttl time.Duration) Option {
+	return func(s *Service) {
+		s.zones.ttl = ttl
+	}
+}
+
+// WithTransactionIdleTimeout sets how long a transaction is kept without
+// calls before it is aborted, 0 keeps it until committed or aborted.
+func WithTransactionIdleTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.trxIdle = d
+	}
+}
+
+// Retrier runs a unit of work again while it fails with a transient error,
+// retry.Storage is one.
+type Retrier interface {
+	Run(ctx context.Context, fn func() error) error
+}
+
+// WithRetry repeats the service transactions failing with a transient
+// error as a whole, statements inside them are not repeated alone.
+func WithRetry(r Retrier) Option {
+	return func(s *Service) {
+		s.retrier = r
+	}
+}
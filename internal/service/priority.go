@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// priorityFields counts the content fields of the types with priority,
+// the priority included.
+var priorityFields = map[QType]int{
+	TypeMX:  2,
+	TypeSRV: 4,
+}
+
+// withPriority puts prio in front of content unless it is already there,
+// as PowerDNS before 4 sends it apart.
+func withPriority(qtype QType, prio int, content string) string {
+	if len(strings.Fields(content)) >= priorityFields[qtype] {
+		return content
+	}
+	return strconv.Itoa(prio) + " " + content
+}
+
+// splitPriority takes the leading priority off normalized content.
+func splitPriority(content string) (int, string) {
+	i := strings.IndexByte(content, ' ')
+	prio, _ := strconv.Atoi(content[:i])
+	return prio, content[i+1:]
+}
+
+// storedPriority returns the priority and content of a stored record,
+// reading the priority off the content of rows not migrated yet.
+func storedPriority(qtype QType, rr *storage.Record) (int, string, bool) {
+	fields := strings.Fields(rr.Content)
+	if len(fields) != priorityFields[qtype] {
+		return rr.Prio, rr.Content, false
+	}
+	prio, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return rr.Prio, rr.Content, false
+	}
+	return int(prio), strings.Join(fields[1:], " "), true
+}
+
+// fromRecord presents rr to the PowerDNS version served: since 4 the
+// priority is part of the content, before it comes in prio.
+func (s *Service) fromRecord(rr *storage.Record) *DNSResourceRecord {
+	out := recordFields(rr)
+	qtype, err := ParseQType(rr.Type)
+	if err != nil || !qtype.HasPriority() {
+		return out
+	}
+	prio, content, _ := storedPriority(qtype, rr)
+	if s.pdnsVersion >= 4 {
+		out.Content, out.Prio = strconv.Itoa(prio)+" "+content, 0
+	} else {
+		out.Content, out.Prio = content, prio
+	}
+	return out
+}
+
+// SplitPriorities moves the priority of MX and SRV records written with
+// it in the content into the prio column, zone by zone. It returns the
+// number of records moved.
+func (s *Service) SplitPriorities(ctx context.Context) (int, error) {
+	zones, err := s.repos().Zones.List(ctx, true)
+	if err != nil {
+		return 0, stacktrace.Wrap(err)
+	}
+	moved := 0
+	for _, zone := range zones {
+		n, err := s.splitZonePriorities(ctx, zone.ID)
+		if err != nil {
+			return moved, stacktrace.Newf("zone %s: %w", zone.Name, err)
+		}
+		moved += n
+	}
+	return moved, nil
+}
+
+type rrsetKey struct {
+	name  string
+	qtype QType
+}
+
+func (s *Service) splitZonePriorities(ctx context.Context, domainID int) (int, error) {
+	moved := 0
+	err := s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		moved = 0
+		it, err := r.Records.List(ctx, domainID, true)
+		if err != nil {
+			return nil, err
+		}
+		rrsets := make(map[rrsetKey][]*storage.Record)
+		isStale := make(map[rrsetKey]bool)
+		stale := make([]rrsetKey, 0)
+		for it.Next() {
+			rr := it.Record()
+			qtype, err := ParseQType(rr.Type)
+			if err != nil || !qtype.HasPriority() {
+				continue
+			}
+			key := rrsetKey{name: rr.Name, qtype: qtype}
+			if _, _, ok := storedPriority(qtype, rr); ok && !isStale[key] {
+				isStale[key] = true
+				stale = append(stale, key)
+			}
+			rrsets[key] = append(rrsets[key], rr)
+		}
+		if err = it.Err(); err != nil {
+			_ = it.Close()
+			return nil, err
+		}
+		if err = it.Close(); err != nil {
+			return nil, err
+		}
+		changes := make([]*Change, 0, len(stale))
+		for _, key := range stale {
+			records := make([]*storage.Record, 0, len(rrsets[key]))
+			before := make([]*DNSResourceRecord, 0, len(rrsets[key]))
+			after := make([]*DNSResourceRecord, 0, len(rrsets[key]))
+			for _, rr := range rrsets[key] {
+				before = append(before, recordFields(rr))
+				prio, content, ok := storedPriority(key.qtype, rr)
+				if ok {
+					moved++
+				}
+				record := *rr
+				record.Prio, record.Content = prio, content
+				records = append(records, &record)
+				after = append(after, recordFields(&record))
+			}
+			if _, err = r.Records.DeleteRRSet(ctx, domainID, key.name, key.qtype.String()); err != nil {
+				return nil, err
+			}
+			if _, err = r.Records.Insert(ctx, records...); err != nil {
+				return nil, err
+			}
+			c, err := newChange(domainID, OpReplaceRRSet, before, after)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, c)
+		}
+		return changes, nil
+	})
+	return moved, err
+}
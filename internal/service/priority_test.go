@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_Priority(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	zone := &storage.Zone{ID: 1, Name: "prio.test.", Kind: "NATIVE"}
+	assert.Equal(t, store.Repositories().Zones.Create(ctx, zone), nil)
+	s := New(nil, true, WithStore(store))
+
+	assert.Equal(t, s.FeedRecord(ctx, &DNSResourceRecord{
+		Qname: "prio.test.", Qtype: "MX", Content: "10 Mail.prio.test", TTL: 300, DomainID: zone.ID,
+	}, ""), nil)
+	// PowerDNS 3 sends the priority apart
+	assert.Equal(t, s.FeedRecord(ctx, &DNSResourceRecord{
+		Qname: "_sip._udp.prio.test.", Qtype: "SRV", Content: "5 5060 sip.prio.test.", Prio: 20, TTL: 300, DomainID: zone.ID,
+	}, ""), nil)
+
+	stored, err := store.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: "prio.test.", Type: "MX", DomainID: -1})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(stored), 1)
+	assert.Equal(t, stored[0].Prio, 10)
+	assert.Equal(t, stored[0].Content, "mail.prio.test.")
+
+	rrs, err := s.Lookup(ctx, TypeSRV, MustParseDNSName("_sip._udp.prio.test."), -1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rrs[0].Content, "20 5 5060 sip.prio.test.")
+	assert.Equal(t, rrs[0].Prio, 0)
+
+	v3 := New(nil, true, WithStore(store), WithPDNSVersion(3))
+	rrs, err = v3.Lookup(ctx, TypeMX, MustParseDNSName("prio.test."), -1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rrs[0].Content, "mail.prio.test.")
+	assert.Equal(t, rrs[0].Prio, 10)
+}
+
+func TestService_SplitPriorities(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	zone := &storage.Zone{ID: 1, Name: "legacy.test.", Kind: "NATIVE"}
+	assert.Equal(t, store.Repositories().Zones.Create(ctx, zone), nil)
+	_, err := store.Repositories().Records.Insert(ctx,
+		&storage.Record{DomainID: zone.ID, Name: "legacy.test.", Type: "MX", Content: "10 mx1.legacy.test.", TTL: 300, Auth: true},
+		&storage.Record{DomainID: zone.ID, Name: "legacy.test.", Type: "MX", Content: "mx2.legacy.test.", Prio: 20, TTL: 300, Auth: true},
+		&storage.Record{DomainID: zone.ID, Name: "legacy.test.", Type: "A", Content: "192.0.2.1", TTL: 300, Auth: true},
+	)
+	assert.Equal(t, err, nil)
+	s := New(nil, true, WithStore(store))
+
+	n, err := s.SplitPriorities(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	stored, err := store.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: "legacy.test.", Type: "MX", DomainID: -1})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(stored), 2)
+	for _, rr := range stored {
+		assert.NotEqual(t, rr.Prio, 0)
+		assert.Equal(t, len(rr.Content) > 0 && rr.Content[0] == 'm', true)
+	}
+	changes, err := s.ChangesSince(ctx, 0, zone.ID, 10)
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(changes), 1) {
+		assert.JSONEq(t, string(changes[0].Before), `[
+			{"qname":"legacy.test.","qtype":"MX","content":"10 mx1.legacy.test.","ttl":300,"domain_id":1,"auth":true},
+			{"qname":"legacy.test.","qtype":"MX","content":"mx2.legacy.test.","prio":20,"ttl":300,"domain_id":1,"auth":true}
+		]`)
+	}
+
+	n, err = s.SplitPriorities(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 0)
+}
@@ -1,3 +1,242 @@
 package service
 
-type QType string
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// QType is the numeric code of an RR type. Types missing from the registry
+// are written in the generic TYPEnnn form (RFC 3597).
+type QType uint16
+
+const (
+	TypeA          QType = 1
+	TypeNS         QType = 2
+	TypeCNAME      QType = 5
+	TypeSOA        QType = 6
+	TypePTR        QType = 12
+	TypeMX         QType = 15
+	TypeTXT        QType = 16
+	TypeAAAA       QType = 28
+	TypeLOC        QType = 29
+	TypeSRV        QType = 33
+	TypeNAPTR      QType = 35
+	TypeDNAME      QType = 39
+	TypeOPT        QType = 41
+	TypeDS         QType = 43
+	TypeSSHFP      QType = 44
+	TypeRRSIG      QType = 46
+	TypeNSEC       QType = 47
+	TypeDNSKEY     QType = 48
+	TypeNSEC3      QType = 50
+	TypeNSEC3PARAM QType = 51
+	TypeTLSA       QType = 52
+	TypeCDS        QType = 59
+	TypeCDNSKEY    QType = 60
+	TypeZONEMD     QType = 63
+	TypeSVCB       QType = 64
+	TypeHTTPS      QType = 65
+	TypeSPF        QType = 99
+	TypeTKEY       QType = 249
+	TypeTSIG       QType = 250
+	TypeIXFR       QType = 251
+	TypeAXFR       QType = 252
+	TypeMAILB      QType = 253
+	TypeMAILA      QType = 254
+	TypeANY        QType = 255
+	TypeURI        QType = 256
+	TypeCAA        QType = 257
+	TypeALIAS      QType = 65401
+	TypeLUA        QType = 65402
+)
+
+type qtypeFlags uint8
+
+const (
+	// hasPriority types keep a leading priority in the prio column
+	hasPriority qtypeFlags = 1 << iota
+	// dnssecMeta types are produced by signing, not by the zone content
+	dnssecMeta
+	// apexOnly types live at the zone apex only
+	apexOnly
+	// queryOnly types appear in questions, never as stored records
+	queryOnly
+)
+
+type qtypeInfo struct {
+	code  QType
+	name  string
+	flags qtypeFlags
+}
+
+// qtypes is the IANA registry of RR types plus the PowerDNS private ones.
+var qtypes = []qtypeInfo{
+	{1, "A", 0},
+	{2, "NS", 0},
+	{3, "MD", 0},
+	{4, "MF", 0},
+	{5, "CNAME", 0},
+	{6, "SOA", apexOnly},
+	{7, "MB", 0},
+	{8, "MG", 0},
+	{9, "MR", 0},
+	{10, "NULL", 0},
+	{11, "WKS", 0},
+	{12, "PTR", 0},
+	{13, "HINFO", 0},
+	{14, "MINFO", 0},
+	{15, "MX", hasPriority},
+	{16, "TXT", 0},
+	{17, "RP", 0},
+	{18, "AFSDB", 0},
+	{19, "X25", 0},
+	{20, "ISDN", 0},
+	{21, "RT", 0},
+	{22, "NSAP", 0},
+	{23, "NSAP-PTR", 0},
+	{24, "SIG", 0},
+	{25, "KEY", 0},
+	{26, "PX", 0},
+	{27, "GPOS", 0},
+	{28, "AAAA", 0},
+	{29, "LOC", 0},
+	{30, "NXT", 0},
+	{31, "EID", 0},
+	{32, "NIMLOC", 0},
+	{33, "SRV", hasPriority},
+	{34, "ATMA", 0},
+	{35, "NAPTR", 0},
+	{36, "KX", 0},
+	{37, "CERT", 0},
+	{38, "A6", 0},
+	{39, "DNAME", 0},
+	{40, "SINK", 0},
+	{41, "OPT", queryOnly},
+	{42, "APL", 0},
+	{43, "DS", 0},
+	{44, "SSHFP", 0},
+	{45, "IPSECKEY", 0},
+	{46, "RRSIG", dnssecMeta},
+	{47, "NSEC", dnssecMeta},
+	{48, "DNSKEY", apexOnly},
+	{49, "DHCID", 0},
+	{50, "NSEC3", dnssecMeta},
+	{51, "NSEC3PARAM", dnssecMeta | apexOnly},
+	{52, "TLSA", 0},
+	{53, "SMIMEA", 0},
+	{55, "HIP", 0},
+	{56, "NINFO", 0},
+	{57, "RKEY", 0},
+	{58, "TALINK", 0},
+	{59, "CDS", apexOnly},
+	{60, "CDNSKEY", apexOnly},
+	{61, "OPENPGPKEY", 0},
+	{62, "CSYNC", apexOnly},
+	{63, "ZONEMD", apexOnly},
+	{64, "SVCB", 0},
+	{65, "HTTPS", 0},
+	{66, "DSYNC", 0},
+	{99, "SPF", 0},
+	{100, "UINFO", 0},
+	{101, "UID", 0},
+	{102, "GID", 0},
+	{103, "UNSPEC", 0},
+	{104, "NID", 0},
+	{105, "L32", 0},
+	{106, "L64", 0},
+	{107, "LP", 0},
+	{108, "EUI48", 0},
+	{109, "EUI64", 0},
+	{128, "NXNAME", queryOnly},
+	{249, "TKEY", queryOnly},
+	{250, "TSIG", queryOnly},
+	{251, "IXFR", queryOnly},
+	{252, "AXFR", queryOnly},
+	{253, "MAILB", queryOnly},
+	{254, "MAILA", queryOnly},
+	{255, "ANY", queryOnly},
+	{256, "URI", 0},
+	{257, "CAA", 0},
+	{258, "AVC", 0},
+	{259, "DOA", 0},
+	{260, "AMTRELAY", 0},
+	{261, "RESINFO", 0},
+	{262, "WALLET", 0},
+	{263, "CLA", 0},
+	{264, "IPN", 0},
+	{32768, "TA", 0},
+	{32769, "DLV", 0},
+	{65401, "ALIAS", 0},
+	{65402, "LUA", 0},
+}
+
+var (
+	qtypeByCode = make(map[QType]*qtypeInfo, len(qtypes))
+	qtypeByName = make(map[string]*qtypeInfo, len(qtypes))
+)
+
+func init() {
+	for i := range qtypes {
+		info := &qtypes[i]
+		qtypeByCode[info.code] = info
+		qtypeByName[info.name] = info
+	}
+}
+
+// ParseQType reads a type mnemonic in any case, or the generic TYPEnnn
+// form of any code but 0.
+func ParseQType(s string) (QType, error) {
+	upper := strings.ToUpper(s)
+	if info, ok := qtypeByName[upper]; ok {
+		return info.code, nil
+	}
+	if strings.HasPrefix(upper, "TYPE") && len(upper) > 4 && upper[4] != '+' {
+		code, err := strconv.ParseUint(upper[4:], 10, 16)
+		if err == nil && code > 0 {
+			return QType(code), nil
+		}
+	}
+	return 0, stacktrace.Newf("Unknown qtype %q", s)
+}
+
+// String returns the mnemonic, TYPEnnn for types out of the registry.
+func (t QType) String() string {
+	if info, ok := qtypeByCode[t]; ok {
+		return info.name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func (t QType) Known() bool {
+	_, ok := qtypeByCode[t]
+	return ok
+}
+
+func (t QType) is(flag qtypeFlags) bool {
+	info, ok := qtypeByCode[t]
+	return ok && info.flags&flag != 0
+}
+
+// HasPriority reports whether the content starts with a priority that is
+// kept in the prio column.
+func (t QType) HasPriority() bool {
+	return t.is(hasPriority)
+}
+
+// IsDNSSECMeta reports whether records of t are produced by signing the
+// zone rather than written into it, unless the zone is presigned.
+func (t QType) IsDNSSECMeta() bool {
+	return t.is(dnssecMeta)
+}
+
+func (t QType) IsApexOnly() bool {
+	return t.is(apexOnly)
+}
+
+// IsQueryOnly reports whether t is only asked for, like ANY or AXFR, and
+// cannot be the type of a record.
+func (t QType) IsQueryOnly() bool {
+	return t.is(queryOnly)
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQType(t *testing.T) {
+	for in, want := range map[string]QType{
+		"A":          TypeA,
+		"mx":         TypeMX,
+		"NSAP-PTR":   23,
+		"TYPE1":      TypeA,
+		"type65534":  65534,
+		"ANY":        TypeANY,
+		"NSEC3PARAM": TypeNSEC3PARAM,
+	} {
+		qtype, err := ParseQType(in)
+		assert.Equal(t, err, nil, in)
+		assert.Equal(t, qtype, want, in)
+	}
+	for _, in := range []string{"", "XX", "TYPE", "TYPE0", "TYPE65536", "TYPE+1", "TYPE-1"} {
+		_, err := ParseQType(in)
+		assert.NotEqual(t, err, nil, in)
+	}
+	assert.Equal(t, TypeAAAA.String(), "AAAA")
+	assert.Equal(t, QType(65534).String(), "TYPE65534")
+	assert.False(t, QType(65534).Known())
+}
+
+func TestQType_Flags(t *testing.T) {
+	assert.True(t, TypeMX.HasPriority())
+	assert.True(t, TypeSRV.HasPriority())
+	assert.False(t, TypeA.HasPriority())
+	assert.True(t, TypeRRSIG.IsDNSSECMeta())
+	assert.False(t, TypeDS.IsDNSSECMeta())
+	assert.True(t, TypeSOA.IsApexOnly())
+	assert.False(t, TypeNS.IsApexOnly())
+	assert.True(t, TypeAXFR.IsQueryOnly())
+	assert.False(t, QType(65534).IsQueryOnly())
+}
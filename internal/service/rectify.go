@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// RectifiedRRSet is an RRset whose ordername or auth RectifyZone changed,
+// Type is empty for an empty non-terminal.
+type RectifiedRRSet struct {
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	OrderName string `json:"ordername,omitempty"`
+	Auth      bool   `json:"auth"`
+}
+
+// RectifyResult tells what RectifyZone changed.
+type RectifyResult struct {
+	Zone        string           `json:"zone"`
+	Updated     []RectifiedRRSet `json:"updated"`
+	AddedENTs   []string         `json:"added_ents"`
+	RemovedENTs []string         `json:"removed_ents"`
+}
+
+// Changed reports whether the zone needed rectifying.
+func (r *RectifyResult) Changed() bool {
+	return len(r.Updated)+len(r.AddedENTs)+len(r.RemovedENTs) > 0
+}
+
+// rectifiedName is an RRset of the zone, or an empty non-terminal when
+// qtype is empty, with its ordername and auth as stored.
+type rectifiedName struct {
+	name      DNSName
+	qtype     string
+	orderName string
+	auth      bool
+	// mixed is set when the records of the RRset disagree
+	mixed bool
+}
+
+// RectifyZone recomputes the auth flags and ordernames of the enabled
+// records of zone and its empty non-terminals, like pdnsutil rectify-zone,
+// in one transaction. Use it after editing records outside the service.
+func (s *Service) RectifyZone(ctx context.Context, zone DNSName) (*RectifyResult, error) {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.List)
+	defer cancel()
+	if !s.dnssec {
+		return nil, stacktrace.New("Only for DNSSEC")
+	}
+	name := zone.Canonical()
+	var result *RectifyResult
+	err := s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		result = &RectifyResult{
+			Zone:        name.String(),
+			Updated:     make([]RectifiedRRSet, 0),
+			AddedENTs:   make([]string, 0),
+			RemovedENTs: make([]string, 0),
+		}
+		id, err := r.Zones.ID(ctx, name.String())
+		if err != nil {
+			return nil, err
+		}
+		z, err := newDNSSECZone(ctx, r, id, name)
+		if err != nil {
+			return nil, err
+		}
+		rrsets, ents, err := readRectifiedNames(ctx, r, z)
+		if err != nil {
+			return nil, err
+		}
+		if err = rectifyRRSets(ctx, r, z, rrsets, result); err != nil {
+			return nil, err
+		}
+		if err = rectifyENTs(ctx, r, z, rrsets, ents, result); err != nil {
+			return nil, err
+		}
+		if !result.Changed() {
+			return nil, nil
+		}
+		c, err := newChange(id, OpRectifyZone, nil, result)
+		return []*Change{c}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rectifyRedelegated fixes the stored records at or below the names of z
+// whose delegation changed after they were rectified. The change is nil
+// when nothing needed fixing.
+func rectifyRedelegated(ctx context.Context, r *storage.Repositories, z *dnssecZone) (*Change, error) {
+	if len(z.redelegated) == 0 {
+		return nil, nil
+	}
+	rrsets, _, err := readRectifiedNames(ctx, r, z)
+	if err != nil {
+		return nil, err
+	}
+	below := make([]*rectifiedName, 0)
+	for _, n := range rrsets {
+		if z.belowRedelegated(n.name) {
+			below = append(below, n)
+		}
+	}
+	z.redelegated = nil
+	result := &RectifyResult{Zone: z.name.String(), Updated: make([]RectifiedRRSet, 0)}
+	if err = rectifyRRSets(ctx, r, z, below, result); err != nil || !result.Changed() {
+		return nil, err
+	}
+	return newChange(z.id, OpRectifyZone, nil, result)
+}
+
+// readRectifiedNames lists the RRsets and empty non-terminals of the zone
+// and learns its delegations. Names out of the zone are left alone.
+func readRectifiedNames(ctx context.Context, r *storage.Repositories, z *dnssecZone) ([]*rectifiedName, map[string]*rectifiedName, error) {
+	it, err := r.Records.List(ctx, z.id, false)
+	if err != nil {
+		return nil, nil, stacktrace.Wrap(err)
+	}
+	defer it.Close()
+	byKey := make(map[string]*rectifiedName)
+	rrsets := make([]*rectifiedName, 0)
+	ents := make(map[string]*rectifiedName)
+	for it.Next() {
+		rr := it.Record()
+		name, err := ParseDNSName(rr.Name)
+		if err != nil || !name.IsPartOf(z.name) {
+			continue
+		}
+		name = name.Canonical()
+		key := name.String() + " " + rr.Type
+		if found, ok := byKey[key]; ok {
+			found.mixed = found.mixed || found.orderName != rr.OrderName || found.auth != rr.Auth
+			continue
+		}
+		n := &rectifiedName{name: name, qtype: rr.Type, orderName: rr.OrderName, auth: rr.Auth}
+		byKey[key] = n
+		if rr.Type == "" {
+			ents[name.String()] = n
+			continue
+		}
+		rrsets = append(rrsets, n)
+		if _, ok := z.delegations[name.String()]; !ok || rr.Type == TypeNS.String() {
+			z.delegations[name.String()] = rr.Type == TypeNS.String() && !name.Equal(z.name)
+		}
+	}
+	if err = it.Err(); err != nil {
+		return nil, nil, err
+	}
+	for key := range ents {
+		if _, ok := z.delegations[key]; !ok {
+			z.delegations[key] = false
+		}
+	}
+	sort.Slice(rrsets, func(i, j int) bool {
+		if c := rrsets[i].name.Compare(rrsets[j].name); c != 0 {
+			return c < 0
+		}
+		return rrsets[i].qtype < rrsets[j].qtype
+	})
+	return rrsets, ents, nil
+}
+
+func rectifyRRSets(ctx context.Context, r *storage.Repositories, z *dnssecZone, rrsets []*rectifiedName, result *RectifyResult) error {
+	for _, n := range rrsets {
+		want := &storage.Record{Name: n.name.String(), Type: n.qtype}
+		if err := z.rectify(ctx, r, want); err != nil {
+			return err
+		}
+		if !n.mixed && n.orderName == want.OrderName && n.auth == want.Auth {
+			continue
+		}
+		_, err := r.Records.SetOrderNameAndAuth(ctx, z.id, want.Name, want.Type, want.OrderName, want.Auth)
+		if err != nil {
+			return stacktrace.Wrap(err)
+		}
+		result.Updated = append(result.Updated, RectifiedRRSet{
+			Name:      want.Name,
+			Type:      want.Type,
+			OrderName: want.OrderName,
+			Auth:      want.Auth,
+		})
+	}
+	return nil
+}
+
+// emptyNonTerminals returns the names between the apex and a record that
+// have no records of their own, in canonical order.
+func emptyNonTerminals(z *dnssecZone, rrsets []*rectifiedName) []DNSName {
+	required := make([]DNSName, 0)
+	seen := make(map[string]bool)
+	for _, n := range rrsets {
+		seen[n.name.String()] = true
+	}
+	for _, n := range rrsets {
+		for parent := n.name.Parent(); parent.CountLabels() > z.name.CountLabels(); parent = parent.Parent() {
+			key := parent.String()
+			if seen[key] {
+				break
+			}
+			seen[key] = true
+			required = append(required, parent)
+		}
+	}
+	sort.Slice(required, func(i, j int) bool {
+		return required[i].Compare(required[j]) < 0
+	})
+	return required
+}
+
+// rectifyENTs keeps an empty non-terminal row for each name
+// emptyNonTerminals requires and deletes the others.
+func rectifyENTs(ctx context.Context, r *storage.Repositories, z *dnssecZone, rrsets []*rectifiedName, ents map[string]*rectifiedName, result *RectifyResult) error {
+	for _, name := range emptyNonTerminals(z, rrsets) {
+		key := name.String()
+		if _, ok := z.delegations[key]; !ok {
+			z.delegations[key] = false
+		}
+		want := &storage.Record{Name: key}
+		if err := z.rectify(ctx, r, want); err != nil {
+			return err
+		}
+		found, ok := ents[key]
+		delete(ents, key)
+		switch {
+		case !ok:
+			if err := r.Records.InsertEmptyNonTerminal(ctx, z.id, key, want.OrderName, want.Auth); err != nil {
+				return stacktrace.Wrap(err)
+			}
+			result.AddedENTs = append(result.AddedENTs, key)
+		case found.mixed || found.orderName != want.OrderName || found.auth != want.Auth:
+			if _, err := r.Records.SetOrderNameAndAuth(ctx, z.id, key, "", want.OrderName, want.Auth); err != nil {
+				return stacktrace.Wrap(err)
+			}
+			result.Updated = append(result.Updated, RectifiedRRSet{Name: key, OrderName: want.OrderName, Auth: want.Auth})
+		}
+	}
+	stale := make([]string, 0, len(ents))
+	for key := range ents {
+		stale = append(stale, key)
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		if _, err := r.Records.DeleteEmptyNonTerminal(ctx, z.id, key); err != nil {
+			return stacktrace.Wrap(err)
+		}
+		result.RemovedENTs = append(result.RemovedENTs, key)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_RectifyZone(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	r := store.Repositories()
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 1, Name: "rect.test.", Kind: "NATIVE"}), nil)
+	// written by hand, as with SQL
+	_, err := r.Records.Insert(ctx,
+		&storage.Record{DomainID: 1, Name: "rect.test.", Type: "SOA", Content: "ns.rect.test. h.rect.test. 1 2 3 4 5"},
+		&storage.Record{DomainID: 1, Name: "www.rect.test.", Type: "A", Content: "192.0.2.1", OrderName: "www", Auth: true},
+		&storage.Record{DomainID: 1, Name: "a.b.rect.test.", Type: "A", Content: "192.0.2.2"},
+		&storage.Record{DomainID: 1, Name: "sub.rect.test.", Type: "NS", Content: "ns.sub.rect.test.", Auth: true},
+		&storage.Record{DomainID: 1, Name: "ns.sub.rect.test.", Type: "A", Content: "192.0.2.3", OrderName: "sub ns", Auth: true},
+		&storage.Record{DomainID: 1, Name: "gone.rect.test.", OrderName: "gone", Auth: true},
+	)
+	assert.Equal(t, err, nil)
+	s := New(nil, true, WithStore(store))
+
+	result, err := s.RectifyZone(ctx, MustParseDNSName("RECT.test"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Zone, "rect.test.")
+	assert.Equal(t, result.Updated, []RectifiedRRSet{
+		{Name: "rect.test.", Type: "SOA", Auth: true},
+		{Name: "a.b.rect.test.", Type: "A", OrderName: "b a", Auth: true},
+		{Name: "sub.rect.test.", Type: "NS", OrderName: "sub", Auth: false},
+		{Name: "ns.sub.rect.test.", Type: "A", Auth: false},
+	})
+	assert.Equal(t, result.AddedENTs, []string{"b.rect.test."})
+	assert.Equal(t, result.RemovedENTs, []string{"gone.rect.test."})
+
+	ent, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "b.rect.test.", DomainID: 1})
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(ent), 1) {
+		assert.Equal(t, ent[0].Type, "")
+		assert.Equal(t, ent[0].OrderName, "b")
+	}
+	changes, err := s.ChangesSince(ctx, 0, 1, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changes[len(changes)-1].Operation, OpRectifyZone)
+
+	result, err = s.RectifyZone(ctx, MustParseDNSName("rect.test."))
+	assert.Equal(t, err, nil)
+	assert.False(t, result.Changed())
+
+	_, err = s.RectifyZone(ctx, MustParseDNSName("missing.test."))
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,53 @@
+//go:build cgo
+// +build cgo
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/fault"
+	"github.com/ivan-bokov/go-pdns/internal/storage/retry"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_RetryTransaction(t *testing.T) {
+	ctx := context.Background()
+	db := sqlite.New(":memory:")
+	defer db.Close()
+	assert.Equal(t, db.CreateTable(), nil)
+	faulty := fault.New(db, fault.WithSeed(1))
+	retrying := retry.New(faulty, retry.Policy{MaxAttempts: 20, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	s := New(retrying, false, WithRetry(retrying))
+	assert.Equal(t, s.CreateSlaveDomain(ctx, "192.0.2.1", MustParseDNSName("retry.test.")), nil)
+	info, err := s.GetDomainInfo(ctx, MustParseDNSName("retry.test."))
+	assert.Equal(t, err, nil)
+
+	// the insert runs inside the transaction of FeedRecord
+	faulty.Set(fault.Rule{Stmt: "insert-record-query", ErrorRate: 0.5, Transient: true})
+	for i := 0; i < 10; i++ {
+		rr := &DNSResourceRecord{DomainID: info.ID, Qname: fmt.Sprintf("h%d.retry.test.", i), Qtype: "A", Content: "192.0.2.1", TTL: 300}
+		assert.Equal(t, s.FeedRecord(ctx, rr, ""), nil)
+	}
+	injected := faulty.Rules()[0].Injected
+	assert.Greater(t, injected, uint64(0))
+	assert.Equal(t, retrying.Stats().Retries, injected)
+	rrs, err := s.List(ctx, MustParseDNSName("retry.test."), info.ID, false)
+	assert.Equal(t, err, nil)
+	n := 0
+	for rrs.Next() {
+		n++
+	}
+	assert.Equal(t, rrs.Close(), nil)
+	assert.Equal(t, n, 10)
+
+	// without WithRetry the transaction fails
+	faulty.Set(fault.Rule{Stmt: "insert-record-query", ErrorRate: 1, Transient: true})
+	rr := &DNSResourceRecord{DomainID: info.ID, Qname: "once.retry.test.", Qtype: "A", Content: "192.0.2.1", TTL: 300}
+	assert.True(t, storage.IsTransient(New(retrying, false).FeedRecord(ctx, rr, "")))
+}
@@ -1,307 +1,439 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
 	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
 	"go.uber.org/zap"
 )
 
 type Service struct {
-	dnssec bool
-	stg    storage.IStorage
-	logger *zap.Logger
-}
+	dnssec    bool
+	store     storage.Store
+	logger    *zap.Logger
+	timeouts  Timeouts
+	batchSize int
+	// pdnsVersion is the major version of the PowerDNS served
+	pdnsVersion int
+	zones       zoneCache
+	retrier     Retrier
 
-func New(stg storage.IStorage, dnssec bool) *Service {
-	return &Service{
-		dnssec: dnssec,
-		stg:    stg,
-		logger: zap.NewExample(),
-	}
+	trxMu sync.Mutex
+	trx   map[int]*RecordWriter
+	// trxIdle is how long a transaction is kept without calls
+	trxIdle time.Duration
 }
 
-func (s *Service) SetNotified(domainID int, serial int) error {
-	_, err := s.stg.Exec(
-		"update-serial-query",
-		"serial", serial,
-		"domain_id", domainID,
-	)
-	if err != nil {
-		return stacktrace.Wrap(err)
+// New serves stg through the SQL statement catalogue, WithStore plugs in
+// any other implementation of the repositories.
+func New(stg storage.IStorage, dnssec bool, opts ...Option) *Service {
+	s := &Service{
+		dnssec:      dnssec,
+		logger:      zap.NewExample(),
+		batchSize:   1000,
+		pdnsVersion: 4,
+		zones:       zoneCache{ttl: 10 * time.Second},
+		trx:         make(map[int]*RecordWriter),
+		trxIdle:     5 * time.Minute,
 	}
-	return nil
+	if stg != nil {
+		s.store = catalog.New(stg)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *Service) setLastCheck(domainID int, lastcheck int64) error {
-	_, err := s.stg.Exec(
-		"update-lastcheck-query",
-		"last_check", lastcheck,
-		"domain_id", domainID,
-	)
-	if err != nil {
-		return stacktrace.Wrap(err)
-	}
-	return nil
+func (s *Service) repos() *storage.Repositories {
+	return s.store.Repositories()
 }
 
-func (s *Service) SetFresh(domainID int) error {
-	return s.setLastCheck(domainID, time.Now().UTC().Unix())
+func (s *Service) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = s.timeouts.Default
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
-func (s *Service) Lookup(qtype string, qname string, zoneID int) ([]*DNSResourceRecord, error) {
-	var err error
-	listRR := make([]*DNSResourceRecord, 0)
-	var rows storage.IResult
-	if qtype != "ANY" {
-		if zoneID < 0 {
-			rows, err = s.stg.Query(
-				"basic-query",
-				"qtype", qtype,
-				"qname", qname,
-			)
-		} else {
-			rows, err = s.stg.Query(
-				"id-query",
-				"qtype", qtype,
-				"qname", qname,
-				"domain_id", zoneID,
-			)
+func (s *Service) SetNotified(ctx context.Context, domainID int, serial int) error {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	return s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		zone, err := r.Zones.ByID(ctx, domainID)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		if zoneID < 0 {
-			rows, err = s.stg.Query(
-				"any-query",
-				"qname", qname,
-			)
-		} else {
-			rows, err = s.stg.Query(
-				"any-id-query",
-				"qname", qname,
-				"domain_id", zoneID,
-			)
+		if err = r.Zones.SetNotified(ctx, domainID, int64(serial)); err != nil {
+			return nil, err
 		}
-	}
-	for rows.Next() {
-		rr := new(DNSResourceRecord)
-		err = rows.Scan(&rr.Content, &rr.TTL, &rr.Prio, &rr.Qtype, &rr.DomainID, &rr.Disabled, &rr.Qname, &rr.Auth)
+		c, err := newChange(domainID, OpSetNotified,
+			map[string]int64{"serial": zone.NotifiedSerial}, map[string]int{"serial": serial})
+		return []*Change{c}, err
+	})
+}
+
+func (s *Service) setLastCheck(ctx context.Context, domainID int, lastcheck int64) error {
+	return s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		zone, err := r.Zones.ByID(ctx, domainID)
 		if err != nil {
-			//TODO Добавить логирование
-			continue
+			return nil, err
 		}
-		listRR = append(listRR, rr)
-	}
-	return listRR, err
+		if err = r.Zones.SetLastCheck(ctx, domainID, lastcheck); err != nil {
+			return nil, err
+		}
+		c, err := newChange(domainID, OpSetFresh,
+			map[string]int64{"last_check": zone.LastCheck}, map[string]int64{"last_check": lastcheck})
+		return []*Change{c}, err
+	})
+}
+
+func (s *Service) SetFresh(ctx context.Context, domainID int) error {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	return s.setLastCheck(ctx, domainID, time.Now().UTC().Unix())
 }
 
-func (s *Service) List(zonename string, domainID int, includeDisabled bool) ([]*DNSResourceRecord, error) {
+// Lookup searches the zone zoneID, or when it is negative the closest
+// zone enclosing qname, its parent for DS records.
+func (s *Service) Lookup(ctx context.Context, qtype QType, qname DNSName, zoneID int) ([]*DNSResourceRecord, error) {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.Lookup)
+	defer cancel()
 	listRR := make([]*DNSResourceRecord, 0)
-	if domainID < 0 {
-		rows, err := s.stg.Query(
-			"get-domain-id",
-			"domain", zonename,
-		)
-		if err != nil {
-			return listRR, stacktrace.Wrap(err)
+	if qtype.IsQueryOnly() && qtype != TypeANY {
+		return listRR, stacktrace.Newf("Cannot look up %s", qtype)
+	}
+	if zoneID < 0 {
+		owner := qname
+		if qtype == TypeDS && qname.CountLabels() > 0 {
+			owner = qname.Parent()
 		}
-		if rows.Next() {
-			err = rows.Scan(domainID)
-			if err != nil {
-				return listRR, stacktrace.Wrap(err)
-			}
-		} else {
-			return listRR, stacktrace.New(fmt.Sprintf("Domain not found: %s", zonename))
+		id, _, err := s.bestZone(ctx, owner)
+		if errors.Is(err, storage.ErrNotFound) {
+			return listRR, nil
 		}
+		if err != nil {
+			return listRR, err
+		}
+		zoneID = id
 	}
-	rows, err := s.stg.Query(
-		"list-query",
-		"include_disabled", includeDisabled,
-		"domain_id", domainID,
-	)
+	records, err := s.repos().Records.Lookup(ctx, storage.LookupQuery{
+		Name:     qname.Canonical().String(),
+		Type:     qtype.String(),
+		DomainID: zoneID,
+	})
 	if err != nil {
 		return listRR, stacktrace.Wrap(err)
 	}
-	for rows.Next() {
-		rr := new(DNSResourceRecord)
-		err = rows.Scan(&rr.Content, &rr.TTL, &rr.Prio, &rr.Qtype, &rr.DomainID, &rr.Disabled, &rr.Qname, &rr.Auth)
+	for _, rr := range records {
+		listRR = append(listRR, s.fromRecord(rr))
+	}
+	return listRR, nil
+}
+
+func (s *Service) List(ctx context.Context, zone DNSName, domainID int, includeDisabled bool) (*RecordIterator, error) {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.List)
+	if domainID < 0 {
+		id, err := s.repos().Zones.ID(ctx, zone.Canonical().String())
 		if err != nil {
-			//TODO Добавить логирование
-			continue
+			cancel()
+			return nil, err
 		}
-		listRR = append(listRR, rr)
+		domainID = id
 	}
-	return listRR, err
+	it, err := s.repos().Records.List(ctx, domainID, includeDisabled)
+	if err != nil {
+		cancel()
+		return nil, stacktrace.Wrap(err)
+	}
+	return newRecordIterator(it, s.fromRecord, cancel), nil
 }
 
-func (s *Service) GetBeforeAndAfterNamesAbsolute(id int, qname string) error {
+func (s *Service) GetBeforeAndAfterNamesAbsolute(ctx context.Context, id int, qname string) error {
 	if !s.dnssec {
 		return stacktrace.New("Only for DNSSEC")
 	}
 	return stacktrace.New("No implementation")
 }
 
-func (s *Service) SetDomainMetadata(name string, kind string, meta []string) error {
+func (s *Service) SetDomainMetadata(ctx context.Context, zone DNSName, kind string, meta []string) error {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
 	if !s.dnssec {
 		return stacktrace.New("Only for DNSSEC")
 	}
-	_, err := s.stg.Exec("clear-domain-metadata-query",
-		"domain", name,
-		"kind", kind,
-	)
-	if err != nil {
-		return stacktrace.Wrap(err)
-	}
-	errors := make([]error, 0)
-	if len(meta) != 0 {
-		for _, m := range meta {
-			_, err = s.stg.Exec("set-domain-metadata-query",
-				"kind", kind,
-				"content", m,
-				"domain", name,
-			)
-			if err != nil {
-				errors = append(errors, stacktrace.Newf("%v", err))
-			}
+	name := zone.Canonical().String()
+	return s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		domainID, err := r.Zones.ID(ctx, name)
+		if err != nil {
+			return nil, err
 		}
-	}
-	if len(errors) != 0 {
-		return stacktrace.New(fmt.Sprintf("Unable to set metadata kind %s for domain %s", kind, name))
-	}
-	return nil
+		before, err := r.Metadata.Get(ctx, name, kind)
+		if err != nil {
+			return nil, err
+		}
+		if err = r.Metadata.Set(ctx, name, kind, meta); err != nil {
+			return nil, err
+		}
+		c, err := newChange(domainID, OpSetMetadata,
+			map[string][]string{kind: before},
+			map[string][]string{kind: meta},
+		)
+		return []*Change{c}, err
+	})
 }
 
-func (s *Service) AddDomainKey(name string, key *KeyData) error {
+func (s *Service) AddDomainKey(ctx context.Context, zone DNSName, key *KeyData) error {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
 	if !s.dnssec {
 		return stacktrace.New("Only for DNSSEC")
 	}
-	_, err := s.stg.Exec("add-domain-key-query",
-		"domain", name,
-		"flags", key.Flags,
-		"active", key.Active,
-		"published", key.Published,
-		"content", key.Content,
-	)
+	name := zone.Canonical().String()
+	return s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		n, err := r.Keys.Add(ctx, name, &storage.Key{
+			Flags:     key.Flags,
+			Active:    key.Active,
+			Published: key.Published,
+			Content:   key.Content,
+		})
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		domainID, err := r.Zones.ID(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		// private key material never goes into the journal
+		c, err := newChange(domainID, OpAddKey, nil, &KeyData{
+			Flags:     key.Flags,
+			Active:    key.Active,
+			Published: key.Published,
+		})
+		return []*Change{c}, err
+	})
+}
+
+func (s *Service) FeedRecord(ctx context.Context, rr *DNSResourceRecord, ordername string) error {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.FeedRecord)
+	defer cancel()
+	normalized, norm, err := s.toRecord(rr, ordername)
 	if err != nil {
-		return stacktrace.Wrap(err)
+		return err
 	}
-	return nil
+	return s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		record := *normalized
+		var zone *dnssecZone
+		if s.dnssec {
+			var err error
+			if zone, err = loadDNSSECZone(ctx, r, record.DomainID, record.Name); err != nil {
+				return nil, err
+			}
+			if record.Type == TypeNS.String() {
+				// knowing there was no delegation, rectify marks it redelegated
+				name, err := ParseDNSName(record.Name)
+				if err != nil {
+					return nil, err
+				}
+				if !name.Equal(zone.name) {
+					if _, err = zone.delegated(ctx, r, name); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err = zone.rectify(ctx, r, &record); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := r.Records.Insert(ctx, &record); err != nil {
+			return nil, err
+		}
+		c, err := newChange(record.DomainID, OpInsertRecord, nil, journaled(norm, &record))
+		if err != nil || zone == nil {
+			return []*Change{c}, err
+		}
+		fixed, err := rectifyRedelegated(ctx, r, zone)
+		if err != nil || fixed == nil {
+			return []*Change{c}, err
+		}
+		return []*Change{c, fixed}, nil
+	})
 }
 
-func (s *Service) FeedRecord(rr *DNSResourceRecord, ordername string) error {
-	var oName interface{}
+// toRecord stores the name, type and content of rr in canonical form. It
+// returns a copy of rr normalized the same way too, rr is left as it is.
+func (s *Service) toRecord(rr *DNSResourceRecord, ordername string) (*storage.Record, *DNSResourceRecord, error) {
+	qname, err := ParseDNSName(rr.Qname)
+	if err != nil {
+		return nil, nil, err
+	}
+	qtype, err := ParseQType(rr.Qtype)
+	if err != nil {
+		return nil, nil, err
+	}
+	if qtype.IsQueryOnly() {
+		return nil, nil, stacktrace.Newf("%s is not a record type", qtype)
+	}
+	content := rr.Content
+	if qtype.HasPriority() {
+		content = withPriority(qtype, rr.Prio, content)
+	}
+	content, err = NormalizeContent(qtype, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	norm := *rr
+	norm.Qname = qname.String()
+	norm.Qtype = qtype.String()
+	norm.Content = content
+	norm.Prio = 0
 	prio := 0
 	auth := true
-	content := rr.Content
-	if rr.Qtype == "MX" || rr.Qtype == "SRV" {
-		pos := FindFirstNotOf(content, "0123456789")
-		if pos != -1 {
-			//TODO Сделать очистку до первых цифр
-		}
+	if qtype.HasPriority() {
+		prio, content = splitPriority(content)
 	}
 	if s.dnssec {
 		auth = rr.Auth
 	}
-	if ordername == "" {
-		oName = nil
-	} else {
-		oName = []byte(strings.ToLower(ordername))
-	}
-	_, err := s.stg.Exec("insert-record-query",
-		"content", content,
-		"ttl", rr.TTL,
-		"priority", prio,
-		"qtype", rr.Qtype,
-		"domain_id", rr.DomainID,
-		"disabled", rr.Disabled,
-		"qname", rr.Qname,
-		"auth", auth,
-		"ordername", oName,
-	)
-
-	return err
+	return (&storage.Record{
+		DomainID:  rr.DomainID,
+		Name:      norm.Qname,
+		Type:      norm.Qtype,
+		Content:   content,
+		TTL:       rr.TTL,
+		Prio:      prio,
+		Disabled:  rr.Disabled,
+		OrderName: strings.ToLower(ordername),
+		Auth:      auth,
+	}).Normalized(), &norm, nil
 }
 
-func (s *Service) CreateSlaveDomain(ip string, domain string) error {
-	_, err := s.stg.Exec("insert-zone-query",
-		"domain", domain,
-		"account", "",
-		"masters", fmt.Sprintf("%s:53", ip),
-		"type", "SLAVE",
-	)
-	return err
+// journaled is rr as the journal keeps it, with the zone, auth and
+// ordername record was written with.
+func journaled(rr *DNSResourceRecord, record *storage.Record) *DNSResourceRecord {
+	out := *rr
+	out.DomainID, out.Auth, out.OrderName = record.DomainID, record.Auth, record.OrderName
+	return &out
 }
 
-func (s *Service) GetAllDomainMetadata(name string) (map[string][]string, error) {
-	meta := make(map[string][]string)
-	rows, err := s.stg.Query(
-		"get-all-domain-metadata-query",
-		"domain", name,
-	)
-	if err != nil {
-		return meta, stacktrace.Wrap(err)
+// recordFields copies rr as stored, with names in the case they were
+// written with. The method fromRecord presents it to PowerDNS.
+func recordFields(rr *storage.Record) *DNSResourceRecord {
+	qname := rr.Name
+	if rr.DisplayName != "" {
+		qname = rr.DisplayName
+	}
+	return &DNSResourceRecord{
+		Qname:     qname,
+		OrderName: rr.OrderName,
+		Content:   rr.Content,
+		TTL:       rr.TTL,
+		DomainID:  rr.DomainID,
+		Qtype:     rr.Type,
+		Auth:      rr.Auth,
+		Disabled:  rr.Disabled,
+		Prio:      rr.Prio,
 	}
-	for rows.Next() {
-		var m1, m2 string
-		err = rows.Scan(m1, m2)
+}
+
+func (s *Service) CreateSlaveDomain(ctx context.Context, ip string, zone DNSName) error {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	domain := zone.Canonical().String()
+	err := s.mutate(ctx, func(r *storage.Repositories) ([]*Change, error) {
+		masters := fmt.Sprintf("%s:53", ip)
+		err := r.Zones.Create(ctx, &storage.Zone{
+			Name:   domain,
+			Master: masters,
+			Kind:   "SLAVE",
+		})
 		if err != nil {
-			return make(map[string][]string), stacktrace.Wrap(err)
+			return nil, err
 		}
-		if _, ok := meta[m1]; !ok {
-			meta[m1] = make([]string, 0, 10)
+		domainID, err := r.Zones.ID(ctx, domain)
+		if err != nil {
+			return nil, err
 		}
-		meta[m1] = append(meta[m1], m2)
+		c, err := newChange(domainID, OpCreateDomain, nil, &DomainInfo{
+			ID:     domainID,
+			Zone:   domain,
+			Kind:   "SLAVE",
+			Master: []string{masters},
+		})
+		return []*Change{c}, err
+	})
+	if err == nil {
+		s.zones.invalidate()
+	}
+	return err
+}
+
+func (s *Service) GetAllDomainMetadata(ctx context.Context, zone DNSName) (map[string][]string, error) {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	meta, err := s.repos().Metadata.GetAll(ctx, zone.Canonical().String())
+	if err != nil {
+		return make(map[string][]string), stacktrace.Wrap(err)
 	}
 	return meta, nil
 }
 
-func (s *Service) GetDomainInfo(name string) (*DomainInfo, error) {
-	rows, err := s.stg.Query(
-		"info-zone-query",
-		"domain", name,
-	)
+func (s *Service) GetDomainInfo(ctx context.Context, name DNSName) (*DomainInfo, error) {
+	ctx, cancel := s.withTimeout(ctx, 0)
+	defer cancel()
+	zone, err := s.repos().Zones.Get(ctx, name.Canonical().String())
 	if err != nil {
 		return new(DomainInfo), stacktrace.Wrap(err)
 	}
-	di := new(DomainInfo)
-	if rows.Next() {
-		master := ""
-		err = rows.Scan(&di.ID, &di.Zone, &master, &di.LastCheck, &di.Serial, &di.Kind, &di.Account)
-		if err != nil {
-			log.Println("[ERROR] " + err.Error())
-			return new(DomainInfo), stacktrace.Wrap(err)
-		}
-		if master != "" {
-			di.Master = StringTok(master, " ,\t")
-		}
-	}
-	return di, nil
+	return domainInfo(zone, zone.NotifiedSerial), nil
 }
 
-func (s *Service) GetAllDomains(includeDisabled bool) ([]*DomainInfo, error) {
-	rows, err := s.stg.Query(
-		"get-all-domains-query",
-		"include_disabled", includeDisabled,
-	)
+func (s *Service) GetAllDomains(ctx context.Context, includeDisabled bool) ([]*DomainInfo, error) {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.List)
+	defer cancel()
+	zones, err := s.repos().Zones.List(ctx, includeDisabled)
 	if err != nil {
 		return nil, stacktrace.Wrap(err)
 	}
-	dis := make([]*DomainInfo, 0, 10)
-	for rows.Next() {
-		di := new(DomainInfo)
-		master := ""
-		err = rows.Scan(&di.ID, &di.Zone, &master, &di.LastCheck, &di.Serial, &di.Kind, &di.Account)
-		if err != nil {
-			log.Println("[ERROR] " + err.Error())
-			return nil, stacktrace.Wrap(err)
-		}
-		if master != "" {
-			di.Master = StringTok(master, " ,\t")
-		}
-		dis = append(dis, di)
+	dis := make([]*DomainInfo, 0, len(zones))
+	for _, zone := range zones {
+		dis = append(dis, domainInfo(zone, soaSerial(zone.SOA)))
 	}
 	return dis, nil
 }
+
+func domainInfo(zone *storage.Zone, serial int64) *DomainInfo {
+	di := &DomainInfo{
+		ID:        zone.ID,
+		Zone:      zone.Name,
+		Kind:      zone.Kind,
+		Serial:    serial,
+		LastCheck: zone.LastCheck,
+		Account:   zone.Account,
+	}
+	if zone.Master != "" {
+		di.Master = StringTok(zone.Master, " ,\t")
+	}
+	return di
+}
+
+// soaSerial returns the third field of SOA content, 0 if there is none.
+func soaSerial(content string) int64 {
+	fields := StringTok(content, " \t")
+	if len(fields) < 3 {
+		return 0
+	}
+	serial, _ := strconv.ParseInt(fields[2], 10, 64)
+	return serial
+}
@@ -1,22 +1,19 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
-	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
-	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
 	"github.com/stretchr/testify/assert"
 )
 
 var service *Service
 
 func init() {
-	storage := sqlite.New(":memory:")
-	err := storage.CreateTable()
-	if err != nil {
-		panic(stacktrace.Wrap(err))
-	}
-	service = New(storage, true)
+	service = New(nil, true, WithStore(memory.New()))
 }
 
 func TestService_AddDomainKey(t *testing.T) {
@@ -32,30 +29,293 @@ func TestService_AddDomainKey(t *testing.T) {
 		Published: true,
 		Content:   "Private-key-format: v1.2\\nAlgorithm: 5 (RSASHA1)\\nModulus: tY2TAMgL/whZdSbn2aci4wcMqohO24KQAaq5RlTRwQ33M8FYdW5fZ3DMdMsSLQUkjGnKJPKEdN3Qd4Z5b18f+w==\\nPublicExponent: AQAB\\nPrivateExponent: BB6xibPNPrBV0PUp3CQq0OdFpk9v9EZ2NiBFrA7osG5mGIZICqgOx/zlHiHKmX4OLmL28oU7jPKgogeuONXJQQ==\\nPrime1: yjxe/iHQ4IBWpvCmuGqhxApWF+DY9LADIP7bM3Ejf3M=\\nPrime2: 5dGWTyYEQRBVK74q1a64iXgaNuYm1pbClvvZ6ccCq1k=\\nExponent1: TwM5RebmWeAqerzJFoIqw5IaQugJO8hM4KZR9A4/BTs=\\nExponent2: bpV2HSmu3Fvuj7jWxbFoDIXlH0uJnrI2eg4/4hSnvSk=\\nCoefficient: e2uDDWN2zXwYa2P6VQBWQ4mR1ZZjFEtO/+YqOJZun1Y=",
 	}
-	assert.Equal(t, service.AddDomainKey("unit.test.", k1), nil)
-	assert.Equal(t, service.AddDomainKey("unit.test.", k2), nil)
+	assert.Equal(t, service.AddDomainKey(context.Background(), MustParseDNSName("unit.test."), k1), nil)
+	assert.Equal(t, service.AddDomainKey(context.Background(), MustParseDNSName("unit.test."), k2), nil)
 }
 
 func TestService_CreateSlaveDomain(t *testing.T) {
-	assert.Equal(t, service.CreateSlaveDomain("10.0.0.1", "example.com."), nil)
+	assert.Equal(t, service.CreateSlaveDomain(context.Background(), "10.0.0.1", MustParseDNSName("example.com.")), nil)
 }
 
 func TestFeedRecord(t *testing.T) {
 	//service.StartTransaction()
+	info, err := service.GetDomainInfo(context.Background(), MustParseDNSName("example.com."))
+	assert.Equal(t, err, nil)
 	rr := &DNSResourceRecord{
-		Qname:   "example.com.",
-		Content: "ns1.example.com. hostmaster.example.com. 2013013441 7200 3600 1209600 300",
-		TTL:     300,
-		Qtype:   "SOA",
-		Qclass:  "IN",
+		DomainID: info.ID,
+		Qname:    "example.com.",
+		Content:  "ns1.example.com. hostmaster.example.com. 2013013441 7200 3600 1209600 300",
+		TTL:      300,
+		Qtype:    "SOA",
+		Qclass:   "IN",
 	}
-	assert.Equal(t, service.FeedRecord(rr, ""), nil, "Не удалось записать")
+	assert.Equal(t, service.FeedRecord(context.Background(), rr, ""), nil, "Не удалось записать")
 	rr = &DNSResourceRecord{
-		Qname:   "replace.example.com.",
-		Content: "127.0.0.1",
-		TTL:     300,
-		Qtype:   "A",
-		Qclass:  "IN",
+		DomainID: info.ID,
+		Qname:    "replace.example.com.",
+		Content:  "127.0.0.1",
+		TTL:      300,
+		Qtype:    "A",
+		Qclass:   "IN",
+	}
+	assert.Equal(t, service.FeedRecord(context.Background(), rr, ""), nil, "Не удалось записать")
+}
+
+func TestService_LookupCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := service.Lookup(ctx, TypeA, MustParseDNSName("replace.example.com."), -1)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestService_LookupTimeout(t *testing.T) {
+	svc := New(nil, true, WithStore(service.store), WithTimeouts(Timeouts{Lookup: time.Nanosecond}))
+	_, err := svc.Lookup(context.Background(), TypeA, MustParseDNSName("replace.example.com."), -1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestService_List(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, service.CreateSlaveDomain(ctx, "10.0.0.2", MustParseDNSName("list.test.")), nil)
+	for _, rr := range []*DNSResourceRecord{
+		{Qname: "list.test.", Qtype: "SOA", Content: "ns1.list.test. hostmaster.list.test. 1 7200 3600 1209600 300", TTL: 300},
+		{Qname: "www.list.test.", Qtype: "A", Content: "127.0.0.1", TTL: 300},
+		{Qname: "list.test.", Qtype: "NS", Content: "ns1.list.test.", TTL: 300},
+	} {
+		rr.DomainID = listTestDomainID(t)
+		assert.Equal(t, service.FeedRecord(ctx, rr, ""), nil)
+	}
+	it, err := service.List(ctx, MustParseDNSName("list.test."), -1, false)
+	assert.Equal(t, err, nil)
+	defer it.Close()
+	names := make([]string, 0)
+	for it.Next() {
+		names = append(names, it.Record().Qname+" "+it.Record().Qtype)
 	}
-	assert.Equal(t, service.FeedRecord(rr, ""), nil, "Не удалось записать")
+	assert.Equal(t, it.Err(), nil)
+	assert.Equal(t, names, []string{"list.test. NS", "list.test. SOA", "www.list.test. A"})
+
+	_, err = service.List(ctx, MustParseDNSName("missing.test."), -1, false)
+	assert.NotEqual(t, err, nil)
+}
+
+func listTestDomainID(t *testing.T) int {
+	id, err := service.repos().Zones.ID(context.Background(), "list.test.")
+	assert.Equal(t, err, nil)
+	return id
+}
+
+func TestService_Transaction(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, service.CreateSlaveDomain(ctx, "10.0.0.3", MustParseDNSName("trx.test.")), nil)
+	domainID, err := service.repos().Zones.ID(ctx, "trx.test.")
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, service.StartTransaction(ctx, 1, domainID, MustParseDNSName("trx.test.")), nil)
+	assert.NotEqual(t, service.StartTransaction(ctx, 1, domainID, MustParseDNSName("trx.test.")), nil)
+	for i := 0; i < 2500; i++ {
+		rr := &DNSResourceRecord{Qname: fmt.Sprintf("h%d.trx.test.", i), Qtype: "A", Content: "127.0.0.1", TTL: 300}
+		assert.Equal(t, service.FeedTransactionRecord(ctx, 1, rr, ""), nil)
+	}
+	assert.Equal(t, service.CommitTransaction(ctx, 1), nil)
+	assert.Equal(t, countRecords(t, "trx.test."), 2500)
+
+	assert.Equal(t, service.StartTransaction(ctx, 2, domainID, MustParseDNSName("trx.test.")), nil)
+	rr := &DNSResourceRecord{Qname: "trx.test.", Qtype: "A", Content: "127.0.0.1", TTL: 300}
+	assert.Equal(t, service.FeedTransactionRecord(ctx, 2, rr, ""), nil)
+	assert.Equal(t, service.AbortTransaction(ctx, 2), nil)
+	assert.Equal(t, countRecords(t, "trx.test."), 2500)
+
+	assert.NotEqual(t, service.FeedTransactionRecord(ctx, 2, rr, ""), nil)
+	assert.NotEqual(t, service.CommitTransaction(ctx, 2), nil)
+}
+
+func TestService_StartTransactionConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil, false, WithStore(memory.New()))
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- s.StartTransaction(ctx, 1, -1, MustParseDNSName("race.test."))
+		}()
+	}
+	started := 0
+	for i := 0; i < cap(errs); i++ {
+		if <-errs == nil {
+			started++
+		}
+	}
+	assert.Equal(t, started, 1)
+	assert.Equal(t, s.AbortTransaction(ctx, 1), nil)
+}
+
+func TestService_TransactionIdle(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil, false, WithStore(memory.New()), WithTransactionIdleTimeout(50*time.Millisecond))
+	assert.Equal(t, s.StartTransaction(ctx, 1, -1, MustParseDNSName("idle.test.")), nil)
+	rr := &DNSResourceRecord{Qname: "idle.test.", Qtype: "A", Content: "192.0.2.1", TTL: 300}
+	// calls keep the transaction
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, s.FeedTransactionRecord(ctx, 1, rr, ""), nil)
+	}
+	time.Sleep(150 * time.Millisecond)
+	assert.NotEqual(t, s.FeedTransactionRecord(ctx, 1, rr, ""), nil)
+	assert.NotEqual(t, s.CommitTransaction(ctx, 1), nil)
+	// the id is free and the storage no longer held
+	assert.Equal(t, s.StartTransaction(ctx, 1, -1, MustParseDNSName("idle.test.")), nil)
+	assert.Equal(t, s.CommitTransaction(ctx, 1), nil)
+}
+
+func countRecords(t *testing.T, zone string) int {
+	it, err := service.List(context.Background(), MustParseDNSName(zone), -1, false)
+	assert.Equal(t, err, nil)
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		n++
+	}
+	assert.Equal(t, it.Err(), nil)
+	return n
+}
+
+func TestService_TSIGKey(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, service.SetTSIGKey(ctx, &TSIGKey{Name: "xfr.", Algorithm: "hmac-sha256", Content: "c2VjcmV0"}), nil)
+	key, err := service.GetTSIGKey(ctx, MustParseDNSName("xfr."))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, key.Content, "c2VjcmV0")
+	keys, err := service.GetTSIGKeys(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(keys), 1)
+	assert.Equal(t, service.DeleteTSIGKey(ctx, MustParseDNSName("xfr.")), nil)
+	_, err = service.GetTSIGKey(ctx, MustParseDNSName("xfr."))
+	assert.NotEqual(t, err, nil)
+}
+
+func TestService_Journal(t *testing.T) {
+	ctx := WithActor(context.Background(), "unit-test")
+	last := lastChange(t)
+	assert.Equal(t, service.CreateSlaveDomain(ctx, "10.0.0.4", MustParseDNSName("journal.test.")), nil)
+	domainID, err := service.repos().Zones.ID(ctx, "journal.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, service.SetDomainMetadata(ctx, MustParseDNSName("journal.test."), "ALLOW-AXFR-FROM", []string{"AUTO-NS"}), nil)
+	assert.Equal(t, service.SetDomainMetadata(ctx, MustParseDNSName("journal.test."), "ALLOW-AXFR-FROM", []string{"10.0.0.0/8"}), nil)
+	assert.Equal(t, service.SetNotified(ctx, domainID, 2), nil)
+
+	changes, err := service.ChangesSince(ctx, last, domainID, 100)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(changes), 4)
+	ops := make([]string, 0)
+	for i, c := range changes {
+		ops = append(ops, c.Operation)
+		assert.Equal(t, c.Actor, "unit-test")
+		if i > 0 {
+			assert.Greater(t, c.Seq, changes[i-1].Seq)
+		}
+	}
+	assert.Equal(t, ops, []string{OpCreateDomain, OpSetMetadata, OpSetMetadata, OpSetNotified})
+	assert.JSONEq(t, string(changes[2].Before), `{"ALLOW-AXFR-FROM":["AUTO-NS"]}`)
+	assert.JSONEq(t, string(changes[2].After), `{"ALLOW-AXFR-FROM":["10.0.0.0/8"]}`)
+
+	// a failed mutation leaves no journal entry
+	assert.NotEqual(t, service.SetDomainMetadata(ctx, MustParseDNSName("missing.test."), "ALLOW-AXFR-FROM", nil), nil)
+	changes, err = service.ChangesSince(ctx, changes[3].Seq, -1, 100)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(changes), 0)
+
+	n, err := service.CompactChanges(ctx, CompactionPolicy{KeepLast: 2})
+	assert.Equal(t, err, nil)
+	assert.Greater(t, n, 0)
+	changes, err = service.ChangesSince(ctx, 0, -1, 100)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(changes), 2)
+	assert.Equal(t, changes[1].Seq, lastChange(t))
+}
+
+func TestService_JournalBefore(t *testing.T) {
+	ctx := context.Background()
+	s := New(nil, false, WithStore(memory.New()))
+	zone := MustParseDNSName("before.test.")
+	assert.Equal(t, s.CreateSlaveDomain(ctx, "10.0.0.7", zone), nil)
+	domainID, err := s.repos().Zones.ID(ctx, "before.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, s.SetNotified(ctx, domainID, 5), nil)
+	assert.Equal(t, s.SetNotified(ctx, domainID, 6), nil)
+
+	assert.Equal(t, s.StartTransaction(ctx, 1, domainID, zone), nil)
+	for _, rr := range []*DNSResourceRecord{
+		{Qname: "before.test.", Qtype: "MX", Content: "mail.before.test.", TTL: 60, Prio: 10},
+		{Qname: "www.before.test.", Qtype: "A", Content: "192.0.2.1", TTL: 60},
+		{Qname: "www.before.test.", Qtype: "A", Content: "192.0.2.2", TTL: 60, Disabled: true},
+	} {
+		assert.Equal(t, s.FeedTransactionRecord(ctx, 1, rr, ""), nil)
+	}
+	assert.Equal(t, s.CommitTransaction(ctx, 1), nil)
+	assert.Equal(t, s.StartTransaction(ctx, 2, domainID, zone), nil)
+	assert.Equal(t, s.FeedTransactionRecord(ctx, 2, &DNSResourceRecord{Qname: "www.before.test.", Qtype: "A", Content: "192.0.2.3", TTL: 60}, ""), nil)
+	rrset := []*DNSResourceRecord{{Qname: "www.before.test.", Qtype: "A", Content: "192.0.2.4", TTL: 60}}
+	assert.Equal(t, s.ReplaceRRSet(ctx, 2, domainID, MustParseDNSName("www.before.test."), TypeA, rrset), nil)
+	assert.Equal(t, s.CommitTransaction(ctx, 2), nil)
+
+	changes, err := s.ChangesSince(ctx, 0, domainID, 100)
+	assert.Equal(t, err, nil)
+	before := make(map[string][]string)
+	for _, c := range changes {
+		before[c.Operation] = append(before[c.Operation], string(c.Before))
+	}
+	assert.Equal(t, len(before[OpSetNotified]), 2)
+	assert.JSONEq(t, before[OpSetNotified][1], `{"serial":5}`)
+	if assert.Equal(t, len(before[OpDeleteZone]), 2) {
+		assert.JSONEq(t, before[OpDeleteZone][0], `[]`)
+		assert.JSONEq(t, before[OpDeleteZone][1], `[
+			{"qname":"before.test.","qtype":"MX","content":"mail.before.test.","ttl":60,"prio":10,"domain_id":1,"auth":true},
+			{"qname":"www.before.test.","qtype":"A","content":"192.0.2.1","ttl":60,"domain_id":1,"auth":true},
+			{"qname":"www.before.test.","qtype":"A","content":"192.0.2.2","ttl":60,"domain_id":1,"auth":true,"disabled":true}
+		]`)
+	}
+	if assert.Equal(t, len(before[OpReplaceRRSet]), 1) {
+		assert.JSONEq(t, before[OpReplaceRRSet][0],
+			`[{"qname":"www.before.test.","qtype":"A","content":"192.0.2.3","ttl":60,"domain_id":1,"auth":true}]`)
+	}
+}
+
+func lastChange(t *testing.T) int64 {
+	changes, err := service.ChangesSince(context.Background(), 0, -1, 1000000)
+	assert.Equal(t, err, nil)
+	if len(changes) == 0 {
+		return 0
+	}
+	return changes[len(changes)-1].Seq
+}
+
+func TestService_ReplaceRRSet(t *testing.T) {
+	ctx := context.Background()
+	zone := MustParseDNSName("replace.test.")
+	assert.Equal(t, service.CreateSlaveDomain(ctx, "10.0.0.6", zone), nil)
+	domainID, err := service.repos().Zones.ID(ctx, "replace.test.")
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, service.StartTransaction(ctx, 3, domainID, zone), nil)
+	for _, content := range []string{"192.0.2.1", "192.0.2.2"} {
+		rr := &DNSResourceRecord{Qname: "www.replace.test.", Qtype: "A", Content: content, TTL: 60}
+		assert.Equal(t, service.FeedTransactionRecord(ctx, 3, rr, ""), nil)
+	}
+	assert.NotEqual(t, service.FeedTransactionRecord(ctx, 3, &DNSResourceRecord{Qname: "www.replace.test.", Qtype: "AXFR"}, ""), nil)
+	assert.NotEqual(t, service.FeedTransactionRecord(ctx, 3, &DNSResourceRecord{Qname: "www.replace.test.", Qtype: "BOGUS"}, ""), nil)
+
+	www := MustParseDNSName("WWW.replace.test")
+	rrset := []*DNSResourceRecord{{Qname: "www.replace.test.", Qtype: "a", Content: "192.0.2.3", TTL: 60}}
+	assert.Equal(t, service.ReplaceRRSet(ctx, 3, domainID, www, TypeA, rrset), nil)
+	wrong := []*DNSResourceRecord{{Qname: "www.replace.test.", Qtype: "AAAA", Content: "2001:db8::1", TTL: 60}}
+	assert.NotEqual(t, service.ReplaceRRSet(ctx, 3, domainID, www, TypeA, wrong), nil)
+	assert.Equal(t, service.CommitTransaction(ctx, 3), nil)
+
+	rrs, err := service.Lookup(ctx, TypeA, www, domainID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rrs), 1)
+	assert.Equal(t, rrs[0].Content, "192.0.2.3")
+	assert.Equal(t, rrs[0].Qtype, "A")
+	_, err = service.Lookup(ctx, TypeAXFR, www, domainID)
+	assert.NotEqual(t, err, nil)
 }
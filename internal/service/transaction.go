@@ -0,0 +1,375 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"go.uber.org/zap"
+)
+
+// RecordWriter inserts records of one zone inside a single storage
+// transaction, grouping them into multi-row inserts.
+type RecordWriter struct {
+	s        *Service
+	tx       storage.StoreTx
+	domainID int
+	// emptied is set when StartTransaction deleted the records of domainID
+	emptied bool
+	// zone is loaded by the first write when DNSSEC is on
+	zone    *dnssecZone
+	batch   []*storage.Record
+	changes []*Change
+	written int
+	// mu serializes the calls of the service on its transaction with idle,
+	// which aborts it when left without calls
+	mu   sync.Mutex
+	idle *time.Timer
+}
+
+// NewRecordWriter starts a transaction for the zone. The transaction is not
+// bound to ctx, it lives until Commit or Abort.
+func (s *Service) NewRecordWriter(ctx context.Context, domainID int) (*RecordWriter, error) {
+	tx, err := s.store.Begin(context.Background())
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return &RecordWriter{
+		s:        s,
+		tx:       tx,
+		domainID: domainID,
+		batch:    make([]*storage.Record, 0, s.batchSize),
+		changes:  make([]*Change, 0, s.batchSize),
+	}, nil
+}
+
+func (w *RecordWriter) Write(ctx context.Context, rr *DNSResourceRecord, ordername string) error {
+	record, norm, err := w.s.toRecord(rr, ordername)
+	if err != nil {
+		return err
+	}
+	record.DomainID = w.domainID
+	if err = w.rectify(ctx, record); err != nil {
+		return err
+	}
+	c, err := newChange(w.domainID, OpInsertRecord, nil, journaled(norm, record))
+	if err != nil {
+		return err
+	}
+	w.batch = append(w.batch, record)
+	w.changes = append(w.changes, c)
+	if len(w.batch) >= w.s.batchSize {
+		return w.Flush(ctx)
+	}
+	return nil
+}
+
+func (w *RecordWriter) Flush(ctx context.Context) error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	r := w.tx.Repositories()
+	n, err := r.Records.Insert(ctx, w.batch...)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	if err = w.s.journal(ctx, r, w.changes...); err != nil {
+		return err
+	}
+	w.written += n
+	w.batch = w.batch[:0]
+	w.changes = w.changes[:0]
+	return nil
+}
+
+// Replace swaps the records of qname and qtype in the zone for rrset, an
+// empty rrset deletes them.
+func (w *RecordWriter) Replace(ctx context.Context, domainID int, qname DNSName, qtype QType, rrset []*DNSResourceRecord) error {
+	if qtype.IsQueryOnly() {
+		return stacktrace.Newf("%s is not a record type", qtype)
+	}
+	if err := w.Flush(ctx); err != nil {
+		return err
+	}
+	name := qname.Canonical().String()
+	records := make([]*storage.Record, 0, len(rrset))
+	normalized := make([]*DNSResourceRecord, 0, len(rrset))
+	for _, rr := range rrset {
+		record, norm, err := w.s.toRecord(rr, rr.OrderName)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, norm)
+		record.DomainID = domainID
+		if record.Name != name || record.Type != qtype.String() {
+			return stacktrace.Newf("Record %s %s is not in RRset %s %s", record.Name, record.Type, name, qtype)
+		}
+		records = append(records, record)
+	}
+	if w.s.dnssec && qtype == TypeNS {
+		zone, err := w.dnssecZone(ctx, domainID, name)
+		if err != nil {
+			return err
+		}
+		zone.setDelegation(qname, len(records) > 0)
+	}
+	after := make([]*DNSResourceRecord, 0, len(records))
+	for i, record := range records {
+		if err := w.rectify(ctx, record); err != nil {
+			return err
+		}
+		after = append(after, journaled(normalized[i], record))
+	}
+	r := w.tx.Repositories()
+	existing, err := r.Records.RRSet(ctx, domainID, name, qtype.String())
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	before := make([]*DNSResourceRecord, 0, len(existing))
+	for _, record := range existing {
+		before = append(before, recordFields(record))
+	}
+	if _, err = r.Records.DeleteRRSet(ctx, domainID, name, qtype.String()); err != nil {
+		return stacktrace.Wrap(err)
+	}
+	written, err := r.Records.Insert(ctx, records...)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	c, err := newChange(domainID, OpReplaceRRSet, before, after)
+	if err != nil {
+		return err
+	}
+	if err = w.s.journal(ctx, r, c); err != nil {
+		return err
+	}
+	w.written += written
+	return nil
+}
+
+// dnssecZone returns the zone domainID that name is in, loaded once.
+func (w *RecordWriter) dnssecZone(ctx context.Context, domainID int, name string) (*dnssecZone, error) {
+	if w.zone != nil && w.zone.id == domainID {
+		return w.zone, nil
+	}
+	if err := w.Flush(ctx); err != nil {
+		return nil, err
+	}
+	if err := w.rectifyRedelegated(ctx); err != nil {
+		return nil, err
+	}
+	zone, err := loadDNSSECZone(ctx, w.tx.Repositories(), domainID, name)
+	if err != nil {
+		return nil, err
+	}
+	// once unloaded the delegations it learnt are lost
+	if domainID == w.domainID {
+		zone.fresh, w.emptied = w.emptied, false
+	}
+	w.zone = zone
+	return zone, nil
+}
+
+// rectify computes the ordername and auth of record when DNSSEC is on.
+func (w *RecordWriter) rectify(ctx context.Context, record *storage.Record) error {
+	if !w.s.dnssec {
+		return nil
+	}
+	zone, err := w.dnssecZone(ctx, record.DomainID, record.Name)
+	if err != nil {
+		return err
+	}
+	return zone.rectify(ctx, w.tx.Repositories(), record)
+}
+
+// rectifyRedelegated fixes the records written at or below a name before
+// its delegation was written or deleted, they are already flushed.
+func (w *RecordWriter) rectifyRedelegated(ctx context.Context) error {
+	if w.zone == nil {
+		return nil
+	}
+	r := w.tx.Repositories()
+	c, err := rectifyRedelegated(ctx, r, w.zone)
+	if err != nil || c == nil {
+		return err
+	}
+	return w.s.journal(ctx, r, c)
+}
+
+// Written returns the number of records already sent to the storage.
+func (w *RecordWriter) Written() int {
+	return w.written
+}
+
+func (w *RecordWriter) Commit(ctx context.Context) error {
+	err := w.Flush(ctx)
+	if err == nil {
+		err = w.rectifyRedelegated(ctx)
+	}
+	if err != nil {
+		_ = w.tx.Rollback()
+		return err
+	}
+	return stacktrace.Wrap(w.tx.Commit())
+}
+
+func (w *RecordWriter) Abort() error {
+	w.batch = nil
+	w.changes = nil
+	return stacktrace.Wrap(w.tx.Rollback())
+}
+
+// StartTransaction reserves trxID before starting the transaction, so two
+// callers cannot both start it.
+func (s *Service) StartTransaction(ctx context.Context, trxID int, domainID int, zone DNSName) error {
+	s.trxMu.Lock()
+	if _, exists := s.trx[trxID]; exists {
+		s.trxMu.Unlock()
+		return stacktrace.Newf("Transaction %d already started", trxID)
+	}
+	s.trx[trxID] = nil
+	s.trxMu.Unlock()
+	w, err := s.NewRecordWriter(ctx, domainID)
+	if err != nil {
+		s.releaseTransaction(trxID)
+		return err
+	}
+	if domainID >= 0 {
+		if err = s.deleteZone(ctx, w.tx.Repositories(), domainID); err != nil {
+			_ = w.Abort()
+			s.releaseTransaction(trxID)
+			return err
+		}
+		w.emptied = true
+	}
+	s.trxMu.Lock()
+	if s.trxIdle > 0 {
+		w.idle = time.AfterFunc(s.trxIdle, func() { s.expireTransaction(trxID, w) })
+	}
+	s.trx[trxID] = w
+	s.trxMu.Unlock()
+	return nil
+}
+
+// deleteZone deletes the records of the zone, the journal keeps them.
+func (s *Service) deleteZone(ctx context.Context, r *storage.Repositories, domainID int) error {
+	it, err := r.Records.List(ctx, domainID, true)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	before := make([]*DNSResourceRecord, 0)
+	for it.Next() {
+		before = append(before, recordFields(it.Record()))
+	}
+	if err = it.Err(); err != nil {
+		_ = it.Close()
+		return stacktrace.Wrap(err)
+	}
+	if err = it.Close(); err != nil {
+		return stacktrace.Wrap(err)
+	}
+	if _, err = r.Records.DeleteZone(ctx, domainID); err != nil {
+		return stacktrace.Wrap(err)
+	}
+	c, err := newChange(domainID, OpDeleteZone, before, nil)
+	if err != nil {
+		return err
+	}
+	return s.journal(ctx, r, c)
+}
+
+func (s *Service) FeedTransactionRecord(ctx context.Context, trxID int, rr *DNSResourceRecord, ordername string) error {
+	w, err := s.transaction(trxID, false)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.FeedRecord)
+	defer cancel()
+	return w.Write(ctx, rr, ordername)
+}
+
+func (s *Service) ReplaceRRSet(ctx context.Context, trxID int, domainID int, qname DNSName, qtype QType, rrset []*DNSResourceRecord) error {
+	w, err := s.transaction(trxID, false)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.FeedRecord)
+	defer cancel()
+	return w.Replace(ctx, domainID, qname, qtype, rrset)
+}
+
+func (s *Service) CommitTransaction(ctx context.Context, trxID int) error {
+	w, err := s.transaction(trxID, true)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.FeedRecord)
+	defer cancel()
+	if err = w.Commit(ctx); err != nil {
+		return err
+	}
+	s.zones.invalidate()
+	return nil
+}
+
+func (s *Service) AbortTransaction(ctx context.Context, trxID int) error {
+	w, err := s.transaction(trxID, true)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Abort()
+}
+
+func (s *Service) transaction(trxID int, remove bool) (*RecordWriter, error) {
+	s.trxMu.Lock()
+	defer s.trxMu.Unlock()
+	w, ok := s.trx[trxID]
+	if !ok || w == nil {
+		// nil while StartTransaction is still starting it
+		return nil, stacktrace.Newf("Transaction %d not found", trxID)
+	}
+	switch {
+	case w.idle == nil:
+	case remove:
+		w.idle.Stop()
+	default:
+		w.idle.Reset(s.trxIdle)
+	}
+	if remove {
+		delete(s.trx, trxID)
+	}
+	return w, nil
+}
+
+// expireTransaction aborts trxID if it is still w once left idle.
+func (s *Service) expireTransaction(trxID int, w *RecordWriter) {
+	s.trxMu.Lock()
+	if s.trx[trxID] != w {
+		s.trxMu.Unlock()
+		return
+	}
+	delete(s.trx, trxID)
+	s.trxMu.Unlock()
+	s.logger.Warn("idle transaction aborted", zap.Int("trxid", trxID))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Abort(); err != nil {
+		s.logger.Error("abort idle transaction", zap.Int("trxid", trxID), zap.Error(err))
+	}
+}
+
+func (s *Service) releaseTransaction(trxID int) {
+	s.trxMu.Lock()
+	delete(s.trx, trxID)
+	s.trxMu.Unlock()
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// zoneCache maps the names of all zones to their ids. It is reloaded once
+// older than ttl, the zones created and the transactions committed by the
+// service reload it at once.
+type zoneCache struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	ids    map[string]int
+	loaded time.Time
+}
+
+func (c *zoneCache) invalidate() {
+	c.mu.Lock()
+	c.ids = nil
+	c.mu.Unlock()
+}
+
+// get returns the cached map, which is never changed once loaded.
+func (c *zoneCache) get(ctx context.Context, r *storage.Repositories) (map[string]int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ids != nil && time.Since(c.loaded) < c.ttl {
+		return c.ids, nil
+	}
+	zones, err := r.Zones.List(ctx, true)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	ids := make(map[string]int, len(zones))
+	for _, zone := range zones {
+		ids[storage.NormalizeName(zone.Name)] = zone.ID
+	}
+	c.ids, c.loaded = ids, time.Now()
+	return ids, nil
+}
+
+// bestZone returns the id and name of the closest zone enclosing qname,
+// storage.ErrNotFound when none does. Without the cache every ancestor
+// of qname is looked up.
+func (s *Service) bestZone(ctx context.Context, qname DNSName) (int, DNSName, error) {
+	r := s.repos()
+	var ids map[string]int
+	if s.zones.ttl > 0 {
+		var err error
+		if ids, err = s.zones.get(ctx, r); err != nil {
+			return 0, DNSName{}, err
+		}
+	}
+	for name := qname.Canonical(); ; name = name.Parent() {
+		if ids != nil {
+			if id, ok := ids[name.String()]; ok {
+				return id, name, nil
+			}
+		} else {
+			id, err := r.Zones.ID(ctx, name.String())
+			if err == nil {
+				return id, name, nil
+			}
+			if !errors.Is(err, storage.ErrNotFound) {
+				return 0, DNSName{}, stacktrace.Wrap(err)
+			}
+		}
+		if name.CountLabels() == 0 {
+			return 0, DNSName{}, stacktrace.Newf("no zone for %s: %w", qname, storage.ErrNotFound)
+		}
+	}
+}
+
+// GetSOA returns the SOA record of the closest zone enclosing qname.
+func (s *Service) GetSOA(ctx context.Context, qname DNSName) (*DNSResourceRecord, error) {
+	ctx, cancel := s.withTimeout(ctx, s.timeouts.Lookup)
+	defer cancel()
+	id, zone, err := s.bestZone(ctx, qname)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.repos().Records.Lookup(ctx, storage.LookupQuery{
+		Name:     zone.String(),
+		Type:     TypeSOA.String(),
+		DomainID: id,
+	})
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	if len(records) == 0 {
+		return nil, stacktrace.Newf("no SOA in %s: %w", zone, storage.ErrNotFound)
+	}
+	return s.fromRecord(records[0]), nil
+}
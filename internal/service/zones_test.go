@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_BestZone(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	r := store.Repositories()
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 1, Name: "parent.test.", Kind: "NATIVE"}), nil)
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 2, Name: "child.parent.test.", Kind: "NATIVE"}), nil)
+	_, err := r.Records.Insert(ctx,
+		&storage.Record{DomainID: 1, Name: "parent.test.", Type: "SOA", Content: "ns.parent.test. h.parent.test. 1 2 3 4 5", TTL: 300},
+		&storage.Record{DomainID: 1, Name: "child.parent.test.", Type: "NS", Content: "ns.child.parent.test.", TTL: 300},
+		&storage.Record{DomainID: 1, Name: "child.parent.test.", Type: "DS", Content: "1 13 2 ab", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "child.parent.test.", Type: "SOA", Content: "ns.child.parent.test. h.parent.test. 7 2 3 4 5", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "child.parent.test.", Type: "NS", Content: "ns.child.parent.test.", TTL: 300},
+	)
+	assert.Equal(t, err, nil)
+
+	for _, s := range []*Service{
+		New(nil, true, WithStore(store)),
+		New(nil, true, WithStore(store), WithZoneCacheTTL(0)),
+	} {
+		rrs, err := s.Lookup(ctx, TypeNS, MustParseDNSName("Child.Parent.test."), -1)
+		assert.Equal(t, err, nil)
+		if assert.Equal(t, len(rrs), 1) {
+			assert.Equal(t, rrs[0].DomainID, 2)
+		}
+		rrs, err = s.Lookup(ctx, TypeDS, MustParseDNSName("child.parent.test."), -1)
+		assert.Equal(t, err, nil)
+		if assert.Equal(t, len(rrs), 1) {
+			assert.Equal(t, rrs[0].DomainID, 1)
+		}
+		rrs, err = s.Lookup(ctx, TypeA, MustParseDNSName("www.other.test."), -1)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(rrs), 0)
+
+		soa, err := s.GetSOA(ctx, MustParseDNSName("deep.www.child.parent.test."))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, soa.Qname, "child.parent.test.")
+		assert.Equal(t, soa.DomainID, 2)
+		soa, err = s.GetSOA(ctx, MustParseDNSName("www.parent.test."))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, soa.DomainID, 1)
+		_, err = s.GetSOA(ctx, MustParseDNSName("other.test."))
+		assert.True(t, errors.Is(err, storage.ErrNotFound))
+	}
+
+	// zones created by the service are found before the cache expires
+	s := New(nil, true, WithStore(store))
+	_, err = s.GetSOA(ctx, MustParseDNSName("parent.test."))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, s.CreateSlaveDomain(ctx, "192.0.2.53", MustParseDNSName("new.test.")), nil)
+	id, zone, err := s.bestZone(ctx, MustParseDNSName("www.new.test."))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, zone.String(), "new.test.")
+	assert.NotEqual(t, id, 0)
+
+	// a zone created in the storage shows once a transaction commits
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 9, Name: "outside.test.", Kind: "NATIVE"}), nil)
+	_, _, err = s.bestZone(ctx, MustParseDNSName("outside.test."))
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+	assert.Equal(t, s.StartTransaction(ctx, 1, 9, MustParseDNSName("outside.test.")), nil)
+	assert.Equal(t, s.CommitTransaction(ctx, 1), nil)
+	id, _, err = s.bestZone(ctx, MustParseDNSName("outside.test."))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, id, 9)
+}
@@ -0,0 +1,127 @@
+// Package audit looks for rows of a SQL storage that the typed
+// repositories cannot see: children of deleted zones, left behind while
+// foreign keys are off, keys without content and zones differing by case.
+package audit
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+const (
+	OrphanRecords  = "orphan-records"
+	OrphanComments = "orphan-comments"
+	OrphanMetadata = "orphan-metadata"
+	OrphanKeys     = "orphan-keys"
+	EmptyKey       = "empty-key"
+	DuplicateZone  = "duplicate-zone"
+)
+
+// Finding is one inconsistency. Orphans are counted per missing domain
+// id, DomainID is 0 for records without one. Duplicate zones are only
+// reported, merging them is left to the operator.
+type Finding struct {
+	Kind     string `json:"kind"`
+	DomainID int    `json:"domain_id,omitempty"`
+	KeyID    int    `json:"key_id,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Count    int    `json:"count"`
+	Repaired bool   `json:"repaired"`
+}
+
+type Report struct {
+	Findings []*Finding `json:"findings"`
+	// Repaired counts the rows deleted
+	Repaired int `json:"repaired"`
+}
+
+// check finds one kind of inconsistency, repair deletes all of it.
+type check struct {
+	kind   string
+	query  string
+	repair string
+	scan   func(rows storage.IResult) (*Finding, error)
+}
+
+func scanOrphans(rows storage.IResult) (*Finding, error) {
+	var domainID sql.NullInt64
+	f := new(Finding)
+	err := rows.Scan(&domainID, &f.Count)
+	f.DomainID = int(domainID.Int64)
+	return f, err
+}
+
+var checks = []check{
+	{OrphanRecords, "audit-orphan-records-query", "delete-orphan-records-query", scanOrphans},
+	{OrphanComments, "audit-orphan-comments-query", "delete-orphan-comments-query", scanOrphans},
+	{OrphanMetadata, "audit-orphan-metadata-query", "delete-orphan-metadata-query", scanOrphans},
+	{OrphanKeys, "audit-orphan-keys-query", "delete-orphan-keys-query", scanOrphans},
+	{EmptyKey, "audit-empty-keys-query", "delete-empty-keys-query", func(rows storage.IResult) (*Finding, error) {
+		f := &Finding{Count: 1}
+		return f, rows.Scan(&f.KeyID, &f.Zone)
+	}},
+	{DuplicateZone, "audit-duplicate-zones-query", "", func(rows storage.IResult) (*Finding, error) {
+		f := &Finding{Count: 1}
+		return f, rows.Scan(&f.DomainID, &f.Zone)
+	}},
+}
+
+// Run audits stg, the undecorated storage, in one transaction. Without
+// repair nothing is written, with it the orphans and empty keys found
+// are deleted.
+func Run(ctx context.Context, stg storage.IStorage, repair bool) (*Report, error) {
+	t, err := stg.Begin(ctx)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	report, err := run(ctx, t, repair)
+	if err != nil || !repair {
+		_ = t.Rollback()
+		return report, err
+	}
+	return report, stacktrace.Wrap(t.Commit())
+}
+
+func run(ctx context.Context, t storage.ITx, repair bool) (*Report, error) {
+	report := &Report{Findings: make([]*Finding, 0)}
+	for _, c := range checks {
+		findings, err := find(ctx, t, c)
+		if err != nil {
+			return nil, err
+		}
+		report.Findings = append(report.Findings, findings...)
+		if !repair || c.repair == "" || len(findings) == 0 {
+			continue
+		}
+		n, err := t.ExecContext(ctx, c.repair)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		report.Repaired += n
+		for _, f := range findings {
+			f.Repaired = true
+		}
+	}
+	return report, nil
+}
+
+func find(ctx context.Context, t storage.ITx, c check) ([]*Finding, error) {
+	rows, err := t.QueryContext(ctx, c.query)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	defer rows.Close()
+	findings := make([]*Finding, 0)
+	for rows.Next() {
+		f, err := c.scan(rows)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		f.Kind = c.kind
+		findings = append(findings, f)
+	}
+	return findings, stacktrace.Wrap(rows.Err())
+}
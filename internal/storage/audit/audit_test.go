@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	db := sqlite.New(":memory:")
+	defer db.Close()
+	assert.Equal(t, db.CreateTable(), nil)
+	r := catalog.New(db).Repositories()
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 1, Name: "kept.test.", Kind: "NATIVE"}), nil)
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{ID: 2, Name: "gone.test.", Kind: "NATIVE"}), nil)
+	_, err := r.Records.Insert(ctx,
+		&storage.Record{DomainID: 1, Name: "kept.test.", Type: "A", Content: "192.0.2.1", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "gone.test.", Type: "A", Content: "192.0.2.1", TTL: 300},
+		&storage.Record{DomainID: 2, Name: "www.gone.test.", Type: "A", Content: "192.0.2.1", TTL: 300},
+	)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.Metadata.Set(ctx, "gone.test.", "ALLOW-AXFR-FROM", []string{"AUTO-NS"}), nil)
+	_, err = r.Keys.Add(ctx, "gone.test.", &storage.Key{Flags: 257, Active: true, Content: "key"})
+	assert.Equal(t, err, nil)
+	_, err = r.Keys.Add(ctx, "kept.test.", &storage.Key{ID: 7, Flags: 257, Active: true})
+	assert.Equal(t, err, nil)
+	// foreign keys are off, the children of the zone stay
+	_, err = db.Exec("delete-domain-query", "domain", "gone.test.")
+	assert.Equal(t, err, nil)
+
+	want := []*Finding{
+		{Kind: OrphanRecords, DomainID: 2, Count: 2},
+		{Kind: OrphanMetadata, DomainID: 2, Count: 1},
+		{Kind: OrphanKeys, DomainID: 2, Count: 1},
+		{Kind: EmptyKey, KeyID: 7, Zone: "kept.test.", Count: 1},
+	}
+	report, err := Run(ctx, db, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Findings, want)
+	assert.Equal(t, report.Repaired, 0)
+
+	report, err = Run(ctx, db, true)
+	assert.Equal(t, err, nil)
+	for _, f := range want {
+		f.Repaired = true
+	}
+	assert.Equal(t, report.Findings, want)
+	assert.Equal(t, report.Repaired, 5)
+
+	report, err = Run(ctx, db, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Findings, []*Finding{})
+	rrs, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "kept.test.", DomainID: 1})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rrs), 1)
+}
@@ -0,0 +1,92 @@
+// Package catalog implements the typed repositories on top of the named
+// statements of a storage.IStorage. Column order of every statement is
+// known here only.
+package catalog
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type Store struct {
+	stg   storage.IStorage
+	repos *storage.Repositories
+}
+
+func New(stg storage.IStorage) *Store {
+	return &Store{
+		stg:   stg,
+		repos: Repositories(stg),
+	}
+}
+
+func (s *Store) Repositories() *storage.Repositories {
+	return s.repos
+}
+
+func (s *Store) Begin(ctx context.Context) (storage.StoreTx, error) {
+	t, err := s.stg.Begin(ctx)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return &tx{ITx: t, repos: Repositories(t)}, nil
+}
+
+type tx struct {
+	storage.ITx
+	repos *storage.Repositories
+}
+
+func (t *tx) Repositories() *storage.Repositories {
+	return t.repos
+}
+
+// Repositories binds the repositories to q, a storage or a transaction.
+func Repositories(q storage.IQuerier) *storage.Repositories {
+	return &storage.Repositories{
+		Zones:        &zones{q: q},
+		Records:      &records{q: q},
+		Keys:         &keys{q: q},
+		Metadata:     &metadata{q: q},
+		TSIG:         &tsig{q: q},
+		Comments:     &comments{q: q},
+		Supermasters: &supermasters{q: q},
+		Changes:      &changes{q: q},
+	}
+}
+
+// query runs stmt and calls scan for every row.
+func query(ctx context.Context, q storage.IQuerier, scan func(rows storage.IResult) error, stmt string, args ...interface{}) error {
+	rows, err := q.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return stacktrace.Wrap(err)
+		}
+	}
+	return stacktrace.Wrap(rows.Err())
+}
+
+func exec(ctx context.Context, q storage.IQuerier, stmt string, args ...interface{}) (int, error) {
+	n, err := q.ExecContext(ctx, stmt, args...)
+	return n, stacktrace.Wrap(err)
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullInt(n int64) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
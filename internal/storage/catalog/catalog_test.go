@@ -0,0 +1,108 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStore(t *testing.T) *Store {
+	stg := sqlite.New(":memory:")
+	t.Cleanup(stg.Close)
+	assert.Equal(t, stg.CreateTable(), nil)
+	return New(stg)
+}
+
+func TestZones(t *testing.T) {
+	ctx := context.Background()
+	r := newStore(t).Repositories()
+
+	_, err := r.Zones.Get(ctx, "none.test.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{Name: "a.test.", Kind: "NATIVE"}), nil)
+	id, err := r.Zones.ID(ctx, "a.test.")
+	assert.Equal(t, err, nil)
+	_, err = r.Records.Insert(ctx, &storage.Record{
+		DomainID: id,
+		Name:     "a.test.",
+		Type:     "SOA",
+		Content:  "ns.a.test. admin.a.test. 2022010101 3600 600 86400 60",
+		TTL:      3600,
+		Auth:     true,
+	})
+	assert.Equal(t, err, nil)
+
+	zone, err := r.Zones.Get(ctx, "a.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, zone.ID, id)
+	assert.Equal(t, zone.Kind, "NATIVE")
+	assert.Equal(t, zone.Master, "")
+
+	zones, err := r.Zones.List(ctx, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(zones), 1)
+	assert.Equal(t, zones[0].SOA, "ns.a.test. admin.a.test. 2022010101 3600 600 86400 60")
+}
+
+func TestRecords(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+	r := s.Repositories()
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{Name: "b.test.", Kind: "NATIVE"}), nil)
+	id, err := r.Zones.ID(ctx, "b.test.")
+	assert.Equal(t, err, nil)
+
+	tx, err := s.Begin(ctx)
+	assert.Equal(t, err, nil)
+	n, err := tx.Repositories().Records.Insert(ctx,
+		&storage.Record{DomainID: id, Name: "www.b.test.", Type: "A", Content: "192.0.2.1", TTL: 60, Auth: true},
+		&storage.Record{DomainID: id, Name: "www.b.test.", Type: "AAAA", Content: "2001:db8::1", TTL: 60, Auth: true},
+		&storage.Record{DomainID: id, Name: "old.b.test.", Type: "A", Content: "192.0.2.2", TTL: 60, Disabled: true},
+	)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 3)
+	assert.Equal(t, tx.Commit(), nil)
+
+	list, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "www.b.test.", Type: "A", DomainID: -1})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(list), 1)
+	assert.Equal(t, list[0].Content, "192.0.2.1")
+
+	list, err = r.Records.Lookup(ctx, storage.LookupQuery{Name: "www.b.test.", Type: "ANY", DomainID: id})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(list), 2)
+
+	it, err := r.Records.List(ctx, id, false)
+	assert.Equal(t, err, nil)
+	count := 0
+	for it.Next() {
+		count++
+	}
+	assert.Equal(t, it.Err(), nil)
+	assert.Equal(t, it.Close(), nil)
+	assert.Equal(t, count, 2)
+
+	n, err = r.Records.DeleteZone(ctx, id)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 3)
+}
+
+func TestTSIG(t *testing.T) {
+	ctx := context.Background()
+	r := newStore(t).Repositories()
+	key := &storage.TSIGKey{Name: "k.", Algorithm: "hmac-sha256", Secret: "c2VjcmV0"}
+	assert.Equal(t, r.TSIG.Set(ctx, key), nil)
+	got, err := r.TSIG.Get(ctx, "k.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, got, key)
+	n, err := r.TSIG.Delete(ctx, "k.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	_, err = r.TSIG.Get(ctx, "k.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+}
@@ -0,0 +1,84 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type changes struct {
+	q storage.IQuerier
+}
+
+func (c *changes) Append(ctx context.Context, list ...*storage.Change) error {
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		_, err := exec(ctx, c.q, "insert-change-query", changeArgs(list[0])...)
+		return err
+	}
+	batch := make([][]interface{}, 0, len(list))
+	for _, change := range list {
+		batch = append(batch, changeArgs(change))
+	}
+	_, err := c.q.ExecBatchContext(ctx, "insert-change-query", batch)
+	return stacktrace.Wrap(err)
+}
+
+func (c *changes) Since(ctx context.Context, seq int64, domainID int, limit int) ([]*storage.Change, error) {
+	list := make([]*storage.Change, 0)
+	err := query(ctx, c.q, func(rows storage.IResult) error {
+		change := new(storage.Change)
+		var before, after, actor sql.NullString
+		var domain sql.NullInt64
+		err := rows.Scan(&change.Seq, &domain, &change.Operation, &before, &after, &actor, &change.Time)
+		if err != nil {
+			return err
+		}
+		change.DomainID = -1
+		if domain.Valid {
+			change.DomainID = int(domain.Int64)
+		}
+		if before.Valid {
+			change.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			change.After = json.RawMessage(after.String)
+		}
+		change.Actor = actor.String
+		list = append(list, change)
+		return nil
+	}, "list-changes-since-query",
+		"seq", seq,
+		"domain_id", domainID,
+		"limit", limit,
+	)
+	return list, err
+}
+
+func (c *changes) DeleteBefore(ctx context.Context, created int64) (int, error) {
+	return exec(ctx, c.q, "compact-changes-before-query", "created_at", created)
+}
+
+func (c *changes) KeepLast(ctx context.Context, n int) (int, error) {
+	return exec(ctx, c.q, "compact-changes-keep-query", "keep", n)
+}
+
+func changeArgs(change *storage.Change) []interface{} {
+	var domainID interface{}
+	if change.DomainID >= 0 {
+		domainID = change.DomainID
+	}
+	return []interface{}{
+		"domain_id", domainID,
+		"operation", change.Operation,
+		"before", nullString(string(change.Before)),
+		"after", nullString(string(change.After)),
+		"actor", nullString(change.Actor),
+		"created_at", change.Time,
+	}
+}
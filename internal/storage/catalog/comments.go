@@ -0,0 +1,52 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type comments struct {
+	q storage.IQuerier
+}
+
+func (c *comments) List(ctx context.Context, domainID int) ([]*storage.Comment, error) {
+	list := make([]*storage.Comment, 0)
+	err := query(ctx, c.q, func(rows storage.IResult) error {
+		comment := new(storage.Comment)
+		var account sql.NullString
+		err := rows.Scan(&comment.DomainID, &comment.Name, &comment.Type, &comment.ModifiedAt, &account, &comment.Comment)
+		if err != nil {
+			return err
+		}
+		comment.Account = account.String
+		list = append(list, comment)
+		return nil
+	}, "list-comments-query", "domain_id", domainID)
+	return list, err
+}
+
+func (c *comments) Insert(ctx context.Context, comment *storage.Comment) error {
+	_, err := exec(ctx, c.q, "insert-comment-query",
+		"domain_id", comment.DomainID,
+		"qname", comment.Name,
+		"qtype", comment.Type,
+		"modified_at", comment.ModifiedAt,
+		"account", nullString(comment.Account),
+		"content", comment.Comment,
+	)
+	return err
+}
+
+func (c *comments) DeleteRRSet(ctx context.Context, domainID int, name string, qtype string) (int, error) {
+	return exec(ctx, c.q, "delete-comment-rrset-query",
+		"domain_id", domainID,
+		"qname", name,
+		"qtype", qtype,
+	)
+}
+
+func (c *comments) DeleteZone(ctx context.Context, domainID int) (int, error) {
+	return exec(ctx, c.q, "delete-comments-query", "domain_id", domainID)
+}
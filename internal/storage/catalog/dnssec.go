@@ -0,0 +1,154 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type keys struct {
+	q storage.IQuerier
+}
+
+func (k *keys) List(ctx context.Context, zone string) ([]*storage.Key, error) {
+	list := make([]*storage.Key, 0, 2)
+	err := query(ctx, k.q, func(rows storage.IResult) error {
+		key := new(storage.Key)
+		var active, published sql.NullBool
+		var content sql.NullString
+		if err := rows.Scan(&key.ID, &key.Flags, &active, &published, &content); err != nil {
+			return err
+		}
+		key.Active = active.Bool
+		key.Published = published.Bool
+		key.Content = content.String
+		list = append(list, key)
+		return nil
+	}, "list-domain-keys-query", "domain", zone)
+	return list, err
+}
+
+func (k *keys) Add(ctx context.Context, zone string, key *storage.Key) (int, error) {
+	if key.ID > 0 {
+		return exec(ctx, k.q, "add-domain-key-with-id-query",
+			"key_id", key.ID,
+			"domain", zone,
+			"flags", key.Flags,
+			"active", key.Active,
+			"published", key.Published,
+			"content", key.Content,
+		)
+	}
+	return exec(ctx, k.q, "add-domain-key-query",
+		"domain", zone,
+		"flags", key.Flags,
+		"active", key.Active,
+		"published", key.Published,
+		"content", key.Content,
+	)
+}
+
+type metadata struct {
+	q storage.IQuerier
+}
+
+func (m *metadata) GetAll(ctx context.Context, zone string) (map[string][]string, error) {
+	meta := make(map[string][]string)
+	err := query(ctx, m.q, func(rows storage.IResult) error {
+		var kind string
+		var content sql.NullString
+		if err := rows.Scan(&kind, &content); err != nil {
+			return err
+		}
+		meta[kind] = append(meta[kind], content.String)
+		return nil
+	}, "get-all-domain-metadata-query", "domain", zone)
+	return meta, err
+}
+
+func (m *metadata) Get(ctx context.Context, zone string, kind string) ([]string, error) {
+	values := make([]string, 0)
+	err := query(ctx, m.q, func(rows storage.IResult) error {
+		var content sql.NullString
+		if err := rows.Scan(&content); err != nil {
+			return err
+		}
+		values = append(values, content.String)
+		return nil
+	}, "get-domain-metadata-query", "domain", zone, "kind", kind)
+	return values, err
+}
+
+// Set replaces all values of kind, call it inside a transaction.
+func (m *metadata) Set(ctx context.Context, zone string, kind string, values []string) error {
+	_, err := exec(ctx, m.q, "clear-domain-metadata-query",
+		"domain", zone,
+		"kind", kind,
+	)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		_, err = exec(ctx, m.q, "set-domain-metadata-query",
+			"kind", kind,
+			"content", value,
+			"domain", zone,
+		)
+		if err != nil {
+			return stacktrace.Newf("Unable to set metadata kind %s for domain %s: %w", kind, zone, err)
+		}
+	}
+	return nil
+}
+
+type tsig struct {
+	q storage.IQuerier
+}
+
+func (t *tsig) Get(ctx context.Context, name string) (*storage.TSIGKey, error) {
+	var key *storage.TSIGKey
+	err := query(ctx, t.q, func(rows storage.IResult) error {
+		key = &storage.TSIGKey{Name: name}
+		return rows.Scan(&key.Algorithm, &key.Secret)
+	}, "get-tsig-key-query", "key_name", name)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, stacktrace.Newf("TSIG key %s: %w", name, storage.ErrNotFound)
+	}
+	return key, nil
+}
+
+func (t *tsig) List(ctx context.Context) ([]*storage.TSIGKey, error) {
+	list := make([]*storage.TSIGKey, 0)
+	err := query(ctx, t.q, func(rows storage.IResult) error {
+		key := new(storage.TSIGKey)
+		if err := rows.Scan(&key.Name, &key.Algorithm, &key.Secret); err != nil {
+			return err
+		}
+		list = append(list, key)
+		return nil
+	}, "get-tsig-keys-query")
+	return list, err
+}
+
+// Set replaces the key of that name whatever its algorithm, call it inside
+// a transaction.
+func (t *tsig) Set(ctx context.Context, key *storage.TSIGKey) error {
+	if _, err := t.Delete(ctx, key.Name); err != nil {
+		return err
+	}
+	_, err := exec(ctx, t.q, "set-tsig-key-query",
+		"key_name", key.Name,
+		"algorithm", key.Algorithm,
+		"content", key.Secret,
+	)
+	return err
+}
+
+func (t *tsig) Delete(ctx context.Context, name string) (int, error) {
+	return exec(ctx, t.q, "delete-tsig-key-query", "key_name", name)
+}
@@ -0,0 +1,217 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type records struct {
+	q storage.IQuerier
+}
+
+func (r *records) Lookup(ctx context.Context, lq storage.LookupQuery) ([]*storage.Record, error) {
+	var stmt string
+	args := []interface{}{"qname", storage.NormalizeName(lq.Name)}
+	anyType := lq.Type == "" || strings.EqualFold(lq.Type, "ANY")
+	switch {
+	case !anyType && lq.DomainID < 0:
+		stmt = "basic-query"
+		args = append(args, "qtype", lq.Type)
+	case !anyType:
+		stmt = "id-query"
+		args = append(args, "qtype", lq.Type, "domain_id", lq.DomainID)
+	case lq.DomainID < 0:
+		stmt = "any-query"
+	default:
+		stmt = "any-id-query"
+		args = append(args, "domain_id", lq.DomainID)
+	}
+	list := make([]*storage.Record, 0)
+	err := query(ctx, r.q, func(rows storage.IResult) error {
+		rr, err := scanRecord(rows, false)
+		if err == nil {
+			list = append(list, rr)
+		}
+		return err
+	}, stmt, args...)
+	return list, err
+}
+
+func (r *records) List(ctx context.Context, domainID int, includeDisabled bool) (storage.RecordIterator, error) {
+	rows, err := r.q.QueryContext(ctx, "list-query",
+		"include_disabled", includeDisabled,
+		"domain_id", domainID,
+	)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return &recordIterator{rows: rows}, nil
+}
+
+func (r *records) RRSet(ctx context.Context, domainID int, name string, qtype string) ([]*storage.Record, error) {
+	list := make([]*storage.Record, 0)
+	err := query(ctx, r.q, func(rows storage.IResult) error {
+		rr, err := scanRecord(rows, true)
+		if err == nil {
+			list = append(list, rr)
+		}
+		return err
+	}, "rrset-query", "domain_id", domainID, "qname", storage.NormalizeName(name), "qtype", qtype)
+	return list, err
+}
+
+func (r *records) Insert(ctx context.Context, list ...*storage.Record) (int, error) {
+	switch len(list) {
+	case 0:
+		return 0, nil
+	case 1:
+		return exec(ctx, r.q, "insert-record-query", recordArgs(list[0])...)
+	}
+	batch := make([][]interface{}, 0, len(list))
+	for _, rr := range list {
+		batch = append(batch, recordArgs(rr))
+	}
+	n, err := r.q.ExecBatchContext(ctx, "insert-record-query", batch)
+	return n, stacktrace.Wrap(err)
+}
+
+func (r *records) DeleteZone(ctx context.Context, domainID int) (int, error) {
+	return exec(ctx, r.q, "delete-zone-query", "domain_id", domainID)
+}
+
+func (r *records) DeleteRRSet(ctx context.Context, domainID int, name string, qtype string) (int, error) {
+	return exec(ctx, r.q, "delete-rrset-query", "domain_id", domainID, "qname", storage.NormalizeName(name), "qtype", qtype)
+}
+
+func (r *records) SetOrderNameAndAuth(ctx context.Context, domainID int, name string, qtype string, ordername string, auth bool) (int, error) {
+	args := []interface{}{"domain_id", domainID, "qname", storage.NormalizeName(name), "auth", auth}
+	if ordername != "" {
+		args = append(args, "ordername", ordername)
+	}
+	stmt := "update-ordername-and-auth"
+	if ordername == "" {
+		stmt = "nullify-ordername-and-update-auth"
+	}
+	if qtype != "" {
+		stmt += "-type"
+		args = append(args, "qtype", qtype)
+	}
+	return exec(ctx, r.q, stmt+"-query", args...)
+}
+
+func (r *records) InsertEmptyNonTerminal(ctx context.Context, domainID int, name string, ordername string, auth bool) error {
+	_, err := exec(ctx, r.q, "insert-empty-non-terminal-order-query",
+		"domain_id", domainID, "qname", storage.NormalizeName(name), "ordername", nullString(ordername), "auth", auth)
+	return err
+}
+
+func (r *records) DeleteEmptyNonTerminal(ctx context.Context, domainID int, name string) (int, error) {
+	return exec(ctx, r.q, "delete-empty-non-terminal-query", "domain_id", domainID, "qname", storage.NormalizeName(name))
+}
+
+func (r *records) OrderBefore(ctx context.Context, domainID int, ordername string) (*storage.Record, error) {
+	return r.order(ctx, "get-order-before-query", "domain_id", domainID, "ordername", ordername)
+}
+
+func (r *records) OrderAfter(ctx context.Context, domainID int, ordername string) (*storage.Record, error) {
+	return r.order(ctx, "get-order-after-query", "domain_id", domainID, "ordername", ordername)
+}
+
+func (r *records) OrderFirst(ctx context.Context, domainID int) (*storage.Record, error) {
+	return r.order(ctx, "get-order-first-query", "domain_id", domainID)
+}
+
+func (r *records) OrderLast(ctx context.Context, domainID int) (*storage.Record, error) {
+	return r.order(ctx, "get-order-last-query", "domain_id", domainID)
+}
+
+// order runs one of the get-order statements, all of them return ordername
+// and name.
+func (r *records) order(ctx context.Context, stmt string, args ...interface{}) (*storage.Record, error) {
+	var rr *storage.Record
+	err := query(ctx, r.q, func(rows storage.IResult) error {
+		rr = new(storage.Record)
+		return rows.Scan(&rr.OrderName, &rr.Name)
+	}, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		return nil, stacktrace.Newf("%s: %w", stmt, storage.ErrNotFound)
+	}
+	return rr, nil
+}
+
+func recordArgs(rr *storage.Record) []interface{} {
+	rr = rr.Normalized()
+	return []interface{}{
+		"content", rr.Content,
+		"ttl", rr.TTL,
+		"priority", rr.Prio,
+		"qtype", nullString(rr.Type),
+		"domain_id", rr.DomainID,
+		"disabled", rr.Disabled,
+		"qname", rr.Name,
+		"display_name", nullString(rr.DisplayName),
+		"auth", rr.Auth,
+		"ordername", nullString(rr.OrderName),
+	}
+}
+
+// scanRecord reads the lookup statements, or list-query with ordername as
+// the tenth column. Empty non-terminals come back with an empty Type.
+func scanRecord(rows storage.IResult, withOrderName bool) (*storage.Record, error) {
+	rr := new(storage.Record)
+	var content, qtype, displayName, ordername sql.NullString
+	var ttl, prio sql.NullInt64
+	var disabled, auth sql.NullBool
+	dest := []interface{}{&content, &ttl, &prio, &qtype, &rr.DomainID, &disabled, &rr.Name, &auth, &displayName}
+	if withOrderName {
+		dest = append(dest, &ordername)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	rr.Content = content.String
+	rr.TTL = int(ttl.Int64)
+	rr.Prio = int(prio.Int64)
+	rr.Type = qtype.String
+	rr.Disabled = disabled.Bool
+	rr.Auth = auth.Bool
+	rr.DisplayName = displayName.String
+	rr.OrderName = ordername.String
+	return rr, nil
+}
+
+type recordIterator struct {
+	rows storage.IResult
+	rr   *storage.Record
+	err  error
+}
+
+func (it *recordIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	it.rr, it.err = scanRecord(it.rows, true)
+	return it.err == nil
+}
+
+func (it *recordIterator) Record() *storage.Record {
+	return it.rr
+}
+
+func (it *recordIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return stacktrace.Wrap(it.rows.Err())
+}
+
+func (it *recordIterator) Close() error {
+	return stacktrace.Wrap(it.rows.Close())
+}
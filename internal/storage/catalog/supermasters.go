@@ -0,0 +1,53 @@
+package catalog
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type supermasters struct {
+	q storage.IQuerier
+}
+
+func (s *supermasters) Account(ctx context.Context, ip string, nameserver string) (string, error) {
+	account, found := "", false
+	err := query(ctx, s.q, func(rows storage.IResult) error {
+		found = true
+		return rows.Scan(&account)
+	}, "supermaster-query", "ip", ip, "nameserver", nameserver)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", stacktrace.Newf("supermaster %s %s: %w", ip, nameserver, storage.ErrNotFound)
+	}
+	return account, nil
+}
+
+func (s *supermasters) List(ctx context.Context) ([]*storage.Supermaster, error) {
+	list := make([]*storage.Supermaster, 0)
+	err := query(ctx, s.q, func(rows storage.IResult) error {
+		sm := new(storage.Supermaster)
+		if err := rows.Scan(&sm.IP, &sm.Nameserver, &sm.Account); err != nil {
+			return err
+		}
+		list = append(list, sm)
+		return nil
+	}, "list-autoprimaries")
+	return list, err
+}
+
+func (s *supermasters) Add(ctx context.Context, sm *storage.Supermaster) error {
+	_, err := exec(ctx, s.q, "supermaster-add",
+		"ip", sm.IP,
+		"nameserver", sm.Nameserver,
+		"account", sm.Account,
+	)
+	return err
+}
+
+func (s *supermasters) Remove(ctx context.Context, ip string, nameserver string) (int, error) {
+	return exec(ctx, s.q, "autoprimary-remove", "ip", ip, "nameserver", nameserver)
+}
@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type zones struct {
+	q storage.IQuerier
+}
+
+func (z *zones) ID(ctx context.Context, name string) (int, error) {
+	id := -1
+	err := query(ctx, z.q, func(rows storage.IResult) error {
+		return rows.Scan(&id)
+	}, "get-domain-id", "domain", name)
+	if err != nil {
+		return 0, err
+	}
+	if id < 0 {
+		return 0, stacktrace.Newf("zone %s: %w", name, storage.ErrNotFound)
+	}
+	return id, nil
+}
+
+func (z *zones) Get(ctx context.Context, name string) (*storage.Zone, error) {
+	var zone *storage.Zone
+	err := query(ctx, z.q, func(rows storage.IResult) error {
+		var err error
+		zone, err = scanZone(rows, false)
+		return err
+	}, "info-zone-query", "domain", name)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, stacktrace.Newf("zone %s: %w", name, storage.ErrNotFound)
+	}
+	return zone, nil
+}
+
+func (z *zones) ByID(ctx context.Context, id int) (*storage.Zone, error) {
+	var zone *storage.Zone
+	err := query(ctx, z.q, func(rows storage.IResult) error {
+		var err error
+		zone, err = scanZone(rows, false)
+		return err
+	}, "info-zone-by-id-query", "domain_id", id)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, stacktrace.Newf("zone %d: %w", id, storage.ErrNotFound)
+	}
+	return zone, nil
+}
+
+func (z *zones) List(ctx context.Context, includeDisabled bool) ([]*storage.Zone, error) {
+	list := make([]*storage.Zone, 0)
+	err := query(ctx, z.q, func(rows storage.IResult) error {
+		zone, err := scanZone(rows, true)
+		if err == nil {
+			list = append(list, zone)
+		}
+		return err
+	}, "get-all-domains-query", "include_disabled", includeDisabled)
+	return list, err
+}
+
+func (z *zones) Create(ctx context.Context, zone *storage.Zone) error {
+	if zone.ID > 0 {
+		_, err := exec(ctx, z.q, "insert-zone-with-id-query",
+			"id", zone.ID,
+			"type", zone.Kind,
+			"domain", zone.Name,
+			"masters", nullString(zone.Master),
+			"account", nullString(zone.Account),
+			"last_check", nullInt(zone.LastCheck),
+			"notified_serial", nullInt(zone.NotifiedSerial),
+		)
+		return err
+	}
+	_, err := exec(ctx, z.q, "insert-zone-query",
+		"type", zone.Kind,
+		"domain", zone.Name,
+		"masters", nullString(zone.Master),
+		"account", nullString(zone.Account),
+	)
+	return err
+}
+
+func (z *zones) SetNotified(ctx context.Context, id int, serial int64) error {
+	_, err := exec(ctx, z.q, "update-serial-query",
+		"serial", serial,
+		"domain_id", id,
+	)
+	return err
+}
+
+func (z *zones) SetLastCheck(ctx context.Context, id int, lastCheck int64) error {
+	_, err := exec(ctx, z.q, "update-lastcheck-query",
+		"last_check", lastCheck,
+		"domain_id", id,
+	)
+	return err
+}
+
+// scanZone reads info-zone-query and info-zone-by-id-query, or
+// get-all-domains-query with the SOA content as the third column.
+func scanZone(rows storage.IResult, withSOA bool) (*storage.Zone, error) {
+	zone := new(storage.Zone)
+	var master, account, soa sql.NullString
+	var lastCheck, serial sql.NullInt64
+	var err error
+	if withSOA {
+		err = rows.Scan(&zone.ID, &zone.Name, &soa, &zone.Kind, &master, &serial, &lastCheck, &account)
+	} else {
+		err = rows.Scan(&zone.ID, &zone.Name, &master, &lastCheck, &serial, &zone.Kind, &account)
+	}
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	zone.Master = master.String
+	zone.LastCheck = lastCheck.Int64
+	zone.NotifiedSerial = serial.Int64
+	zone.Account = account.String
+	zone.SOA = soa.String
+	return zone, nil
+}
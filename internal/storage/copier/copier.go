@@ -0,0 +1,288 @@
+// Package copier moves the logical content of one storage into another,
+// whatever the backends are. The journal of changes is not copied.
+package copier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+const batchSize = 1000
+
+// Table sums up the content of one table. Checksum covers the rows sorted,
+// with zones referred to by name, so it does not depend on ids.
+type Table struct {
+	Name     string `json:"name"`
+	Rows     int    `json:"rows"`
+	Checksum string `json:"sha256"`
+}
+
+type Summary []Table
+
+type Report struct {
+	Source      Summary `json:"source"`
+	Destination Summary `json:"destination"`
+}
+
+// Copy writes everything from src into the empty dst inside one transaction
+// and commits it only when the content of both sides matches. Zone and key
+// ids are preserved. Records of zones that do not exist are not copied.
+func Copy(ctx context.Context, dst storage.Store, src storage.Store) (*Report, error) {
+	if err := checkEmpty(ctx, dst.Repositories()); err != nil {
+		return nil, err
+	}
+	report := new(Report)
+	var err error
+	if report.Source, err = Summarize(ctx, src.Repositories()); err != nil {
+		return nil, err
+	}
+	tx, err := dst.Begin(ctx)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	err = copyAll(ctx, tx.Repositories(), src.Repositories())
+	if err == nil {
+		report.Destination, err = Summarize(ctx, tx.Repositories())
+	}
+	if err == nil {
+		err = report.verify()
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return report, err
+	}
+	return report, stacktrace.Wrap(tx.Commit())
+}
+
+func checkEmpty(ctx context.Context, r *storage.Repositories) error {
+	zones, err := r.Zones.List(ctx, true)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	keys, err := r.TSIG.List(ctx)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	sms, err := r.Supermasters.List(ctx)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	if len(zones)+len(keys)+len(sms) > 0 {
+		return stacktrace.New("Destination storage is not empty")
+	}
+	return nil
+}
+
+func (r *Report) verify() error {
+	mismatch := make([]string, 0)
+	for i, src := range r.Source {
+		if dst := r.Destination[i]; dst != src {
+			mismatch = append(mismatch, fmt.Sprintf("%s (%d rows, %d copied)", src.Name, src.Rows, dst.Rows))
+		}
+	}
+	if len(mismatch) > 0 {
+		return stacktrace.Newf("Copy does not match the source: %s", strings.Join(mismatch, ", "))
+	}
+	return nil
+}
+
+// zones lists every zone once, List returns one per apex SOA.
+func zones(ctx context.Context, r *storage.Repositories) ([]*storage.Zone, error) {
+	list, err := r.Zones.List(ctx, true)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	seen := make(map[int]bool, len(list))
+	unique := make([]*storage.Zone, 0, len(list))
+	for _, zone := range list {
+		if !seen[zone.ID] {
+			seen[zone.ID] = true
+			unique = append(unique, zone)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return unique[i].ID < unique[j].ID
+	})
+	return unique, nil
+}
+
+func copyAll(ctx context.Context, dst *storage.Repositories, src *storage.Repositories) error {
+	list, err := zones(ctx, src)
+	if err != nil {
+		return err
+	}
+	for _, zone := range list {
+		if err = copyZone(ctx, dst, src, zone); err != nil {
+			return stacktrace.Newf("zone %s: %w", zone.Name, err)
+		}
+	}
+	keys, err := src.TSIG.List(ctx)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	for _, key := range keys {
+		if err = dst.TSIG.Set(ctx, key); err != nil {
+			return stacktrace.Wrap(err)
+		}
+	}
+	sms, err := src.Supermasters.List(ctx)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	for _, sm := range sms {
+		if err = dst.Supermasters.Add(ctx, sm); err != nil {
+			return stacktrace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func copyZone(ctx context.Context, dst *storage.Repositories, src *storage.Repositories, zone *storage.Zone) error {
+	if err := dst.Zones.Create(ctx, zone); err != nil {
+		return err
+	}
+	id, err := dst.Zones.ID(ctx, zone.Name)
+	if err != nil {
+		return err
+	}
+	it, err := src.Records.List(ctx, zone.ID, true)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	batch := make([]*storage.Record, 0, batchSize)
+	for it.Next() {
+		rr := it.Record()
+		rr.DomainID = id
+		batch = append(batch, rr)
+		if len(batch) == batchSize {
+			if _, err = dst.Records.Insert(ctx, batch...); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err = it.Err(); err != nil {
+		return err
+	}
+	if _, err = dst.Records.Insert(ctx, batch...); err != nil {
+		return err
+	}
+	meta, err := src.Metadata.GetAll(ctx, zone.Name)
+	if err != nil {
+		return err
+	}
+	for kind, values := range meta {
+		if err = dst.Metadata.Set(ctx, zone.Name, kind, values); err != nil {
+			return err
+		}
+	}
+	keys, err := src.Keys.List(ctx, zone.Name)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err = dst.Keys.Add(ctx, zone.Name, key); err != nil {
+			return err
+		}
+	}
+	comments, err := src.Comments.List(ctx, zone.ID)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		c.DomainID = id
+		if err = dst.Comments.Insert(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Summarize counts and checksums every table of r.
+func Summarize(ctx context.Context, r *storage.Repositories) (Summary, error) {
+	list, err := zones(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	tables := map[string][]string{}
+	add := func(table string, fields ...interface{}) {
+		tables[table] = append(tables[table], fmt.Sprintf(strings.Repeat("%v\t", len(fields)), fields...))
+	}
+	for _, zone := range list {
+		add("domains", zone.Name, zone.Kind, zone.Master, zone.Account, zone.LastCheck, zone.NotifiedSerial)
+		it, err := r.Records.List(ctx, zone.ID, true)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		for it.Next() {
+			rr := it.Record()
+			add("records", zone.Name, rr.Name, rr.Type, rr.Content, rr.TTL, rr.Prio, rr.Disabled, rr.OrderName, rr.Auth)
+		}
+		err = it.Err()
+		it.Close()
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		meta, err := r.Metadata.GetAll(ctx, zone.Name)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		for kind, values := range meta {
+			for _, value := range values {
+				add("domainmetadata", zone.Name, kind, value)
+			}
+		}
+		keys, err := r.Keys.List(ctx, zone.Name)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		for _, key := range keys {
+			add("cryptokeys", zone.Name, key.ID, key.Flags, key.Active, key.Published, key.Content)
+		}
+		comments, err := r.Comments.List(ctx, zone.ID)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		for _, c := range comments {
+			add("comments", zone.Name, c.Name, c.Type, c.ModifiedAt, c.Account, c.Comment)
+		}
+	}
+	keys, err := r.TSIG.List(ctx)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	for _, key := range keys {
+		add("tsigkeys", key.Name, key.Algorithm, key.Secret)
+	}
+	sms, err := r.Supermasters.List(ctx)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	for _, sm := range sms {
+		add("supermasters", sm.IP, strings.ToLower(sm.Nameserver), sm.Account)
+	}
+	summary := make(Summary, 0, 7)
+	for _, name := range []string{"domains", "records", "domainmetadata", "cryptokeys", "tsigkeys", "supermasters", "comments"} {
+		lines := tables[name]
+		sort.Strings(lines)
+		h := sha256.New()
+		for _, line := range lines {
+			h.Write([]byte(line))
+			h.Write([]byte{'\n'})
+		}
+		summary = append(summary, Table{
+			Name:     name,
+			Rows:     len(lines),
+			Checksum: hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+	return summary, nil
+}
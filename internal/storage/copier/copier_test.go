@@ -0,0 +1,219 @@
+package copier
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/memory"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+const seed = `{
+  "zones": [{
+    "name": "copy.test.",
+    "records": [
+      {"type": "SOA", "content": "ns.copy.test. admin.copy.test. 1 3600 600 86400 60", "ttl": 3600},
+      {"name": "www.copy.test.", "type": "A", "content": "192.0.2.1", "ttl": 60, "ordername": "www"},
+      {"name": "mail.copy.test.", "type": "MX", "content": "mail.copy.test.", "ttl": 60, "prio": 10, "disabled": true}
+    ],
+    "metadata": {"SOA-EDIT": ["INCEPTION-INCREMENT"]},
+    "keys": [{"flags": 257, "active": true, "published": true, "content": "Private-key-format: v1.2"}],
+    "comments": [{"name": "www.copy.test.", "type": "A", "account": "ops", "comment": "web"}]
+  }, {
+    "name": "slave.test.",
+    "kind": "SLAVE",
+    "master": "192.0.2.53"
+  }],
+  "tsig_keys": [{"name": "k.", "algorithm": "hmac-sha256", "secret": "c2VjcmV0"}],
+  "supermasters": [{"ip": "192.0.2.53", "nameserver": "ns.copy.test.", "account": "ops"}]
+}`
+
+func newSqlite(t *testing.T) storage.Store {
+	db := sqlite.New(":memory:")
+	t.Cleanup(db.Close)
+	if err := db.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	return catalog.New(db)
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	src, err := memory.Load(strings.NewReader(seed))
+	assert.Equal(t, err, nil)
+
+	db := newSqlite(t)
+	report, err := Copy(ctx, db, src)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Source, report.Destination)
+	rows := map[string]int{}
+	for _, table := range report.Destination {
+		rows[table.Name] = table.Rows
+	}
+	assert.Equal(t, rows, map[string]int{
+		"domains": 2, "records": 3, "domainmetadata": 1, "cryptokeys": 1,
+		"tsigkeys": 1, "supermasters": 1, "comments": 1,
+	})
+
+	// ids survive a round trip
+	back := memory.New()
+	report, err = Copy(ctx, back, db)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Source, report.Destination)
+	for _, name := range []string{"copy.test.", "slave.test."} {
+		want, err := src.Repositories().Zones.ID(ctx, name)
+		assert.Equal(t, err, nil)
+		got, err := back.Repositories().Zones.ID(ctx, name)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, got, want)
+	}
+
+	_, err = Copy(ctx, back, db)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestSummarizeDiffers(t *testing.T) {
+	ctx := context.Background()
+	a, err := memory.Load(strings.NewReader(seed))
+	assert.Equal(t, err, nil)
+	b, err := memory.Load(strings.NewReader(seed))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, b.Repositories().Metadata.Set(ctx, "copy.test.", "SOA-EDIT", []string{"EPOCH"}), nil)
+
+	sa, err := Summarize(ctx, a.Repositories())
+	assert.Equal(t, err, nil)
+	sb, err := Summarize(ctx, b.Repositories())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, (&Report{Source: sa, Destination: sa}).verify(), nil)
+	err = (&Report{Source: sa, Destination: sb}).verify()
+	assert.NotEqual(t, err, nil)
+	assert.Contains(t, err.Error(), "domainmetadata")
+}
+
+// stockSchema is the schema.sqlite3.sql of PowerDNS 4 gsqlite3.
+const stockSchema = `
+PRAGMA foreign_keys = 1;
+CREATE TABLE domains (
+  id                    INTEGER PRIMARY KEY,
+  name                  VARCHAR(255) NOT NULL COLLATE NOCASE,
+  master                VARCHAR(128) DEFAULT NULL,
+  last_check            INTEGER DEFAULT NULL,
+  type                  VARCHAR(6) NOT NULL,
+  notified_serial       INTEGER DEFAULT NULL,
+  account               VARCHAR(40) DEFAULT NULL
+);
+CREATE UNIQUE INDEX name_index ON domains(name);
+CREATE TABLE records (
+  id                    INTEGER PRIMARY KEY,
+  domain_id             INTEGER DEFAULT NULL,
+  name                  VARCHAR(255) DEFAULT NULL,
+  type                  VARCHAR(10) DEFAULT NULL,
+  content               VARCHAR(65535) DEFAULT NULL,
+  ttl                   INTEGER DEFAULT NULL,
+  prio                  INTEGER DEFAULT NULL,
+  disabled              BOOLEAN DEFAULT 0,
+  ordername             VARCHAR(255),
+  auth                  BOOL DEFAULT 1,
+  FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX records_lookup_idx ON records(name, type);
+CREATE INDEX records_lookup_id_idx ON records(domain_id, name, type);
+CREATE INDEX records_order_idx ON records(domain_id, ordername);
+CREATE TABLE supermasters (
+  ip                    VARCHAR(64) NOT NULL,
+  nameserver            VARCHAR(255) NOT NULL COLLATE NOCASE,
+  account               VARCHAR(40) NOT NULL
+);
+CREATE UNIQUE INDEX ip_nameserver_pk ON supermasters(ip, nameserver);
+CREATE TABLE comments (
+  id                    INTEGER PRIMARY KEY,
+  domain_id             INTEGER NOT NULL,
+  name                  VARCHAR(255) NOT NULL,
+  type                  VARCHAR(10) NOT NULL,
+  modified_at           INT NOT NULL,
+  account               VARCHAR(40) DEFAULT NULL,
+  comment               VARCHAR(65535) NOT NULL,
+  FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX comments_idx ON comments(domain_id, name, type);
+CREATE INDEX comments_order_idx ON comments (domain_id, modified_at);
+CREATE TABLE domainmetadata (
+ id                     INTEGER PRIMARY KEY,
+ domain_id              INT NOT NULL,
+ kind                   VARCHAR(32) COLLATE NOCASE,
+ content                TEXT,
+ FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX domainmetaidindex ON domainmetadata(domain_id);
+CREATE TABLE cryptokeys (
+ id                     INTEGER PRIMARY KEY,
+ domain_id              INT NOT NULL,
+ flags                  INT NOT NULL,
+ active                 BOOL,
+ published              BOOL DEFAULT 1,
+ content                TEXT,
+ FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX domainidindex ON cryptokeys(domain_id);
+CREATE TABLE tsigkeys (
+ id                     INTEGER PRIMARY KEY,
+ name                   VARCHAR(255) COLLATE NOCASE,
+ algorithm              VARCHAR(50) COLLATE NOCASE,
+ secret                 VARCHAR(255)
+);
+CREATE UNIQUE INDEX namealgoindex ON tsigkeys(name, algorithm);
+
+INSERT INTO domains (id, name, type) VALUES (3, 'stock.test.', 'NATIVE');
+INSERT INTO records (domain_id, name, type, content, ttl, prio, ordername, auth) VALUES
+  (3, 'stock.test.', 'SOA', 'ns.stock.test. admin.stock.test. 1 3600 600 86400 60', 3600, 0, '', 1),
+  (3, 'WWW.Stock.test.', 'A', '192.0.2.1', 60, 0, 'www', 1),
+  (3, 'stock.test.', 'MX', 'mail.stock.test.', 60, 10, '', 1);
+INSERT INTO domainmetadata (domain_id, kind, content) VALUES (3, 'SOA-EDIT', 'INCEPTION-INCREMENT');
+INSERT INTO tsigkeys (name, algorithm, secret) VALUES ('k.', 'hmac-sha256', 'c2VjcmV0');
+`
+
+func TestCopyStockSqlite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "pdns.sqlite3")
+	stock, err := sql.Open("sqlite3", path)
+	assert.Equal(t, err, nil)
+	_, err = stock.Exec(stockSchema)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, stock.Close(), nil)
+
+	src, cleanup, err := sqlite.OpenMigrated(ctx, path)
+	if !assert.Equal(t, err, nil) {
+		return
+	}
+	defer cleanup()
+	dst := memory.New()
+	report, err := Copy(ctx, dst, catalog.New(src))
+	assert.Equal(t, err, nil)
+	rows := map[string]int{}
+	for _, table := range report.Destination {
+		rows[table.Name] = table.Rows
+	}
+	assert.Equal(t, rows, map[string]int{
+		"domains": 1, "records": 3, "domainmetadata": 1, "cryptokeys": 0,
+		"tsigkeys": 1, "supermasters": 0, "comments": 0,
+	})
+	rrs, err := dst.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: "www.stock.test.", Type: "A", DomainID: 3})
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(rrs), 1) {
+		assert.Equal(t, rrs[0].DisplayName, "WWW.Stock.test.")
+	}
+
+	// the source keeps its schema
+	stock, err = sql.Open("sqlite3", path)
+	assert.Equal(t, err, nil)
+	defer stock.Close()
+	var version int
+	assert.Equal(t, stock.QueryRow("PRAGMA user_version").Scan(&version), nil)
+	assert.Equal(t, version, 0)
+}
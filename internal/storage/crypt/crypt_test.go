@@ -0,0 +1,120 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/ivan-bokov/go-pdns/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKEK(t *testing.T, b byte) *KEK {
+	kek, err := NewKEK(bytes.Repeat([]byte{b}, kekSize))
+	assert.Equal(t, err, nil)
+	return kek
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	kr := NewKeyring(newKEK(t, 1))
+	enc, err := kr.Encrypt("Private-key-format: v1.2")
+	assert.Equal(t, err, nil)
+	assert.True(t, IsEncrypted(enc))
+	assert.NotContains(t, enc, "Private-key-format")
+
+	dec, err := kr.Decrypt(enc)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, dec, "Private-key-format: v1.2")
+
+	dec, err = kr.Decrypt("plain")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, dec, "plain")
+
+	_, err = NewKeyring(newKEK(t, 2)).Decrypt(enc)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestNewKEK(t *testing.T) {
+	raw := bytes.Repeat([]byte{7}, kekSize)
+	k1, err := NewKEK(raw)
+	assert.Equal(t, err, nil)
+	k2, err := NewKEK([]byte("BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, k1.ID(), k2.ID())
+	_, err = NewKEK([]byte("short"))
+	assert.NotEqual(t, err, nil)
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	db := sqlite.New(":memory:")
+	defer db.Close()
+	assert.Equal(t, db.CreateTable(), nil)
+	old := NewKeyring(newKEK(t, 1))
+	stg := New(db, old)
+
+	_, err := stg.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", "crypt.test.")
+	assert.Equal(t, err, nil)
+	_, err = stg.ExecContext(ctx, "add-domain-key-query", "domain", "crypt.test.", "flags", 257, "active", true, "published", true, "content", "secret key")
+	assert.Equal(t, err, nil)
+	_, err = stg.ExecContext(ctx, "set-tsig-key-query", "key_name", "tsig", "algorithm", "hmac-sha256", "content", "c2VjcmV0")
+	assert.Equal(t, err, nil)
+
+	assert.True(t, IsEncrypted(rawKey(t, db)))
+	assert.Equal(t, plainKey(t, stg), "secret key")
+
+	rows, err := stg.QueryContext(ctx, "get-tsig-key-query", "key_name", "tsig")
+	assert.Equal(t, err, nil)
+	assert.True(t, rows.Next())
+	var algorithm, secret string
+	assert.Equal(t, rows.Scan(&algorithm, &secret), nil)
+	assert.Equal(t, secret, "c2VjcmV0")
+	assert.Equal(t, rows.Close(), nil)
+
+	// rotation: the new keyring still knows the old KEK until everything is re-wrapped
+	current := newKEK(t, 2)
+	n, err := Rewrap(ctx, db, NewKeyring(current, old.current))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 2)
+	n, err = Rewrap(ctx, db, NewKeyring(current, old.current))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 0)
+	assert.Equal(t, plainKey(t, New(db, NewKeyring(current))), "secret key")
+}
+
+func rawKey(t *testing.T, db *sqlite.Sqlite) string {
+	rows, err := db.Query("list-all-domain-keys-content-query")
+	assert.Equal(t, err, nil)
+	defer rows.Close()
+	assert.True(t, rows.Next())
+	var id int
+	var content string
+	assert.Equal(t, rows.Scan(&id, &content), nil)
+	return content
+}
+
+func plainKey(t *testing.T, stg *Storage) string {
+	rows, err := stg.Query("list-domain-keys-query", "domain", "crypt.test.")
+	assert.Equal(t, err, nil)
+	defer rows.Close()
+	assert.True(t, rows.Next())
+	var id, flags int
+	var active, published bool
+	var content string
+	assert.Equal(t, rows.Scan(&id, &flags, &active, &published, &content), nil)
+	return content
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Store {
+		db := sqlite.New(":memory:")
+		t.Cleanup(db.Close)
+		if err := db.CreateTable(); err != nil {
+			t.Fatal(err)
+		}
+		return catalog.New(New(db, NewKeyring(newKEK(t, 1))))
+	})
+}
@@ -0,0 +1,150 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// An encrypted value is stored as
+//
+//	enc:v1:<kek id>:<base64 wrapped data key>:<base64 nonce|ciphertext>
+//
+// Every value has its own random data key, the KEK only wraps that key.
+const prefix = "enc:v1:"
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func (kr *Keyring) Encrypt(plaintext string) (string, error) {
+	dek := make([]byte, kekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", stacktrace.Wrap(err)
+	}
+	wrapped, err := seal(kr.current.key, dek)
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(dek, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return format(kr.current.id, wrapped, data), nil
+}
+
+// Decrypt returns plaintext values unchanged, so rows written before
+// encryption was enabled keep working.
+func (kr *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	id, wrapped, data, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	kek, err := kr.kek(id)
+	if err != nil {
+		return "", err
+	}
+	dek, err := open(kek.key, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dek, data)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap re-encrypts only the data key under the current KEK. Plaintext
+// values are encrypted.
+func (kr *Keyring) Rewrap(value string) (string, bool, error) {
+	if !IsEncrypted(value) {
+		enc, err := kr.Encrypt(value)
+		return enc, err == nil, err
+	}
+	id, wrapped, data, err := parse(value)
+	if err != nil {
+		return "", false, err
+	}
+	if id == kr.current.id {
+		return value, false, nil
+	}
+	kek, err := kr.kek(id)
+	if err != nil {
+		return "", false, err
+	}
+	dek, err := open(kek.key, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	if wrapped, err = seal(kr.current.key, dek); err != nil {
+		return "", false, err
+	}
+	return format(kr.current.id, wrapped, data), true, nil
+}
+
+func format(id string, wrapped []byte, data []byte) string {
+	return prefix + id + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(data)
+}
+
+func parse(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, stacktrace.New("malformed encrypted value")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, stacktrace.Wrap(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, stacktrace.Wrap(err)
+	}
+	return parts[0], wrapped, data, nil
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, stacktrace.New("encrypted value is too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,85 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+const kekSize = 32
+
+// KEK is a key-encryption-key, it only wraps per-value data keys.
+type KEK struct {
+	id  string
+	key []byte
+}
+
+func (k *KEK) ID() string {
+	return k.id
+}
+
+// NewKEK accepts 32 raw bytes, or the same encoded as base64 or hex.
+func NewKEK(material []byte) (*KEK, error) {
+	key := material
+	if len(key) != kekSize {
+		text := strings.TrimSpace(string(material))
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(text); err != nil || len(key) != kekSize {
+			if key, err = hex.DecodeString(text); err != nil || len(key) != kekSize {
+				return nil, stacktrace.New("key-encryption-key must be 32 bytes, raw, base64 or hex")
+			}
+		}
+	}
+	sum := sha256.Sum256(key)
+	return &KEK{
+		id:  hex.EncodeToString(sum[:4]),
+		key: key,
+	}, nil
+}
+
+func LoadKEKFile(path string) (*KEK, error) {
+	material, err := os.ReadFile(path)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return NewKEK(material)
+}
+
+func LoadKEKEnv(name string) (*KEK, error) {
+	material, ok := os.LookupEnv(name)
+	if !ok || material == "" {
+		return nil, stacktrace.Newf("environment variable %s is not set", name)
+	}
+	return NewKEK([]byte(material))
+}
+
+// Keyring encrypts with the current KEK and decrypts with any known one,
+// so values wrapped by a retired KEK stay readable until re-wrapped.
+type Keyring struct {
+	current *KEK
+	keys    map[string]*KEK
+}
+
+func NewKeyring(current *KEK, retired ...*KEK) *Keyring {
+	kr := &Keyring{
+		current: current,
+		keys:    make(map[string]*KEK, len(retired)+1),
+	}
+	for _, k := range retired {
+		kr.keys[k.id] = k
+	}
+	kr.keys[current.id] = current
+	return kr
+}
+
+func (kr *Keyring) kek(id string) (*KEK, error) {
+	k, ok := kr.keys[id]
+	if !ok {
+		return nil, stacktrace.Newf("unknown key-encryption-key %s", id)
+	}
+	return k, nil
+}
@@ -0,0 +1,86 @@
+package crypt
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// Rewrap moves every secret in stg, the undecorated storage, under the
+// current KEK of kr in one transaction and returns how many values changed.
+// Plaintext values left from before encryption are encrypted on the way.
+func Rewrap(ctx context.Context, stg storage.IStorage, kr *Keyring) (int, error) {
+	t, err := stg.Begin(ctx)
+	if err != nil {
+		return 0, stacktrace.Wrap(err)
+	}
+	n, err := rewrap(ctx, t, kr)
+	if err != nil {
+		_ = t.Rollback()
+		return 0, err
+	}
+	return n, stacktrace.Wrap(t.Commit())
+}
+
+type secret struct {
+	args  []interface{}
+	value string
+}
+
+func rewrap(ctx context.Context, t storage.ITx, kr *Keyring) (int, error) {
+	keys, err := collect(ctx, t, "list-all-domain-keys-content-query", func(rows storage.IResult) (*secret, error) {
+		var id int
+		var content string
+		err := rows.Scan(&id, &content)
+		return &secret{args: []interface{}{"key_id", id}, value: content}, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	tsig, err := collect(ctx, t, "get-tsig-keys-query", func(rows storage.IResult) (*secret, error) {
+		var name, algorithm, content string
+		err := rows.Scan(&name, &algorithm, &content)
+		return &secret{args: []interface{}{"key_name", name, "algorithm", algorithm}, value: content}, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for stmt, secrets := range map[string][]*secret{
+		"update-domain-key-content-query": keys,
+		"update-tsig-key-secret-query":    tsig,
+	} {
+		for _, sc := range secrets {
+			value, changed, err := kr.Rewrap(sc.value)
+			if err != nil {
+				return n, err
+			}
+			if !changed {
+				continue
+			}
+			if _, err = t.ExecContext(ctx, stmt, append(sc.args, "content", value)...); err != nil {
+				return n, stacktrace.Wrap(err)
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func collect(ctx context.Context, t storage.ITx, stmt string, scan func(rows storage.IResult) (*secret, error)) ([]*secret, error) {
+	rows, err := t.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	defer rows.Close()
+	secrets := make([]*secret, 0)
+	for rows.Next() {
+		sc, err := scan(rows)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+		secrets = append(secrets, sc)
+	}
+	return secrets, stacktrace.Wrap(rows.Err())
+}
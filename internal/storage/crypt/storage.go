@@ -0,0 +1,173 @@
+package crypt
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// encryptArgs names the argument holding a secret, per statement.
+var encryptArgs = map[string]string{
+	"add-domain-key-query":         "content",
+	"add-domain-key-with-id-query": "content",
+	"set-tsig-key-query":           "content",
+}
+
+// decryptColumns is the index of the secret column, per statement.
+var decryptColumns = map[string]int{
+	"list-domain-keys-query": 4,
+	"get-tsig-key-query":     1,
+	"get-tsig-keys-query":    2,
+}
+
+// Storage encrypts DNSSEC private keys and TSIG secrets on the way into the
+// wrapped storage and decrypts them on the way out.
+type Storage struct {
+	storage.IStorage
+	kr *Keyring
+}
+
+func New(stg storage.IStorage, kr *Keyring) *Storage {
+	return &Storage{
+		IStorage: stg,
+		kr:       kr,
+	}
+}
+
+func (s *Storage) Query(stmt string, args ...interface{}) (storage.IResult, error) {
+	return s.QueryContext(context.Background(), stmt, args...)
+}
+
+func (s *Storage) Exec(stmt string, args ...interface{}) (int, error) {
+	return s.ExecContext(context.Background(), stmt, args...)
+}
+
+func (s *Storage) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	return query(ctx, s.IStorage, s.kr, stmt, args...)
+}
+
+func (s *Storage) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	return exec(ctx, s.IStorage, s.kr, stmt, args...)
+}
+
+func (s *Storage) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	return execBatch(ctx, s.IStorage, s.kr, stmt, batch)
+}
+
+func (s *Storage) Begin(ctx context.Context) (storage.ITx, error) {
+	t, err := s.IStorage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{ITx: t, kr: s.kr}, nil
+}
+
+type tx struct {
+	storage.ITx
+	kr *Keyring
+}
+
+func (t *tx) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	return query(ctx, t.ITx, t.kr, stmt, args...)
+}
+
+func (t *tx) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	return exec(ctx, t.ITx, t.kr, stmt, args...)
+}
+
+func (t *tx) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	return execBatch(ctx, t.ITx, t.kr, stmt, batch)
+}
+
+func query(ctx context.Context, q storage.IQuerier, kr *Keyring, stmt string, args ...interface{}) (storage.IResult, error) {
+	rows, err := q.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	if column, ok := decryptColumns[stmt]; ok {
+		return &result{IResult: rows, kr: kr, column: column}, nil
+	}
+	return rows, nil
+}
+
+func exec(ctx context.Context, q storage.IQuerier, kr *Keyring, stmt string, args ...interface{}) (int, error) {
+	args, err := encrypt(kr, stmt, args)
+	if err != nil {
+		return 0, err
+	}
+	return q.ExecContext(ctx, stmt, args...)
+}
+
+func execBatch(ctx context.Context, q storage.IQuerier, kr *Keyring, stmt string, batch [][]interface{}) (int, error) {
+	if _, ok := encryptArgs[stmt]; ok {
+		encrypted := make([][]interface{}, len(batch))
+		for i, args := range batch {
+			var err error
+			if encrypted[i], err = encrypt(kr, stmt, args); err != nil {
+				return 0, err
+			}
+		}
+		batch = encrypted
+	}
+	return q.ExecBatchContext(ctx, stmt, batch)
+}
+
+func encrypt(kr *Keyring, stmt string, args []interface{}) ([]interface{}, error) {
+	name, ok := encryptArgs[stmt]
+	if !ok {
+		return args, nil
+	}
+	out := make([]interface{}, len(args))
+	copy(out, args)
+	for i := 0; i+1 < len(out); i += 2 {
+		if key, ok := out[i].(string); !ok || key != name {
+			continue
+		}
+		var plaintext string
+		switch v := out[i+1].(type) {
+		case string:
+			plaintext = v
+		case []byte:
+			plaintext = string(v)
+		default:
+			continue
+		}
+		enc, err := kr.Encrypt(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		out[i+1] = enc
+	}
+	return out, nil
+}
+
+type result struct {
+	storage.IResult
+	kr     *Keyring
+	column int
+}
+
+func (r *result) Scan(dest ...interface{}) error {
+	if err := r.IResult.Scan(dest...); err != nil {
+		return err
+	}
+	if r.column >= len(dest) {
+		return nil
+	}
+	var err error
+	switch d := dest[r.column].(type) {
+	case *string:
+		*d, err = r.kr.Decrypt(*d)
+	case *sql.NullString:
+		if d.Valid {
+			d.String, err = r.kr.Decrypt(d.String)
+		}
+	case *[]byte:
+		var plaintext string
+		plaintext, err = r.kr.Decrypt(string(*d))
+		*d = []byte(plaintext)
+	}
+	return stacktrace.Wrap(err)
+}
@@ -0,0 +1,299 @@
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+var ErrInjected = errors.New("injected fault")
+
+const (
+	// Any matches every statement without a rule of its own
+	Any = "*"
+	// Begin and Commit name the transaction calls
+	Begin  = "begin"
+	Commit = "commit"
+)
+
+// Rule describes the faults of one statement name. Rates are probabilities
+// from 0 to 1.
+type Rule struct {
+	Stmt      string        `json:"stmt"`
+	ErrorRate float64       `json:"error_rate"`
+	Latency   time.Duration `json:"latency"`
+	// PartialRate truncates query results after PartialRows rows, the
+	// result then reports the error
+	PartialRate float64 `json:"partial_rate"`
+	PartialRows int     `json:"partial_rows"`
+	// Transient faults are retried by the retry decorator
+	Transient bool `json:"transient"`
+	// Injected counts the faults injected by the rule
+	Injected uint64 `json:"injected"`
+}
+
+type jsonRule Rule
+
+// MarshalJSON writes Latency as a duration string such as "200ms".
+func (r Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		jsonRule
+		Latency string `json:"latency"`
+	}{jsonRule(r), r.Latency.String()})
+}
+
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	v := struct {
+		*jsonRule
+		Latency string `json:"latency"`
+	}{jsonRule: (*jsonRule)(r)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	r.Latency = 0
+	if v.Latency == "" {
+		return nil
+	}
+	latency, err := time.ParseDuration(v.Latency)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	r.Latency = latency
+	return nil
+}
+
+type Option func(s *Storage)
+
+// WithSeed makes the injected faults reproducible.
+func WithSeed(seed int64) Option {
+	return func(s *Storage) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// Storage injects errors, latency and truncated results into the calls of
+// the storage it wraps, per statement name. Without rules it is transparent.
+type Storage struct {
+	storage.IStorage
+
+	mu    sync.Mutex
+	rules map[string]*Rule
+	rand  *rand.Rand
+}
+
+func New(stg storage.IStorage, opts ...Option) *Storage {
+	s := &Storage{
+		IStorage: stg,
+		rules:    make(map[string]*Rule),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Set adds or replaces the rule of rule.Stmt.
+func (s *Storage) Set(rule Rule) {
+	rule.Injected = 0
+	s.mu.Lock()
+	s.rules[rule.Stmt] = &rule
+	s.mu.Unlock()
+}
+
+func (s *Storage) Clear(stmt string) {
+	s.mu.Lock()
+	delete(s.rules, stmt)
+	s.mu.Unlock()
+}
+
+func (s *Storage) Reset() {
+	s.mu.Lock()
+	s.rules = make(map[string]*Rule)
+	s.mu.Unlock()
+}
+
+func (s *Storage) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := make([]Rule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, *rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Stmt < rules[j].Stmt
+	})
+	return rules
+}
+
+// fault is what happens to one call.
+type fault struct {
+	rule    *Rule
+	latency time.Duration
+	err     error
+	partial bool
+	rows    int
+}
+
+func (s *Storage) roll(stmt string) fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule, ok := s.rules[stmt]
+	if !ok {
+		if rule, ok = s.rules[Any]; !ok {
+			return fault{}
+		}
+	}
+	f := fault{rule: rule, latency: rule.Latency}
+	if rule.ErrorRate > 0 && s.rand.Float64() < rule.ErrorRate {
+		f.err = s.injected(rule, stmt)
+	} else if rule.PartialRate > 0 && s.rand.Float64() < rule.PartialRate {
+		f.partial = true
+		f.rows = rule.PartialRows
+	}
+	return f
+}
+
+// injected counts the fault, s.mu must be held.
+func (s *Storage) injected(rule *Rule, stmt string) error {
+	rule.Injected++
+	err := stacktrace.Newf("%s: %w", stmt, ErrInjected)
+	if rule.Transient {
+		return storage.Transient(err)
+	}
+	return err
+}
+
+// before sleeps the latency of the fault and returns its error.
+func (f fault) before(ctx context.Context) error {
+	if f.latency > 0 {
+		timer := time.NewTimer(f.latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return stacktrace.Wrap(ctx.Err())
+		case <-timer.C:
+		}
+	}
+	return f.err
+}
+
+func (s *Storage) Query(stmt string, args ...interface{}) (storage.IResult, error) {
+	return s.QueryContext(context.Background(), stmt, args...)
+}
+
+func (s *Storage) Exec(stmt string, args ...interface{}) (int, error) {
+	return s.ExecContext(context.Background(), stmt, args...)
+}
+
+func (s *Storage) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	return s.query(ctx, s.IStorage, stmt, args...)
+}
+
+func (s *Storage) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	if err := s.roll(stmt).before(ctx); err != nil {
+		return 0, err
+	}
+	return s.IStorage.ExecContext(ctx, stmt, args...)
+}
+
+func (s *Storage) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	if err := s.roll(stmt).before(ctx); err != nil {
+		return 0, err
+	}
+	return s.IStorage.ExecBatchContext(ctx, stmt, batch)
+}
+
+func (s *Storage) Begin(ctx context.Context) (storage.ITx, error) {
+	if err := s.roll(Begin).before(ctx); err != nil {
+		return nil, err
+	}
+	t, err := s.IStorage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{ITx: t, s: s}, nil
+}
+
+func (s *Storage) query(ctx context.Context, q storage.IQuerier, stmt string, args ...interface{}) (storage.IResult, error) {
+	f := s.roll(stmt)
+	if err := f.before(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, stmt, args...)
+	if err != nil || !f.partial {
+		return rows, err
+	}
+	return &result{IResult: rows, s: s, rule: f.rule, stmt: stmt, left: f.rows}, nil
+}
+
+type tx struct {
+	storage.ITx
+	s *Storage
+}
+
+func (t *tx) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	return t.s.query(ctx, t.ITx, stmt, args...)
+}
+
+func (t *tx) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	if err := t.s.roll(stmt).before(ctx); err != nil {
+		return 0, err
+	}
+	return t.ITx.ExecContext(ctx, stmt, args...)
+}
+
+func (t *tx) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	if err := t.s.roll(stmt).before(ctx); err != nil {
+		return 0, err
+	}
+	return t.ITx.ExecBatchContext(ctx, stmt, batch)
+}
+
+// Commit rolls the transaction back when a fault is injected, as a failed
+// commit would.
+func (t *tx) Commit() error {
+	if err := t.s.roll(Commit).before(context.Background()); err != nil {
+		_ = t.ITx.Rollback()
+		return err
+	}
+	return t.ITx.Commit()
+}
+
+// result stops after left rows and reports an injected error.
+type result struct {
+	storage.IResult
+	s    *Storage
+	rule *Rule
+	stmt string
+	left int
+	err  error
+}
+
+func (r *result) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.left <= 0 {
+		r.s.mu.Lock()
+		r.err = r.s.injected(r.rule, r.stmt)
+		r.s.mu.Unlock()
+		return false
+	}
+	r.left--
+	return r.IResult.Next()
+}
+
+func (r *result) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.IResult.Err()
+}
@@ -0,0 +1,166 @@
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/service"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/retry"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/ivan-bokov/go-pdns/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStorage(t *testing.T) *Storage {
+	db := sqlite.New(":memory:")
+	t.Cleanup(db.Close)
+	assert.Equal(t, db.CreateTable(), nil)
+	return New(db, WithSeed(1))
+}
+
+func setTSIGKeys(t *testing.T, r *storage.Repositories, names ...string) {
+	for _, name := range names {
+		assert.Equal(t, r.TSIG.Set(context.Background(), &storage.TSIGKey{Name: name, Algorithm: "hmac-sha256", Secret: "c2VjcmV0"}), nil)
+	}
+}
+
+func TestStorage_Error(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+	r := catalog.New(s).Repositories()
+
+	s.Set(Rule{Stmt: "get-tsig-keys-query", ErrorRate: 1})
+	_, err := r.TSIG.List(ctx)
+	assert.True(t, errors.Is(err, ErrInjected))
+	assert.False(t, storage.IsTransient(err))
+	_, err = r.TSIG.Get(ctx, "xfr.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	rules := s.Rules()
+	assert.Equal(t, len(rules), 1)
+	assert.Equal(t, rules[0].Injected, uint64(1))
+
+	s.Clear("get-tsig-keys-query")
+	_, err = r.TSIG.List(ctx)
+	assert.Equal(t, err, nil)
+
+	s.Set(Rule{Stmt: Any, ErrorRate: 1, Transient: true})
+	_, err = r.TSIG.Get(ctx, "xfr.")
+	assert.True(t, errors.Is(err, ErrInjected))
+	assert.True(t, storage.IsTransient(err))
+	s.Reset()
+	assert.Equal(t, len(s.Rules()), 0)
+}
+
+func TestStorage_Latency(t *testing.T) {
+	s := newStorage(t)
+	r := catalog.New(s).Repositories()
+	s.Set(Rule{Stmt: "basic-query", Latency: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "www.test.", Type: "A", DomainID: -1})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+}
+
+func TestStorage_Partial(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+	r := catalog.New(s).Repositories()
+	setTSIGKeys(t, r, "a.", "b.", "c.")
+
+	s.Set(Rule{Stmt: "get-tsig-keys-query", PartialRate: 1, PartialRows: 2})
+	rows, err := s.QueryContext(ctx, "get-tsig-keys-query")
+	assert.Equal(t, err, nil)
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	assert.Equal(t, n, 2)
+	assert.True(t, errors.Is(rows.Err(), ErrInjected))
+	assert.Equal(t, rows.Close(), nil)
+
+	_, err = r.TSIG.List(ctx)
+	assert.True(t, errors.Is(err, ErrInjected))
+}
+
+func TestStorage_Commit(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+	store := catalog.New(s)
+	s.Set(Rule{Stmt: Commit, ErrorRate: 1})
+
+	tx, err := store.Begin(ctx)
+	assert.Equal(t, err, nil)
+	setTSIGKeys(t, tx.Repositories(), "lost.")
+	assert.True(t, errors.Is(tx.Commit(), ErrInjected))
+
+	s.Reset()
+	_, err = store.Repositories().TSIG.Get(ctx, "lost.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+}
+
+func TestStorage_Retry(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+	retrying := retry.New(s, retry.Policy{MaxAttempts: 20, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	r := catalog.New(retrying).Repositories()
+	setTSIGKeys(t, r, "a.")
+
+	s.Set(Rule{Stmt: "get-tsig-keys-query", ErrorRate: 0.5, Transient: true})
+	for i := 0; i < 20; i++ {
+		keys, err := r.TSIG.List(ctx)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(keys), 1)
+	}
+	stats := retrying.Stats()
+	assert.Equal(t, stats.Retries, s.Rules()[0].Injected)
+	assert.True(t, stats.Recovered > 0)
+	assert.Equal(t, stats.Exhausted, uint64(0))
+}
+
+// A journal that cannot be written must not leave the change behind.
+func TestStorage_Service(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+	svc := service.New(s, true)
+	assert.Equal(t, svc.CreateSlaveDomain(ctx, "192.0.2.1", service.MustParseDNSName("fault.test.")), nil)
+	info, err := svc.GetDomainInfo(ctx, service.MustParseDNSName("fault.test."))
+	assert.Equal(t, err, nil)
+
+	s.Set(Rule{Stmt: "insert-change-query", ErrorRate: 1})
+	rr := &service.DNSResourceRecord{Qname: "www.fault.test.", Qtype: "A", Content: "192.0.2.2", TTL: 60, DomainID: info.ID, Auth: true}
+	assert.True(t, errors.Is(svc.FeedRecord(ctx, rr, ""), ErrInjected))
+
+	s.Reset()
+	rrs, err := svc.Lookup(ctx, service.TypeA, service.MustParseDNSName("www.fault.test."), info.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rrs), 0)
+}
+
+func TestRule_JSON(t *testing.T) {
+	var rule Rule
+	err := json.Unmarshal([]byte(`{"stmt":"basic-query","latency":"200ms","error_rate":0.1,"transient":true}`), &rule)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rule, Rule{Stmt: "basic-query", Latency: 200 * time.Millisecond, ErrorRate: 0.1, Transient: true})
+
+	b, err := json.Marshal(rule)
+	assert.Equal(t, err, nil)
+	assert.Contains(t, string(b), `"latency":"200ms"`)
+
+	assert.NotEqual(t, json.Unmarshal([]byte(`{"latency":"soon"}`), &rule), nil)
+}
+
+func TestConformance(t *testing.T) {
+	// without rules no fault is injected
+	storagetest.RunConformance(t, func(t *testing.T) storage.Store {
+		return catalog.New(newStorage(t))
+	})
+}
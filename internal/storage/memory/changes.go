@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type changes struct {
+	v view
+}
+
+func (c *changes) Append(ctx context.Context, list ...*storage.Change) error {
+	if len(list) == 0 {
+		return nil
+	}
+	return c.v.write(ctx, func(d *data) error {
+		for _, change := range list {
+			cc := *change
+			cc.Seq = d.nextSeq
+			if cc.DomainID < 0 {
+				cc.DomainID = -1
+			}
+			d.nextSeq++
+			d.changes = append(d.changes, &cc)
+		}
+		return nil
+	})
+}
+
+// Since returns all entries after seq for a negative limit, like SQLite.
+func (c *changes) Since(ctx context.Context, seq int64, domainID int, limit int) ([]*storage.Change, error) {
+	d, err := c.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*storage.Change, 0)
+	for _, change := range d.changes {
+		if limit >= 0 && len(list) >= limit {
+			break
+		}
+		if change.Seq <= seq || (domainID >= 0 && change.DomainID != domainID) {
+			continue
+		}
+		cc := *change
+		list = append(list, &cc)
+	}
+	return list, nil
+}
+
+func (c *changes) DeleteBefore(ctx context.Context, created int64) (int, error) {
+	return c.remove(ctx, func(change *storage.Change, i int, count int) bool {
+		return change.Time < created
+	})
+}
+
+func (c *changes) KeepLast(ctx context.Context, n int) (int, error) {
+	return c.remove(ctx, func(change *storage.Change, i int, count int) bool {
+		return i < count-n
+	})
+}
+
+// remove rebuilds the journal without the entries drop returns true for,
+// i is the position of change among the count entries ordered by seq.
+func (c *changes) remove(ctx context.Context, drop func(change *storage.Change, i int, count int) bool) (int, error) {
+	n := 0
+	err := c.v.write(ctx, func(d *data) error {
+		if len(d.changes) == 0 {
+			return nil
+		}
+		kept := make([]*storage.Change, 0, len(d.changes))
+		for i, change := range d.changes {
+			if drop(change, i, len(d.changes)) {
+				n++
+				continue
+			}
+			kept = append(kept, change)
+		}
+		d.changes = kept
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type comments struct {
+	v view
+}
+
+func (c *comments) List(ctx context.Context, domainID int) ([]*storage.Comment, error) {
+	d, err := c.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*storage.Comment, 0, len(d.comments[domainID]))
+	for _, comment := range d.comments[domainID] {
+		cc := *comment
+		list = append(list, &cc)
+	}
+	return list, nil
+}
+
+func (c *comments) Insert(ctx context.Context, comment *storage.Comment) error {
+	return c.v.write(ctx, func(d *data) error {
+		cc := *comment
+		d.comments[cc.DomainID] = append(d.comments[cc.DomainID], &cc)
+		return nil
+	})
+}
+
+func (c *comments) DeleteRRSet(ctx context.Context, domainID int, name string, qtype string) (int, error) {
+	n := 0
+	err := c.v.write(ctx, func(d *data) error {
+		kept := make([]*storage.Comment, 0, len(d.comments[domainID]))
+		for _, comment := range d.comments[domainID] {
+			if comment.Name == name && comment.Type == qtype {
+				n++
+				continue
+			}
+			kept = append(kept, comment)
+		}
+		d.comments[domainID] = kept
+		return nil
+	})
+	return n, err
+}
+
+func (c *comments) DeleteZone(ctx context.Context, domainID int) (int, error) {
+	n := 0
+	err := c.v.write(ctx, func(d *data) error {
+		n = len(d.comments[domainID])
+		delete(d.comments, domainID)
+		return nil
+	})
+	return n, err
+}
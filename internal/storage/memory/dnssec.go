@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type keys struct {
+	v view
+}
+
+func (k *keys) List(ctx context.Context, zone string) ([]*storage.Key, error) {
+	d, err := k.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*storage.Key, 0, 2)
+	for _, key := range d.keys[d.zoneID(zone)] {
+		c := *key
+		list = append(list, &c)
+	}
+	return list, nil
+}
+
+// Add returns 0 for an unknown zone, like the insert-select statement.
+func (k *keys) Add(ctx context.Context, zone string, key *storage.Key) (int, error) {
+	n := 0
+	err := k.v.write(ctx, func(d *data) error {
+		id := d.zoneID(zone)
+		if id < 0 {
+			return nil
+		}
+		c := *key
+		c.DomainID = id
+		if c.ID > 0 {
+			for _, list := range d.keys {
+				for _, other := range list {
+					if other.ID == c.ID {
+						return stacktrace.Newf("key id %d already exists", c.ID)
+					}
+				}
+			}
+		} else {
+			c.ID = d.nextKey
+		}
+		if c.ID >= d.nextKey {
+			d.nextKey = c.ID + 1
+		}
+		d.keys[id] = append(d.keys[id], &c)
+		n = 1
+		return nil
+	})
+	return n, err
+}
+
+type metadata struct {
+	v view
+}
+
+func (m *metadata) GetAll(ctx context.Context, zone string) (map[string][]string, error) {
+	d, err := m.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string][]string)
+	for kind, values := range d.metadata[d.zoneID(zone)] {
+		meta[kind] = append([]string(nil), values...)
+	}
+	return meta, nil
+}
+
+func (m *metadata) Get(ctx context.Context, zone string, kind string) ([]string, error) {
+	d, err := m.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(make([]string, 0), d.metadata[d.zoneID(zone)][kind]...), nil
+}
+
+// Set replaces all values of kind, nothing is stored for an unknown zone.
+func (m *metadata) Set(ctx context.Context, zone string, kind string, values []string) error {
+	return m.v.write(ctx, func(d *data) error {
+		id := d.zoneID(zone)
+		if id < 0 {
+			return nil
+		}
+		meta := make(map[string][]string, len(d.metadata[id])+1)
+		for k, v := range d.metadata[id] {
+			meta[k] = v
+		}
+		if len(values) == 0 {
+			delete(meta, kind)
+		} else {
+			meta[kind] = append([]string(nil), values...)
+		}
+		d.metadata[id] = meta
+		return nil
+	})
+}
+
+type tsig struct {
+	v view
+}
+
+func (t *tsig) Get(ctx context.Context, name string) (*storage.TSIGKey, error) {
+	d, err := t.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := d.tsig[name]
+	if !ok {
+		return nil, stacktrace.Newf("TSIG key %s: %w", name, storage.ErrNotFound)
+	}
+	c := *key
+	return &c, nil
+}
+
+func (t *tsig) List(ctx context.Context) ([]*storage.TSIGKey, error) {
+	d, err := t.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*storage.TSIGKey, 0, len(d.tsig))
+	for _, key := range d.tsig {
+		c := *key
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func (t *tsig) Set(ctx context.Context, key *storage.TSIGKey) error {
+	return t.v.write(ctx, func(d *data) error {
+		c := *key
+		d.tsig[key.Name] = &c
+		return nil
+	})
+}
+
+func (t *tsig) Delete(ctx context.Context, name string) (int, error) {
+	n := 0
+	err := t.v.write(ctx, func(d *data) error {
+		if _, ok := d.tsig[name]; ok {
+			delete(d.tsig, name)
+			n = 1
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,220 @@
+// Package memory keeps the whole storage in process memory. It implements
+// storage.Store with the semantics of the SQL catalogue and needs no cgo.
+//
+// Committed data is never modified: a transaction works on a shallow copy,
+// slices are only appended to or rebuilt, so readers go without locks.
+// Writers are serialized, like in SQLite.
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type Store struct {
+	mu        sync.RWMutex
+	committed *data
+	writer    chan struct{}
+	repos     *storage.Repositories
+}
+
+func New() *Store {
+	s := &Store{
+		committed: newData(),
+		writer:    make(chan struct{}, 1),
+	}
+	s.repos = repositories(view{s: s})
+	return s
+}
+
+func (s *Store) Repositories() *storage.Repositories {
+	return s.repos
+}
+
+func (s *Store) Begin(ctx context.Context) (storage.StoreTx, error) {
+	return s.begin(ctx)
+}
+
+func (s *Store) begin(ctx context.Context) (*tx, error) {
+	select {
+	case s.writer <- struct{}{}:
+	case <-ctx.Done():
+		return nil, stacktrace.Wrap(ctx.Err())
+	}
+	t := &tx{s: s, d: s.snapshot().clone()}
+	t.repos = repositories(view{s: s, tx: t})
+	return t, nil
+}
+
+func (s *Store) snapshot() *data {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.committed
+}
+
+type tx struct {
+	s     *Store
+	d     *data
+	done  bool
+	repos *storage.Repositories
+}
+
+func (t *tx) Repositories() *storage.Repositories {
+	return t.repos
+}
+
+func (t *tx) Commit() error {
+	if t.done {
+		return stacktrace.New("Transaction has already been committed or rolled back")
+	}
+	t.done = true
+	t.s.mu.Lock()
+	t.s.committed = t.d
+	t.s.mu.Unlock()
+	<-t.s.writer
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	if t.done {
+		return stacktrace.New("Transaction has already been committed or rolled back")
+	}
+	t.done = true
+	<-t.s.writer
+	return nil
+}
+
+// view binds the repositories to the committed data or to a transaction.
+type view struct {
+	s  *Store
+	tx *tx
+}
+
+func (v view) read(ctx context.Context) (*data, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	if v.tx != nil {
+		if v.tx.done {
+			return nil, stacktrace.New("Transaction has already been committed or rolled back")
+		}
+		return v.tx.d, nil
+	}
+	return v.s.snapshot(), nil
+}
+
+// write runs fn in the transaction of the view, or in a transaction of its
+// own outside of one.
+func (v view) write(ctx context.Context, fn func(d *data) error) error {
+	if v.tx != nil {
+		d, err := v.read(ctx)
+		if err != nil {
+			return err
+		}
+		return fn(d)
+	}
+	if err := ctx.Err(); err != nil {
+		return stacktrace.Wrap(err)
+	}
+	t, err := v.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err = fn(t.d); err != nil {
+		_ = t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
+
+func repositories(v view) *storage.Repositories {
+	return &storage.Repositories{
+		Zones:        &zones{v},
+		Records:      &records{v},
+		Keys:         &keys{v},
+		Metadata:     &metadata{v},
+		TSIG:         &tsig{v},
+		Comments:     &comments{v},
+		Supermasters: &supermasters{v},
+		Changes:      &changes{v},
+	}
+}
+
+type data struct {
+	zones    map[int]*storage.Zone
+	names    map[string]int
+	nextZone int
+
+	// records, keys and comments are grouped by domain id
+	records  map[int][]*storage.Record
+	keys     map[int][]*storage.Key
+	nextKey  int
+	metadata map[int]map[string][]string
+	comments map[int][]*storage.Comment
+
+	tsig         map[string]*storage.TSIGKey
+	supermasters []*storage.Supermaster
+
+	changes []*storage.Change
+	nextSeq int64
+}
+
+func newData() *data {
+	return &data{
+		zones:    make(map[int]*storage.Zone),
+		names:    make(map[string]int),
+		nextZone: 1,
+		records:  make(map[int][]*storage.Record),
+		keys:     make(map[int][]*storage.Key),
+		nextKey:  1,
+		metadata: make(map[int]map[string][]string),
+		comments: make(map[int][]*storage.Comment),
+		tsig:     make(map[string]*storage.TSIGKey),
+		nextSeq:  1,
+	}
+}
+
+func (d *data) clone() *data {
+	c := *d
+	c.zones = make(map[int]*storage.Zone, len(d.zones))
+	for k, v := range d.zones {
+		c.zones[k] = v
+	}
+	c.names = make(map[string]int, len(d.names))
+	for k, v := range d.names {
+		c.names[k] = v
+	}
+	c.records = make(map[int][]*storage.Record, len(d.records))
+	for k, v := range d.records {
+		c.records[k] = v
+	}
+	c.keys = make(map[int][]*storage.Key, len(d.keys))
+	for k, v := range d.keys {
+		c.keys[k] = v
+	}
+	c.metadata = make(map[int]map[string][]string, len(d.metadata))
+	for k, v := range d.metadata {
+		c.metadata[k] = v
+	}
+	c.comments = make(map[int][]*storage.Comment, len(d.comments))
+	for k, v := range d.comments {
+		c.comments[k] = v
+	}
+	c.tsig = make(map[string]*storage.TSIGKey, len(d.tsig))
+	for k, v := range d.tsig {
+		c.tsig[k] = v
+	}
+	return &c
+}
+
+// zoneID returns -1 for an unknown zone.
+func (d *data) zoneID(name string) int {
+	id, ok := d.names[name]
+	if !ok {
+		return -1
+	}
+	return id
+}
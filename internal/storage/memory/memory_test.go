@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const seed = `{
+  "zones": [{
+    "name": "seed.test.",
+    "records": [
+      {"type": "SOA", "content": "ns.seed.test. admin.seed.test. 1 3600 600 86400 60", "ttl": 3600},
+      {"name": "www.seed.test.", "type": "A", "content": "192.0.2.1", "ttl": 60, "ordername": "www"},
+      {"name": "a.seed.test.", "type": "A", "content": "192.0.2.2", "ttl": 60, "ordername": "a"}
+    ],
+    "metadata": {"SOA-EDIT": ["INCEPTION-INCREMENT"]}
+  }],
+  "tsig_keys": [{"name": "k.", "algorithm": "hmac-sha256", "secret": "c2VjcmV0"}],
+  "supermasters": [{"ip": "192.0.2.53", "nameserver": "NS.seed.test.", "account": "ops"}]
+}`
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	s, err := Load(strings.NewReader(seed))
+	assert.Equal(t, err, nil)
+	r := s.Repositories()
+
+	zones, err := r.Zones.List(ctx, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(zones), 1)
+	assert.Equal(t, zones[0].Kind, "NATIVE")
+	assert.Equal(t, zones[0].SOA, "ns.seed.test. admin.seed.test. 1 3600 600 86400 60")
+
+	list, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "www.seed.test.", Type: "A", DomainID: -1})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(list), 1)
+	assert.Equal(t, list[0].Auth, true)
+
+	rr, err := r.Records.OrderBefore(ctx, zones[0].ID, "b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rr.Name, "a.seed.test.")
+	rr, err = r.Records.OrderAfter(ctx, zones[0].ID, "b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rr.Name, "www.seed.test.")
+	_, err = r.Records.OrderAfter(ctx, zones[0].ID, "www")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	meta, err := r.Metadata.Get(ctx, "seed.test.", "SOA-EDIT")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, meta, []string{"INCEPTION-INCREMENT"})
+
+	account, err := r.Supermasters.Account(ctx, "192.0.2.53", "ns.seed.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, account, "ops")
+
+	_, err = Load(strings.NewReader(`{"zone": []}`))
+	assert.NotEqual(t, err, nil)
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	r := s.Repositories()
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{Name: "trx.test.", Kind: "NATIVE"}), nil)
+	id, err := r.Zones.ID(ctx, "trx.test.")
+	assert.Equal(t, err, nil)
+
+	tx, err := s.Begin(ctx)
+	assert.Equal(t, err, nil)
+	_, err = tx.Repositories().Records.Insert(ctx, &storage.Record{DomainID: id, Name: "trx.test.", Type: "A", Content: "192.0.2.1"})
+	assert.Equal(t, err, nil)
+	// readers see the committed data only
+	list, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "trx.test.", DomainID: id})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(list), 0)
+	assert.Equal(t, tx.Rollback(), nil)
+	assert.NotEqual(t, tx.Commit(), nil)
+
+	tx, err = s.Begin(ctx)
+	assert.Equal(t, err, nil)
+	_, err = tx.Repositories().Records.Insert(ctx, &storage.Record{DomainID: id, Name: "trx.test.", Type: "A", Content: "192.0.2.2"})
+	assert.Equal(t, err, nil)
+
+	// a second writer waits for the first one
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.Begin(canceled)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	assert.Equal(t, tx.Commit(), nil)
+	list, err = r.Records.Lookup(ctx, storage.LookupQuery{Name: "trx.test.", DomainID: id})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(list), 1)
+	assert.Equal(t, list[0].Content, "192.0.2.2")
+}
@@ -0,0 +1,231 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type records struct {
+	v view
+}
+
+// Lookup scans the records of the zone, or of all zones when DomainID is
+// negative. The store is meant for small deployments and has no indexes.
+func (r *records) Lookup(ctx context.Context, lq storage.LookupQuery) ([]*storage.Record, error) {
+	d, err := r.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	anyType := lq.Type == "" || strings.EqualFold(lq.Type, "ANY")
+	name := storage.NormalizeName(lq.Name)
+	match := func(rr *storage.Record) bool {
+		return !rr.Disabled && rr.Name == name && (anyType || rr.Type == lq.Type)
+	}
+	list := make([]*storage.Record, 0)
+	if lq.DomainID >= 0 {
+		return appendMatching(list, d.records[lq.DomainID], match), nil
+	}
+	for _, rrs := range d.records {
+		list = appendMatching(list, rrs, match)
+	}
+	return list, nil
+}
+
+func appendMatching(list []*storage.Record, rrs []*storage.Record, match func(rr *storage.Record) bool) []*storage.Record {
+	for _, rr := range rrs {
+		if match(rr) {
+			c := *rr
+			list = append(list, &c)
+		}
+	}
+	return list
+}
+
+func (r *records) List(ctx context.Context, domainID int, includeDisabled bool) (storage.RecordIterator, error) {
+	d, err := r.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*storage.Record, 0, len(d.records[domainID]))
+	for _, rr := range d.records[domainID] {
+		if includeDisabled || !rr.Disabled {
+			list = append(list, rr)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Type < list[j].Type
+	})
+	return &recordIterator{ctx: ctx, list: list, pos: -1}, nil
+}
+
+func (r *records) RRSet(ctx context.Context, domainID int, name string, qtype string) ([]*storage.Record, error) {
+	d, err := r.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name = storage.NormalizeName(name)
+	return appendMatching(make([]*storage.Record, 0), d.records[domainID], func(rr *storage.Record) bool {
+		return rr.Name == name && rr.Type == qtype
+	}), nil
+}
+
+func (r *records) Insert(ctx context.Context, list ...*storage.Record) (int, error) {
+	if len(list) == 0 {
+		return 0, nil
+	}
+	err := r.v.write(ctx, func(d *data) error {
+		for _, rr := range list {
+			c := rr.Normalized()
+			d.records[c.DomainID] = append(d.records[c.DomainID], c)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(list), nil
+}
+
+func (r *records) DeleteZone(ctx context.Context, domainID int) (int, error) {
+	n := 0
+	err := r.v.write(ctx, func(d *data) error {
+		n = len(d.records[domainID])
+		delete(d.records, domainID)
+		return nil
+	})
+	return n, err
+}
+
+func (r *records) DeleteRRSet(ctx context.Context, domainID int, name string, qtype string) (int, error) {
+	name = storage.NormalizeName(name)
+	n := 0
+	err := r.v.write(ctx, func(d *data) error {
+		kept := make([]*storage.Record, 0, len(d.records[domainID]))
+		for _, rr := range d.records[domainID] {
+			if rr.Name == name && rr.Type == qtype {
+				n++
+				continue
+			}
+			kept = append(kept, rr)
+		}
+		d.records[domainID] = kept
+		return nil
+	})
+	return n, err
+}
+
+// SetOrderNameAndAuth copies the records it changes, the slices and records
+// are shared with the views of open transactions.
+func (r *records) SetOrderNameAndAuth(ctx context.Context, domainID int, name string, qtype string, ordername string, auth bool) (int, error) {
+	name = storage.NormalizeName(name)
+	n := 0
+	err := r.v.write(ctx, func(d *data) error {
+		list := make([]*storage.Record, 0, len(d.records[domainID]))
+		for _, rr := range d.records[domainID] {
+			if rr.Name == name && !rr.Disabled && (qtype == "" || rr.Type == qtype) {
+				c := *rr
+				c.OrderName, c.Auth = ordername, auth
+				rr = &c
+				n++
+			}
+			list = append(list, rr)
+		}
+		d.records[domainID] = list
+		return nil
+	})
+	return n, err
+}
+
+func (r *records) InsertEmptyNonTerminal(ctx context.Context, domainID int, name string, ordername string, auth bool) error {
+	_, err := r.Insert(ctx, &storage.Record{DomainID: domainID, Name: name, OrderName: ordername, Auth: auth})
+	return err
+}
+
+func (r *records) DeleteEmptyNonTerminal(ctx context.Context, domainID int, name string) (int, error) {
+	return r.DeleteRRSet(ctx, domainID, name, "")
+}
+
+func (r *records) OrderBefore(ctx context.Context, domainID int, ordername string) (*storage.Record, error) {
+	return r.order(ctx, domainID, func(rr, best *storage.Record) bool {
+		return rr.OrderName <= ordername && (best == nil || rr.OrderName > best.OrderName)
+	})
+}
+
+func (r *records) OrderAfter(ctx context.Context, domainID int, ordername string) (*storage.Record, error) {
+	return r.order(ctx, domainID, func(rr, best *storage.Record) bool {
+		return rr.OrderName > ordername && (best == nil || rr.OrderName < best.OrderName)
+	})
+}
+
+func (r *records) OrderFirst(ctx context.Context, domainID int) (*storage.Record, error) {
+	return r.order(ctx, domainID, func(rr, best *storage.Record) bool {
+		return best == nil || rr.OrderName < best.OrderName
+	})
+}
+
+func (r *records) OrderLast(ctx context.Context, domainID int) (*storage.Record, error) {
+	return r.order(ctx, domainID, func(rr, best *storage.Record) bool {
+		return best == nil || rr.OrderName > best.OrderName
+	})
+}
+
+// order returns the enabled record with an ordername for which better holds
+// against the best one so far.
+func (r *records) order(ctx context.Context, domainID int, better func(rr, best *storage.Record) bool) (*storage.Record, error) {
+	d, err := r.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var best *storage.Record
+	for _, rr := range d.records[domainID] {
+		if rr.Disabled || rr.OrderName == "" {
+			continue
+		}
+		if better(rr, best) {
+			best = rr
+		}
+	}
+	if best == nil {
+		return nil, stacktrace.Newf("ordername in zone %d: %w", domainID, storage.ErrNotFound)
+	}
+	return &storage.Record{Name: best.Name, OrderName: best.OrderName}, nil
+}
+
+type recordIterator struct {
+	ctx  context.Context
+	list []*storage.Record
+	pos  int
+	err  error
+}
+
+func (it *recordIterator) Next() bool {
+	if it.err != nil || it.pos+1 >= len(it.list) {
+		return false
+	}
+	if it.err = it.ctx.Err(); it.err != nil {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *recordIterator) Record() *storage.Record {
+	c := *it.list[it.pos]
+	return &c
+}
+
+func (it *recordIterator) Err() error {
+	return stacktrace.Wrap(it.err)
+}
+
+func (it *recordIterator) Close() error {
+	it.list = nil
+	return nil
+}
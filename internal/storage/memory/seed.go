@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+// Seed is the JSON document a store can start from.
+type Seed struct {
+	Zones        []SeedZone        `json:"zones"`
+	TSIGKeys     []SeedTSIGKey     `json:"tsig_keys"`
+	Supermasters []SeedSupermaster `json:"supermasters"`
+}
+
+type SeedZone struct {
+	Name     string              `json:"name"`
+	Kind     string              `json:"kind"`
+	Master   string              `json:"master"`
+	Account  string              `json:"account"`
+	Records  []SeedRecord        `json:"records"`
+	Metadata map[string][]string `json:"metadata"`
+	Keys     []SeedKey           `json:"keys"`
+	Comments []SeedComment       `json:"comments"`
+}
+
+// SeedRecord without a name belongs to the apex, Auth defaults to true.
+type SeedRecord struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+	TTL       int    `json:"ttl"`
+	Prio      int    `json:"prio"`
+	Disabled  bool   `json:"disabled"`
+	OrderName string `json:"ordername"`
+	Auth      *bool  `json:"auth"`
+}
+
+type SeedKey struct {
+	Flags     int    `json:"flags"`
+	Active    bool   `json:"active"`
+	Published bool   `json:"published"`
+	Content   string `json:"content"`
+}
+
+type SeedComment struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	ModifiedAt int64  `json:"modified_at"`
+	Account    string `json:"account"`
+	Comment    string `json:"comment"`
+}
+
+type SeedTSIGKey struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret"`
+}
+
+type SeedSupermaster struct {
+	IP         string `json:"ip"`
+	Nameserver string `json:"nameserver"`
+	Account    string `json:"account"`
+}
+
+func LoadFile(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load returns a store filled from the JSON seed read from r.
+func Load(r io.Reader) (*Store, error) {
+	seed := new(Seed)
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(seed); err != nil {
+		return nil, stacktrace.Newf("Unable to decode seed: %w", err)
+	}
+	s := New()
+	ctx := context.Background()
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = seed.apply(ctx, tx.Repositories()); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return s, tx.Commit()
+}
+
+func (seed *Seed) apply(ctx context.Context, r *storage.Repositories) error {
+	for _, z := range seed.Zones {
+		kind := z.Kind
+		if kind == "" {
+			kind = "NATIVE"
+		}
+		err := r.Zones.Create(ctx, &storage.Zone{
+			Name:    z.Name,
+			Master:  z.Master,
+			Kind:    kind,
+			Account: z.Account,
+		})
+		if err != nil {
+			return err
+		}
+		id, err := r.Zones.ID(ctx, z.Name)
+		if err != nil {
+			return err
+		}
+		rrs := make([]*storage.Record, 0, len(z.Records))
+		for _, rr := range z.Records {
+			name := rr.Name
+			if name == "" {
+				name = z.Name
+			}
+			auth := true
+			if rr.Auth != nil {
+				auth = *rr.Auth
+			}
+			rrs = append(rrs, &storage.Record{
+				DomainID:  id,
+				Name:      name,
+				Type:      rr.Type,
+				Content:   rr.Content,
+				TTL:       rr.TTL,
+				Prio:      rr.Prio,
+				Disabled:  rr.Disabled,
+				OrderName: rr.OrderName,
+				Auth:      auth,
+			})
+		}
+		if _, err = r.Records.Insert(ctx, rrs...); err != nil {
+			return err
+		}
+		for kind, values := range z.Metadata {
+			if err = r.Metadata.Set(ctx, z.Name, kind, values); err != nil {
+				return err
+			}
+		}
+		for _, key := range z.Keys {
+			_, err = r.Keys.Add(ctx, z.Name, &storage.Key{
+				Flags:     key.Flags,
+				Active:    key.Active,
+				Published: key.Published,
+				Content:   key.Content,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, c := range z.Comments {
+			err = r.Comments.Insert(ctx, &storage.Comment{
+				DomainID:   id,
+				Name:       c.Name,
+				Type:       c.Type,
+				ModifiedAt: c.ModifiedAt,
+				Account:    c.Account,
+				Comment:    c.Comment,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range seed.TSIGKeys {
+		err := r.TSIG.Set(ctx, &storage.TSIGKey{
+			Name:      key.Name,
+			Algorithm: key.Algorithm,
+			Secret:    key.Secret,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, sm := range seed.Supermasters {
+		err := r.Supermasters.Add(ctx, &storage.Supermaster{
+			IP:         sm.IP,
+			Nameserver: sm.Nameserver,
+			Account:    sm.Account,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type supermasters struct {
+	v view
+}
+
+func (s *supermasters) Account(ctx context.Context, ip string, nameserver string) (string, error) {
+	d, err := s.v.read(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, sm := range d.supermasters {
+		if sm.IP == ip && strings.EqualFold(sm.Nameserver, nameserver) {
+			return sm.Account, nil
+		}
+	}
+	return "", stacktrace.Newf("supermaster %s %s: %w", ip, nameserver, storage.ErrNotFound)
+}
+
+func (s *supermasters) List(ctx context.Context) ([]*storage.Supermaster, error) {
+	d, err := s.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*storage.Supermaster, 0, len(d.supermasters))
+	for _, sm := range d.supermasters {
+		c := *sm
+		list = append(list, &c)
+	}
+	return list, nil
+}
+
+func (s *supermasters) Add(ctx context.Context, sm *storage.Supermaster) error {
+	return s.v.write(ctx, func(d *data) error {
+		for _, old := range d.supermasters {
+			if old.IP == sm.IP && strings.EqualFold(old.Nameserver, sm.Nameserver) {
+				return stacktrace.Newf("supermaster %s %s already exists", sm.IP, sm.Nameserver)
+			}
+		}
+		c := *sm
+		d.supermasters = append(d.supermasters, &c)
+		return nil
+	})
+}
+
+func (s *supermasters) Remove(ctx context.Context, ip string, nameserver string) (int, error) {
+	n := 0
+	err := s.v.write(ctx, func(d *data) error {
+		kept := make([]*storage.Supermaster, 0, len(d.supermasters))
+		for _, sm := range d.supermasters {
+			if sm.IP == ip && strings.EqualFold(sm.Nameserver, nameserver) {
+				n++
+				continue
+			}
+			kept = append(kept, sm)
+		}
+		d.supermasters = kept
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type zones struct {
+	v view
+}
+
+func (z *zones) ID(ctx context.Context, name string) (int, error) {
+	d, err := z.v.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+	id := d.zoneID(name)
+	if id < 0 {
+		return 0, stacktrace.Newf("zone %s: %w", name, storage.ErrNotFound)
+	}
+	return id, nil
+}
+
+func (z *zones) Get(ctx context.Context, name string) (*storage.Zone, error) {
+	d, err := z.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id := d.zoneID(name)
+	if id < 0 {
+		return nil, stacktrace.Newf("zone %s: %w", name, storage.ErrNotFound)
+	}
+	zone := *d.zones[id]
+	return &zone, nil
+}
+
+func (z *zones) ByID(ctx context.Context, id int) (*storage.Zone, error) {
+	d, err := z.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found, ok := d.zones[id]
+	if !ok {
+		return nil, stacktrace.Newf("zone %d: %w", id, storage.ErrNotFound)
+	}
+	zone := *found
+	return &zone, nil
+}
+
+// List returns a zone once for every apex SOA record. Like the SQL join, a
+// zone without an enabled SOA is listed only with includeDisabled.
+func (z *zones) List(ctx context.Context, includeDisabled bool) ([]*storage.Zone, error) {
+	d, err := z.v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(d.zones))
+	for id := range d.zones {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	list := make([]*storage.Zone, 0, len(ids))
+	for _, id := range ids {
+		found := false
+		for _, rr := range d.records[id] {
+			if rr.Type != "SOA" || rr.Name != d.zones[id].Name {
+				continue
+			}
+			found = true
+			if rr.Disabled && !includeDisabled {
+				continue
+			}
+			zone := *d.zones[id]
+			zone.SOA = rr.Content
+			list = append(list, &zone)
+		}
+		if !found && includeDisabled {
+			zone := *d.zones[id]
+			list = append(list, &zone)
+		}
+	}
+	return list, nil
+}
+
+func (z *zones) Create(ctx context.Context, zone *storage.Zone) error {
+	return z.v.write(ctx, func(d *data) error {
+		if d.zoneID(zone.Name) >= 0 {
+			return stacktrace.Newf("zone %s already exists", zone.Name)
+		}
+		c := &storage.Zone{
+			ID:      d.nextZone,
+			Name:    zone.Name,
+			Master:  zone.Master,
+			Kind:    zone.Kind,
+			Account: zone.Account,
+		}
+		if zone.ID > 0 {
+			if _, ok := d.zones[zone.ID]; ok {
+				return stacktrace.Newf("zone id %d already exists", zone.ID)
+			}
+			c.ID = zone.ID
+			c.LastCheck = zone.LastCheck
+			c.NotifiedSerial = zone.NotifiedSerial
+		}
+		id := c.ID
+		if id >= d.nextZone {
+			d.nextZone = id + 1
+		}
+		d.zones[id] = c
+		d.names[zone.Name] = id
+		return nil
+	})
+}
+
+func (z *zones) SetNotified(ctx context.Context, id int, serial int64) error {
+	return z.update(ctx, id, func(zone *storage.Zone) {
+		zone.NotifiedSerial = serial
+	})
+}
+
+func (z *zones) SetLastCheck(ctx context.Context, id int, lastCheck int64) error {
+	return z.update(ctx, id, func(zone *storage.Zone) {
+		zone.LastCheck = lastCheck
+	})
+}
+
+// update changes a copy of the zone, an unknown id is not an error.
+func (z *zones) update(ctx context.Context, id int, fn func(zone *storage.Zone)) error {
+	return z.v.write(ctx, func(d *data) error {
+		old, ok := d.zones[id]
+		if !ok {
+			return nil
+		}
+		zone := *old
+		fn(&zone)
+		d.zones[id] = &zone
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const redacted = "[redacted]"
+
+// plainArgs may be logged as they are, they carry no names or secrets.
+var plainArgs = map[string]bool{
+	"domain_id":        true,
+	"key_id":           true,
+	"qtype":            true,
+	"kind":             true,
+	"flags":            true,
+	"active":           true,
+	"published":        true,
+	"ttl":              true,
+	"disabled":         true,
+	"auth":             true,
+	"include_disabled": true,
+	"seq":              true,
+	"limit":            true,
+	"keep":             true,
+}
+
+// Logging logs failed statements with their arguments redacted.
+func Logging(logger *zap.Logger) Middleware {
+	return Observe(func(ctx context.Context, c *Call) {
+		if c.Err == nil {
+			return
+		}
+		logger.Error("storage statement failed", append(fields(c), zap.Error(c.Err))...)
+	})
+}
+
+// SlowLog logs statements that took longer than threshold.
+func SlowLog(logger *zap.Logger, threshold time.Duration) Middleware {
+	return Observe(func(ctx context.Context, c *Call) {
+		if c.Duration < threshold {
+			return
+		}
+		logger.Warn("slow storage statement", fields(c)...)
+	})
+}
+
+func fields(c *Call) []zap.Field {
+	fields := []zap.Field{
+		zap.String("stmt", c.Stmt),
+		zap.Duration("duration", c.Duration),
+		zap.Int("rows", c.Rows),
+	}
+	if c.Batch > 0 {
+		fields = append(fields, zap.Int("batch", c.Batch))
+	}
+	if len(c.Args) > 0 {
+		fields = append(fields, zap.Any("args", Redact(c.Args)))
+	}
+	return fields
+}
+
+// Redact turns name/value pairs into a map, keeping only the values of
+// arguments known to be harmless.
+func Redact(args []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			continue
+		}
+		if plainArgs[key] {
+			m[key] = args[i+1]
+		} else {
+			m[key] = redacted
+		}
+	}
+	return m
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of StmtStats.Buckets, the last
+// bucket counts everything slower.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+type StmtStats struct {
+	Calls   uint64        `json:"calls"`
+	Errors  uint64        `json:"errors"`
+	Rows    uint64        `json:"rows"`
+	Total   time.Duration `json:"total_ns"`
+	Max     time.Duration `json:"max_ns"`
+	Buckets []uint64      `json:"buckets"`
+}
+
+// Metrics collects per statement name latency and row counts.
+type Metrics struct {
+	mu    sync.Mutex
+	stmts map[string]*StmtStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{stmts: make(map[string]*StmtStats)}
+}
+
+func (m *Metrics) Middleware() Middleware {
+	return Observe(m.observe)
+}
+
+func (m *Metrics) observe(ctx context.Context, c *Call) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stmts[c.Stmt]
+	if !ok {
+		s = &StmtStats{Buckets: make([]uint64, len(LatencyBuckets)+1)}
+		m.stmts[c.Stmt] = s
+	}
+	s.Calls++
+	if c.Err != nil {
+		s.Errors++
+	}
+	s.Rows += uint64(c.Rows)
+	s.Total += c.Duration
+	if c.Duration > s.Max {
+		s.Max = c.Duration
+	}
+	i := 0
+	for i < len(LatencyBuckets) && c.Duration > LatencyBuckets[i] {
+		i++
+	}
+	s.Buckets[i]++
+}
+
+// Snapshot returns a copy of the statistics by statement name.
+func (m *Metrics) Snapshot() map[string]StmtStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]StmtStats, len(m.stmts))
+	for stmt, s := range m.stmts {
+		c := *s
+		c.Buckets = append([]uint64(nil), s.Buckets...)
+		snapshot[stmt] = c
+	}
+	return snapshot
+}
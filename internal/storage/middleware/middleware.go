@@ -0,0 +1,158 @@
+// Package middleware holds decorators of storage.IStorage that observe the
+// statements going through them without changing the results.
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type Middleware func(next storage.IStorage) storage.IStorage
+
+// Chain wraps stg so that the first middleware sees a call first.
+func Chain(stg storage.IStorage, mws ...Middleware) storage.IStorage {
+	for i := len(mws) - 1; i >= 0; i-- {
+		stg = mws[i](stg)
+	}
+	return stg
+}
+
+// Commit is the statement name of transaction commits.
+const Commit = "commit"
+
+// Call is one finished statement. Duration of a query includes reading its
+// rows, but not the time the caller spends between them.
+type Call struct {
+	Stmt string
+	// Args are the name/value pairs, nil for batches
+	Args []interface{}
+	// Batch is the number of elements of a batch
+	Batch int
+	// Rows counts rows read by a query, or affected by an exec
+	Rows     int
+	Duration time.Duration
+	Err      error
+}
+
+// Observe returns a middleware calling fn after every statement.
+func Observe(fn func(ctx context.Context, c *Call)) Middleware {
+	return func(next storage.IStorage) storage.IStorage {
+		return &observed{IStorage: next, fn: fn}
+	}
+}
+
+type observed struct {
+	storage.IStorage
+	fn func(ctx context.Context, c *Call)
+}
+
+func (o *observed) Query(stmt string, args ...interface{}) (storage.IResult, error) {
+	return o.QueryContext(context.Background(), stmt, args...)
+}
+
+func (o *observed) Exec(stmt string, args ...interface{}) (int, error) {
+	return o.ExecContext(context.Background(), stmt, args...)
+}
+
+func (o *observed) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	return query(ctx, o.IStorage, o.fn, stmt, args...)
+}
+
+func (o *observed) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	return exec(ctx, o.IStorage, o.fn, stmt, args...)
+}
+
+func (o *observed) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	return execBatch(ctx, o.IStorage, o.fn, stmt, batch)
+}
+
+func (o *observed) Begin(ctx context.Context) (storage.ITx, error) {
+	t, err := o.IStorage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &observedTx{ITx: t, ctx: ctx, fn: o.fn}, nil
+}
+
+type observedTx struct {
+	storage.ITx
+	ctx context.Context
+	fn  func(ctx context.Context, c *Call)
+}
+
+func (t *observedTx) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	return query(ctx, t.ITx, t.fn, stmt, args...)
+}
+
+func (t *observedTx) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	return exec(ctx, t.ITx, t.fn, stmt, args...)
+}
+
+func (t *observedTx) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	return execBatch(ctx, t.ITx, t.fn, stmt, batch)
+}
+
+func (t *observedTx) Commit() error {
+	start := time.Now()
+	err := t.ITx.Commit()
+	t.fn(t.ctx, &Call{Stmt: Commit, Duration: time.Since(start), Err: err})
+	return err
+}
+
+func query(ctx context.Context, q storage.IQuerier, fn func(ctx context.Context, c *Call), stmt string, args ...interface{}) (storage.IResult, error) {
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, stmt, args...)
+	c := &Call{Stmt: stmt, Args: args, Duration: time.Since(start)}
+	if err != nil {
+		c.Err = err
+		fn(ctx, c)
+		return nil, err
+	}
+	return &observedResult{IResult: rows, ctx: ctx, fn: fn, call: c}, nil
+}
+
+func exec(ctx context.Context, q storage.IQuerier, fn func(ctx context.Context, c *Call), stmt string, args ...interface{}) (int, error) {
+	start := time.Now()
+	n, err := q.ExecContext(ctx, stmt, args...)
+	fn(ctx, &Call{Stmt: stmt, Args: args, Rows: n, Duration: time.Since(start), Err: err})
+	return n, err
+}
+
+func execBatch(ctx context.Context, q storage.IQuerier, fn func(ctx context.Context, c *Call), stmt string, batch [][]interface{}) (int, error) {
+	start := time.Now()
+	n, err := q.ExecBatchContext(ctx, stmt, batch)
+	fn(ctx, &Call{Stmt: stmt, Batch: len(batch), Rows: n, Duration: time.Since(start), Err: err})
+	return n, err
+}
+
+// observedResult reports the query when it is closed.
+type observedResult struct {
+	storage.IResult
+	ctx  context.Context
+	fn   func(ctx context.Context, c *Call)
+	call *Call
+	once sync.Once
+}
+
+func (r *observedResult) Next() bool {
+	start := time.Now()
+	ok := r.IResult.Next()
+	r.call.Duration += time.Since(start)
+	if ok {
+		r.call.Rows++
+	}
+	return ok
+}
+
+func (r *observedResult) Close() error {
+	queryErr := r.IResult.Err()
+	err := r.IResult.Close()
+	r.once.Do(func() {
+		r.call.Err = queryErr
+		r.fn(r.ctx, r.call)
+	})
+	return err
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/fault"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/ivan-bokov/go-pdns/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newStorage(t *testing.T, mws ...Middleware) storage.IStorage {
+	db := sqlite.New(":memory:")
+	t.Cleanup(db.Close)
+	assert.Equal(t, db.CreateTable(), nil)
+	return Chain(db, mws...)
+}
+
+func TestChain(t *testing.T) {
+	order := make([]string, 0)
+	mark := func(name string) Middleware {
+		return Observe(func(ctx context.Context, c *Call) {
+			order = append(order, name)
+		})
+	}
+	stg := newStorage(t, mark("outer"), mark("inner"))
+	_, err := stg.ExecContext(context.Background(), "delete-tsig-key-query", "key_name", "k.")
+	assert.Equal(t, err, nil)
+	// the inner middleware sees the result first
+	assert.Equal(t, order, []string{"inner", "outer"})
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	m := NewMetrics()
+	store := catalog.New(newStorage(t, m.Middleware()))
+	r := store.Repositories()
+
+	for _, name := range []string{"a.", "b.", "c."} {
+		assert.Equal(t, r.TSIG.Set(ctx, &storage.TSIGKey{Name: name, Algorithm: "hmac-sha256", Secret: "c2VjcmV0"}), nil)
+	}
+	keys, err := r.TSIG.List(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(keys), 3)
+	_, err = r.Records.Lookup(ctx, storage.LookupQuery{Name: "x.", Type: "A", DomainID: 1})
+	assert.Equal(t, err, nil)
+
+	tx, err := store.Begin(ctx)
+	assert.Equal(t, err, nil)
+	_, err = tx.Repositories().TSIG.Delete(ctx, "a.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tx.Commit(), nil)
+
+	stats := m.Snapshot()
+	assert.Equal(t, stats["set-tsig-key-query"].Calls, uint64(3))
+	assert.Equal(t, stats["set-tsig-key-query"].Rows, uint64(3))
+	assert.Equal(t, stats["get-tsig-keys-query"].Rows, uint64(3))
+	assert.Equal(t, stats["id-query"].Calls, uint64(1))
+	assert.Equal(t, stats[Commit].Calls, uint64(1))
+	var bucketed uint64
+	for _, n := range stats["set-tsig-key-query"].Buckets {
+		bucketed += n
+	}
+	assert.Equal(t, bucketed, uint64(3))
+	assert.True(t, stats["get-tsig-keys-query"].Max > 0)
+}
+
+func TestLogging(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.DebugLevel)
+	stg := newStorage(t, Logging(zap.New(core)))
+
+	_, err := stg.ExecContext(ctx, "set-tsig-key-query", "key_name", "k.", "algorithm", "hmac-sha256", "content", "c2VjcmV0")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, logs.Len(), 0)
+
+	_, err = stg.ExecContext(ctx, "no-such-query", "content", "c2VjcmV0", "domain_id", 7)
+	assert.NotEqual(t, err, nil)
+	entries := logs.All()
+	assert.Equal(t, len(entries), 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, fields["stmt"], "no-such-query")
+	assert.Equal(t, fields["args"], map[string]interface{}{"content": redacted, "domain_id": 7})
+}
+
+func TestSlowLog(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.DebugLevel)
+	slow := fault.New(newStorage(t))
+	slow.Set(fault.Rule{Stmt: "get-tsig-keys-query", Latency: 50 * time.Millisecond})
+	stg := Chain(slow, SlowLog(zap.New(core), 20*time.Millisecond))
+
+	rows, err := stg.QueryContext(ctx, "get-tsig-keys-query")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rows.Close(), nil)
+	_, err = stg.ExecContext(ctx, "delete-tsig-key-query", "key_name", "secret.name.")
+	assert.Equal(t, err, nil)
+
+	entries := logs.All()
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].ContextMap()["stmt"], "get-tsig-keys-query")
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Store {
+		nop := zap.NewNop()
+		return catalog.New(newStorage(t, NewMetrics().Middleware(), Logging(nop), SlowLog(nop, time.Hour)))
+	})
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Zone struct {
+	ID             int
+	Name           string
+	Master         string
+	LastCheck      int64
+	Kind           string
+	NotifiedSerial int64
+	Account        string
+	// SOA is the content of the apex SOA record, filled by Zones.List only
+	SOA string
+}
+
+// Record names are stored and matched normalized, DisplayName keeps the
+// case a name was written with when it differs.
+type Record struct {
+	DomainID    int
+	Name        string
+	DisplayName string
+	Type        string
+	Content     string
+	TTL         int
+	Prio        int
+	Disabled    bool
+	OrderName   string
+	Auth        bool
+}
+
+// NormalizeName lowercases the ASCII letters of name, DNS names compare
+// case-insensitively in ASCII only.
+func NormalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, name)
+}
+
+// Normalized returns a copy of rr with a normalized Name, the case of the
+// name is kept in DisplayName unless it was lowercase already.
+func (rr *Record) Normalized() *Record {
+	c := *rr
+	if c.DisplayName == "" {
+		c.DisplayName = c.Name
+	}
+	c.Name = NormalizeName(c.Name)
+	if c.DisplayName == c.Name {
+		c.DisplayName = ""
+	}
+	return &c
+}
+
+// LookupQuery matches records by name in any case, of Type unless it is
+// empty or ANY, in DomainID unless it is negative.
+type LookupQuery struct {
+	Name     string
+	Type     string
+	DomainID int
+}
+
+type Key struct {
+	ID        int
+	DomainID  int
+	Flags     int
+	Active    bool
+	Published bool
+	Content   string
+}
+
+type TSIGKey struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+type Comment struct {
+	DomainID   int
+	Name       string
+	Type       string
+	ModifiedAt int64
+	Account    string
+	Comment    string
+}
+
+type Supermaster struct {
+	IP         string
+	Nameserver string
+	Account    string
+}
+
+// Change is an entry of the append-only journal. Seq grows monotonically
+// and is never reused, DomainID is negative for changes outside zones.
+type Change struct {
+	Seq       int64           `json:"seq"`
+	DomainID  int             `json:"domain_id"`
+	Operation string          `json:"operation"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Actor     string          `json:"actor,omitempty"`
+	Time      int64           `json:"time"`
+}
+
+// RecordIterator reads records one by one and must be closed.
+type RecordIterator interface {
+	Next() bool
+	Record() *Record
+	Err() error
+	Close() error
+}
+
+type Zones interface {
+	ID(ctx context.Context, name string) (int, error)
+	Get(ctx context.Context, name string) (*Zone, error)
+	ByID(ctx context.Context, id int) (*Zone, error)
+	List(ctx context.Context, includeDisabled bool) ([]*Zone, error)
+	// Create keeps ID, LastCheck and NotifiedSerial of the zone when ID is
+	// set, otherwise the storage picks the id.
+	Create(ctx context.Context, zone *Zone) error
+	SetNotified(ctx context.Context, id int, serial int64) error
+	SetLastCheck(ctx context.Context, id int, lastCheck int64) error
+}
+
+type Records interface {
+	Lookup(ctx context.Context, q LookupQuery) ([]*Record, error)
+	List(ctx context.Context, domainID int, includeDisabled bool) (RecordIterator, error)
+	// RRSet returns the records of name and qtype in the zone, the disabled
+	// ones too, with their ordername.
+	RRSet(ctx context.Context, domainID int, name string, qtype string) ([]*Record, error)
+	Insert(ctx context.Context, records ...*Record) (int, error)
+	DeleteZone(ctx context.Context, domainID int) (int, error)
+	DeleteRRSet(ctx context.Context, domainID int, name string, qtype string) (int, error)
+	// SetOrderNameAndAuth updates the enabled records of name, of qtype
+	// unless it is empty. An empty ordername is stored as none.
+	SetOrderNameAndAuth(ctx context.Context, domainID int, name string, qtype string, ordername string, auth bool) (int, error)
+	InsertEmptyNonTerminal(ctx context.Context, domainID int, name string, ordername string, auth bool) error
+	DeleteEmptyNonTerminal(ctx context.Context, domainID int, name string) (int, error)
+	// OrderBefore returns the enabled record with the greatest ordername not
+	// after ordername, OrderAfter the one with the least ordername after it.
+	// Only Name and OrderName are filled, ErrNotFound past the zone ends.
+	// An empty ordername is stored as none and never matched.
+	OrderBefore(ctx context.Context, domainID int, ordername string) (*Record, error)
+	OrderAfter(ctx context.Context, domainID int, ordername string) (*Record, error)
+	OrderFirst(ctx context.Context, domainID int) (*Record, error)
+	OrderLast(ctx context.Context, domainID int) (*Record, error)
+}
+
+type Keys interface {
+	List(ctx context.Context, zone string) ([]*Key, error)
+	// Add keeps key.ID when it is set.
+	Add(ctx context.Context, zone string, key *Key) (int, error)
+}
+
+type Metadata interface {
+	GetAll(ctx context.Context, zone string) (map[string][]string, error)
+	Get(ctx context.Context, zone string, kind string) ([]string, error)
+	Set(ctx context.Context, zone string, kind string, values []string) error
+}
+
+type TSIG interface {
+	Get(ctx context.Context, name string) (*TSIGKey, error)
+	List(ctx context.Context) ([]*TSIGKey, error)
+	Set(ctx context.Context, key *TSIGKey) error
+	Delete(ctx context.Context, name string) (int, error)
+}
+
+type Comments interface {
+	List(ctx context.Context, domainID int) ([]*Comment, error)
+	Insert(ctx context.Context, comment *Comment) error
+	DeleteRRSet(ctx context.Context, domainID int, name string, qtype string) (int, error)
+	DeleteZone(ctx context.Context, domainID int) (int, error)
+}
+
+// Supermasters match nameservers case-insensitively.
+type Supermasters interface {
+	Account(ctx context.Context, ip string, nameserver string) (string, error)
+	List(ctx context.Context) ([]*Supermaster, error)
+	Add(ctx context.Context, sm *Supermaster) error
+	Remove(ctx context.Context, ip string, nameserver string) (int, error)
+}
+
+type Changes interface {
+	Append(ctx context.Context, changes ...*Change) error
+	// Since returns up to limit entries after seq, of one zone or of all
+	// zones when domainID is negative.
+	Since(ctx context.Context, seq int64, domainID int, limit int) ([]*Change, error)
+	DeleteBefore(ctx context.Context, created int64) (int, error)
+	KeepLast(ctx context.Context, n int) (int, error)
+}
+
+type Repositories struct {
+	Zones        Zones
+	Records      Records
+	Keys         Keys
+	Metadata     Metadata
+	TSIG         TSIG
+	Comments     Comments
+	Supermasters Supermasters
+	Changes      Changes
+}
+
+// Store gives the repositories of a storage, outside or inside a
+// transaction. A backend that is not SQL implements it directly.
+type Store interface {
+	Repositories() *Repositories
+	Begin(ctx context.Context) (StoreTx, error)
+}
+
+type StoreTx interface {
+	Repositories() *Repositories
+	Commit() error
+	Rollback() error
+}
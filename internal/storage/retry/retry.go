@@ -0,0 +1,155 @@
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    500 * time.Millisecond,
+	}
+}
+
+type Stats struct {
+	// Retries counts repeated calls after a transient error
+	Retries uint64 `json:"retries"`
+	// Recovered counts calls that succeeded after at least one retry
+	Recovered uint64 `json:"recovered"`
+	// Exhausted counts calls that still failed with a transient error
+	Exhausted uint64 `json:"exhausted"`
+}
+
+// Storage repeats calls failed with a transient error with jittered
+// exponential backoff, as long as the next attempt fits into the context
+// deadline. Statements inside a transaction are not repeated, the whole
+// transaction is the unit to retry with Run, only Begin is.
+type Storage struct {
+	stats Stats
+	storage.IStorage
+	policy Policy
+}
+
+func New(stg storage.IStorage, policy Policy) *Storage {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &Storage{
+		IStorage: stg,
+		policy:   policy,
+	}
+}
+
+func (s *Storage) Stats() Stats {
+	return Stats{
+		Retries:   atomic.LoadUint64(&s.stats.Retries),
+		Recovered: atomic.LoadUint64(&s.stats.Recovered),
+		Exhausted: atomic.LoadUint64(&s.stats.Exhausted),
+	}
+}
+
+func (s *Storage) Query(stmt string, args ...interface{}) (storage.IResult, error) {
+	return s.QueryContext(context.Background(), stmt, args...)
+}
+
+func (s *Storage) Exec(stmt string, args ...interface{}) (int, error) {
+	return s.ExecContext(context.Background(), stmt, args...)
+}
+
+func (s *Storage) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	var rows storage.IResult
+	err := s.do(ctx, func() error {
+		var err error
+		rows, err = s.IStorage.QueryContext(ctx, stmt, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (s *Storage) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	var n int
+	err := s.do(ctx, func() error {
+		var err error
+		n, err = s.IStorage.ExecContext(ctx, stmt, args...)
+		return err
+	})
+	return n, err
+}
+
+func (s *Storage) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	var n int
+	err := s.do(ctx, func() error {
+		var err error
+		n, err = s.IStorage.ExecBatchContext(ctx, stmt, batch)
+		return err
+	})
+	return n, err
+}
+
+func (s *Storage) Begin(ctx context.Context) (storage.ITx, error) {
+	var tx storage.ITx
+	err := s.do(ctx, func() error {
+		var err error
+		tx, err = s.IStorage.Begin(ctx)
+		return err
+	})
+	return tx, err
+}
+
+// Run calls fn, a unit of work such as a whole transaction, again while it
+// fails with a transient error, like the calls of the storage.
+func (s *Storage) Run(ctx context.Context, fn func() error) error {
+	return s.do(ctx, fn)
+}
+
+func (s *Storage) do(ctx context.Context, fn func() error) error {
+	err := fn()
+	for attempt := 1; err != nil && storage.IsTransient(err); attempt++ {
+		if attempt >= s.policy.MaxAttempts {
+			atomic.AddUint64(&s.stats.Exhausted, 1)
+			return err
+		}
+		delay := s.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			atomic.AddUint64(&s.stats.Exhausted, 1)
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			atomic.AddUint64(&s.stats.Exhausted, 1)
+			return err
+		case <-timer.C:
+		}
+		atomic.AddUint64(&s.stats.Retries, 1)
+		if err = fn(); err == nil {
+			atomic.AddUint64(&s.stats.Recovered, 1)
+		}
+	}
+	return err
+}
+
+// backoff is "full jitter", uniform in [0, min(MaxDelay, BaseDelay*2^(attempt-1))).
+func (s *Storage) backoff(attempt int) time.Duration {
+	d := s.policy.BaseDelay << uint(attempt-1)
+	if d <= 0 || (s.policy.MaxDelay > 0 && d > s.policy.MaxDelay) {
+		d = s.policy.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/sqlite"
+	"github.com/ivan-bokov/go-pdns/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+)
+
+type flaky struct {
+	storage.IStorage
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flaky) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return 0, f.err
+	}
+	return 1, nil
+}
+
+func TestStorage_RetriesTransient(t *testing.T) {
+	f := &flaky{failures: 2, err: storage.Transient(errors.New("database is locked"))}
+	s := New(f, Policy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+	n, err := s.ExecContext(context.Background(), "update-serial-query")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	assert.Equal(t, f.calls, 3)
+	assert.Equal(t, s.Stats(), Stats{Retries: 2, Recovered: 1})
+}
+
+func TestStorage_PermanentNotRetried(t *testing.T) {
+	f := &flaky{failures: 2, err: errors.New("no such table")}
+	s := New(f, DefaultPolicy())
+	_, err := s.ExecContext(context.Background(), "update-serial-query")
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, f.calls, 1)
+	assert.Equal(t, s.Stats(), Stats{})
+}
+
+func TestStorage_Exhausted(t *testing.T) {
+	f := &flaky{failures: 10, err: storage.Transient(errors.New("database is locked"))}
+	s := New(f, Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	_, err := s.ExecContext(context.Background(), "update-serial-query")
+	assert.True(t, storage.IsTransient(err))
+	assert.Equal(t, f.calls, 3)
+	assert.Equal(t, s.Stats(), Stats{Retries: 2, Exhausted: 1})
+}
+
+func TestStorage_Deadline(t *testing.T) {
+	f := &flaky{failures: 10, err: storage.Transient(errors.New("database is locked"))}
+	s := New(f, Policy{MaxAttempts: 100, BaseDelay: 50 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.ExecContext(ctx, "update-serial-query")
+	assert.NotEqual(t, err, nil)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestStorage_SqliteLockContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sql.db")
+	db := sqlite.New(path, sqlite.WithBusyTimeout(0))
+	defer db.Close()
+	assert.Equal(t, db.CreateTable(), nil)
+
+	// another process holds the write lock for a moment
+	other, err := sql.Open("sqlite3", "file:"+path+"?_txlock=immediate")
+	assert.Equal(t, err, nil)
+	defer other.Close()
+	lock, err := other.Begin()
+	assert.Equal(t, err, nil)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = lock.Commit()
+	}()
+
+	_, err = db.Exec("insert-zone-query", "type", "MASTER", "domain", "busy.test.")
+	assert.True(t, storage.IsTransient(err), "%v", err)
+
+	s := New(db, Policy{MaxAttempts: 20, BaseDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond})
+	lock, err = other.Begin()
+	assert.Equal(t, err, nil)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = lock.Commit()
+	}()
+	_, err = s.Exec("insert-zone-query", "type", "MASTER", "domain", "busy.test.")
+	assert.Equal(t, err, nil)
+	assert.Greater(t, s.Stats().Retries, uint64(0))
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Store {
+		db := sqlite.New(":memory:")
+		t.Cleanup(db.Close)
+		if err := db.CreateTable(); err != nil {
+			t.Fatal(err)
+		}
+		return catalog.New(New(db, DefaultPolicy()))
+	})
+}
@@ -8,10 +8,11 @@ func ArgToMap(args ...interface{}) (map[string]interface{}, error) {
 	}
 	result := make(map[string]interface{})
 	for i := 0; i < len(args); i += 2 {
-		switch v := args[i].(type) {
-		case string:
-			result[v] = args[i+1]
+		key, ok := args[i].(string)
+		if !ok {
+			return nil, stacktrace.Newf("Argument %d: key must be a string, got %T", i, args[i])
 		}
+		result[key] = args[i+1]
 	}
 	return result, nil
 }
@@ -0,0 +1,52 @@
+package sql
+
+import (
+	"strings"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// ExpandValues repeats the VALUES tuple of a named insert query rows times,
+// so one statement inserts several rows.
+func ExpandValues(queryString string, rows int) (string, error) {
+	if rows < 1 {
+		return "", stacktrace.New("rows must be positive")
+	}
+	pos := strings.LastIndex(strings.ToLower(queryString), "values")
+	if pos == -1 {
+		return "", stacktrace.New("query has no VALUES clause: " + queryString)
+	}
+	start := strings.IndexRune(queryString[pos:], '(')
+	if start == -1 {
+		return "", stacktrace.New("query has no VALUES tuple: " + queryString)
+	}
+	start += pos
+	depth := 0
+	end := -1
+	for i := start; i < len(queryString) && end == -1; i++ {
+		switch queryString[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				end = i + 1
+			}
+		}
+	}
+	if end == -1 {
+		return "", stacktrace.New("unbalanced VALUES tuple: " + queryString)
+	}
+	tuple := queryString[start:end]
+	var b strings.Builder
+	b.Grow(len(queryString) + (len(tuple)+1)*(rows-1))
+	b.WriteString(queryString[:start])
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteRune(',')
+		}
+		b.WriteString(tuple)
+	}
+	b.WriteString(queryString[end:])
+	return b.String(), nil
+}
@@ -26,3 +26,28 @@ func TestCompileNamedQuery(t *testing.T) {
 		assert.Equal(t, name, actualNames[i], fmt.Sprintf("expected %dth name to be %s, got %s", i+1, actualNames[i], name))
 	}
 }
+
+func TestExpandValues(t *testing.T) {
+	qr, err := ExpandValues(`INSERT INTO foo (a,b) VALUES (:a, lower(:b))`, 3)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, qr, `INSERT INTO foo (a,b) VALUES (:a, lower(:b)),(:a, lower(:b)),(:a, lower(:b))`)
+	qr, names, err := CompileNamedQuery(qr, DOLLAR)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, qr, `INSERT INTO foo (a,b) VALUES ($1, lower($2)),($3, lower($4)),($5, lower($6))`)
+	assert.Equal(t, len(names), 6)
+
+	_, err = ExpandValues(`DELETE FROM foo`, 2)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestArgToMap(t *testing.T) {
+	m, err := ArgToMap("name", "a.test.", "ttl", 60)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, m, map[string]interface{}{"name": "a.test.", "ttl": 60})
+
+	_, err = ArgToMap("name")
+	assert.NotEqual(t, err, nil)
+
+	_, err = ArgToMap("name", "a.test.", 1, 60)
+	assert.NotEqual(t, err, nil)
+}
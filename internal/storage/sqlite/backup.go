@@ -0,0 +1,169 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+const (
+	snapshotPrefix  = "go-pdns-"
+	snapshotLayout  = "20060102T150405.000000000Z"
+	checksumSuffix  = ".sha256"
+	backupStepPages = 256
+)
+
+// Backup writes a consistent snapshot of the database into dir using the
+// SQLite online backup API, so it runs while the database serves traffic.
+// A sha256sum compatible checksum file is written next to the snapshot.
+// Snapshots are named after their creation time in nanoseconds, Backup
+// fails rather than replace an existing one.
+func (db *Sqlite) Backup(ctx context.Context, dir string) (*storage.Snapshot, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	created := time.Now().UTC()
+	path := filepath.Join(dir, snapshotPrefix+created.Format(snapshotLayout)+".db")
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if err = f.Close(); err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	if err = db.backupTo(ctx, tmp); err != nil {
+		return nil, err
+	}
+	// a link, unlike a rename, never replaces the target
+	if err = os.Link(tmp, path); err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	sum, size, err := checksum(path)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	if err = os.WriteFile(path+checksumSuffix, []byte(line), 0o640); err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	return &storage.Snapshot{
+		Path:     path,
+		Checksum: sum,
+		Size:     size,
+		Created:  created,
+	}, nil
+}
+
+// Restore replaces the database file at dataSource with a snapshot written
+// by Backup, after checking its checksum, integrity and schema version.
+// Nothing may have the database open while it runs.
+func Restore(snapshot string, dataSource string) error {
+	if err := verifyChecksum(snapshot); err != nil {
+		return err
+	}
+	if err := verifySnapshot(snapshot); err != nil {
+		return err
+	}
+	tmp := dataSource + ".restore"
+	if err := copyFile(snapshot, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// a WAL left from the replaced database would be replayed into the snapshot
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dataSource + suffix); err != nil && !os.IsNotExist(err) {
+			_ = os.Remove(tmp)
+			return stacktrace.Wrap(err)
+		}
+	}
+	return stacktrace.Wrap(os.Rename(tmp, dataSource))
+}
+
+func verifySnapshot(path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	defer db.Close()
+	var result string
+	if err = db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return stacktrace.Wrap(err)
+	}
+	if result != "ok" {
+		return stacktrace.Newf("snapshot %s is damaged: %s", path, result)
+	}
+	var version int
+	if err = db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return stacktrace.Wrap(err)
+	}
+	if version < 1 || version > SchemaVersion {
+		return stacktrace.Newf("snapshot %s has schema version %d, supported 1..%d", path, version, SchemaVersion)
+	}
+	return nil
+}
+
+func verifyChecksum(path string) error {
+	line, err := os.ReadFile(path + checksumSuffix)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return stacktrace.Newf("empty checksum file for %s", path)
+	}
+	sum, _, err := checksum(path)
+	if err != nil {
+		return err
+	}
+	if sum != fields[0] {
+		return stacktrace.Newf("checksum mismatch for %s", path)
+	}
+	return nil
+}
+
+func checksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, stacktrace.Wrap(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, stacktrace.Wrap(err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return stacktrace.Wrap(err)
+	}
+	if err = out.Sync(); err != nil {
+		_ = out.Close()
+		return stacktrace.Wrap(err)
+	}
+	return stacktrace.Wrap(out.Close())
+}
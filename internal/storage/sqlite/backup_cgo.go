@@ -0,0 +1,65 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupTo copies the database into path with the online backup API.
+func (db *Sqlite) backupTo(ctx context.Context, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	defer dest.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	defer destConn.Close()
+	srcConn, err := db.reader.Conn(ctx)
+	if err != nil {
+		return stacktrace.Wrap(err)
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSqlite, ok := destDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return stacktrace.New("backup destination is not a SQLite connection")
+			}
+			srcSqlite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return stacktrace.New("backup source is not a SQLite connection")
+			}
+			backup, err := destSqlite.Backup("main", srcSqlite, "main")
+			if err != nil {
+				return stacktrace.Wrap(err)
+			}
+			for {
+				done, err := backup.Step(backupStepPages)
+				if err != nil {
+					_ = backup.Finish()
+					return stacktrace.Wrap(err)
+				}
+				if done {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					_ = backup.Finish()
+					return stacktrace.Wrap(ctx.Err())
+				default:
+				}
+			}
+			return stacktrace.Wrap(backup.Finish())
+		})
+	})
+}
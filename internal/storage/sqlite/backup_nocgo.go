@@ -0,0 +1,15 @@
+//go:build !cgo
+// +build !cgo
+
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// backupTo needs the online backup API of the cgo SQLite driver.
+func (db *Sqlite) backupTo(ctx context.Context, path string) error {
+	return stacktrace.New("backup needs cgo")
+}
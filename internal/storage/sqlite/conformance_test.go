@@ -0,0 +1,20 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/ivan-bokov/go-pdns/internal/storage/catalog"
+	"github.com/ivan-bokov/go-pdns/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Store {
+		db := New(":memory:")
+		t.Cleanup(db.Close)
+		if err := db.CreateTable(); err != nil {
+			t.Fatal(err)
+		}
+		return catalog.New(db)
+	})
+}
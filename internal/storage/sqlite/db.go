@@ -1,7 +1,13 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
 	"github.com/ivan-bokov/go-pdns/internal/storage"
@@ -10,38 +16,155 @@ import (
 )
 
 type Sqlite struct {
-	db       *sql.DB
-	bindType int
-	declare  map[string]string
+	querier
+	writer *sql.DB
+	reader *sql.DB
+	stmts  *statements
+	queue  *queue
+
+	busyTimeout time.Duration
+	readers     int
+	window      time.Duration
+	maxGroup    int
 }
 
-func New(dataSource string) *Sqlite {
-	db, err := sql.Open("sqlite3", dataSource) //":memory:"
+type Option func(db *Sqlite)
+
+// WithBatchSize sets how many rows a multi-row insert carries at most.
+func WithBatchSize(n int) Option {
+	return func(db *Sqlite) {
+		db.stmts.batchSize = n
+	}
+}
+
+// WithBusyTimeout sets how long a connection waits for a lock held by
+// another connection before failing with SQLITE_BUSY.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(db *Sqlite) {
+		db.busyTimeout = d
+	}
+}
+
+// WithReaders sets the size of the read-only connection pool.
+func WithReaders(n int) Option {
+	return func(db *Sqlite) {
+		db.readers = n
+	}
+}
+
+// WithGroupCommit sets how long the writer queue waits for more writes
+// after the first one and how many writes one transaction groups at most.
+// maxGroup below 1 turns the queue off, writes then contend for the writer
+// connection.
+func WithGroupCommit(window time.Duration, maxGroup int) Option {
+	return func(db *Sqlite) {
+		db.window = window
+		db.maxGroup = maxGroup
+	}
+}
+
+// New opens the database in WAL mode with a single-connection writer pool
+// for Exec and transactions and a read-only pool for Query. An in-memory
+// database exists per connection, so it is served by the writer pool alone.
+// Writes and transactions go through a queue that commits them in groups.
+func New(dataSource string, opts ...Option) *Sqlite {
+	s := &Sqlite{
+		stmts:       newStatements(declareSQL(), sqlex.BindType("sqlite3")),
+		busyTimeout: 5 * time.Second,
+		readers:     runtime.NumCPU(),
+		maxGroup:    128,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	var err error
+	s.writer, err = sql.Open("sqlite3", s.dsn(dataSource, false))
 	if err != nil {
 		panic(stacktrace.Wrap(err))
 	}
-	return &Sqlite{
-		db:       db,
-		bindType: sqlex.BindType("sqlite3"),
-		declare:  declareSQL(),
+	s.writer.SetMaxOpenConns(1)
+	if isMemory(dataSource) {
+		s.reader = s.writer
+	} else {
+		// creates the file and switches it to WAL before readers attach
+		if err = s.writer.Ping(); err != nil {
+			panic(stacktrace.Wrap(err))
+		}
+		s.reader, err = sql.Open("sqlite3", s.dsn(dataSource, true))
+		if err != nil {
+			panic(stacktrace.Wrap(err))
+		}
+		s.reader.SetMaxOpenConns(s.readers)
+	}
+	s.querier = querier{conn: &pools{reader: s.reader, writer: s.writer}, stmts: s.stmts}
+	if s.maxGroup > 0 {
+		s.queue = newQueue(s.writer, s.stmts, s.window, s.maxGroup)
+	}
+	return s
+}
+
+func (db *Sqlite) dsn(dataSource string, readOnly bool) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(db.busyTimeout.Milliseconds(), 10))
+	if readOnly {
+		params.Set("mode", "ro")
+		params.Set("_query_only", "1")
+	} else if !isMemory(dataSource) {
+		params.Set("_journal_mode", "WAL")
+		params.Set("_synchronous", "NORMAL")
+		params.Set("_txlock", "immediate")
+	}
+	if isMemory(dataSource) {
+		return dataSource + sep(dataSource) + params.Encode()
 	}
+	if !strings.HasPrefix(dataSource, "file:") {
+		dataSource = "file:" + dataSource
+	}
+	return dataSource + sep(dataSource) + params.Encode()
+}
+
+func sep(dataSource string) string {
+	if strings.Contains(dataSource, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+func isMemory(dataSource string) bool {
+	return dataSource == ":memory:" || strings.Contains(dataSource, "mode=memory")
+}
+
+// pools routes reads to the reader pool and writes to the writer pool.
+type pools struct {
+	reader *sql.DB
+	writer *sql.DB
+}
+
+func (p *pools) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.reader.QueryContext(ctx, query, args...)
+}
+
+func (p *pools) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.writer.ExecContext(ctx, query, args...)
 }
 
 func declareSQL() map[string]string {
 	dec := make(map[string]string)
-	record_query := "SELECT content,ttl,prio,type,domain_id,disabled,name,auth FROM records WHERE"
+	record_query := "SELECT content,ttl,prio,type,domain_id,disabled,name,auth,display_name FROM records WHERE"
 
 	dec["basic-query"] = record_query + " disabled=0 and type=:qtype and name=:qname"
 	dec["id-query"] = record_query + " disabled=0 and type=:qtype and name=:qname and domain_id=:domain_id"
 	dec["any-query"] = record_query + " disabled=0 and name=:qname"
 	dec["any-id-query"] = record_query + " disabled=0 and name=:qname and domain_id=:domain_id"
-	dec["list-query"] = "SELECT content,ttl,prio,type,domain_id,disabled,name,auth,ordername FROM records WHERE (disabled=0 OR :include_disabled) and domain_id=:domain_id order by name, type"
+	dec["list-query"] = "SELECT content,ttl,prio,type,domain_id,disabled,name,auth,display_name,ordername FROM records WHERE (disabled=0 OR :include_disabled) and domain_id=:domain_id order by name, type"
+	dec["rrset-query"] = "SELECT content,ttl,prio,type,domain_id,disabled,name,auth,display_name,ordername FROM records WHERE domain_id=:domain_id and name=:qname and type=:qtype"
 	dec["list-subzone-query"] = record_query + " disabled=0 and (name=:zone OR name like :wildzone) and domain_id=:domain_id"
 
 	dec["remove-empty-non-terminals-from-zone-query"] = "delete from records where domain_id=:domain_id and type is null"
 	dec["delete-empty-non-terminal-query"] = "delete from records where domain_id=:domain_id and name=:qname and type is null"
 
 	dec["info-zone-query"] = "select id,name,master,last_check,notified_serial,type,account from domains where name=:domain"
+	dec["info-zone-by-id-query"] = "select id,name,master,last_check,notified_serial,type,account from domains where id=:domain_id"
 
 	dec["get-domain-id"] = "select id from domains where name=:domain"
 
@@ -53,13 +176,14 @@ func declareSQL() map[string]string {
 	dec["list-autoprimaries"] = "select ip,nameserver,account from supermasters"
 
 	dec["insert-zone-query"] = "insert into domains (type,name,master,account,last_check,notified_serial) values(:type, :domain, :masters, :account, null, null)"
+	dec["insert-zone-with-id-query"] = "insert into domains (id,type,name,master,account,last_check,notified_serial) values(:id, :type, :domain, :masters, :account, :last_check, :notified_serial)"
 
-	dec["insert-record-query"] = "insert into records (content,ttl,prio,type,domain_id,disabled,name,ordername,auth) values (:content,:ttl,:priority,:qtype,:domain_id,:disabled,:qname,:ordername,:auth)"
+	dec["insert-record-query"] = "insert into records (content,ttl,prio,type,domain_id,disabled,name,display_name,ordername,auth) values (:content,:ttl,:priority,:qtype,:domain_id,:disabled,:qname,:display_name,:ordername,:auth)"
 	dec["insert-empty-non-terminal-order-query"] = "insert into records (type,domain_id,disabled,name,ordername,auth,ttl,prio,content) values (null,:domain_id,0,:qname,:ordername,:auth,null,null,null)"
 
-	dec["get-order-first-query"] = "select ordername from records where disabled=0 and domain_id=:domain_id and ordername is not null order by 1 asc limit 1"
+	dec["get-order-first-query"] = "select ordername, name from records where disabled=0 and domain_id=:domain_id and ordername is not null order by 1 asc limit 1"
 	dec["get-order-before-query"] = "select ordername, name from records where disabled=0 and ordername <= :ordername and domain_id=:domain_id and ordername is not null order by 1 desc limit 1"
-	dec["get-order-after-query"] = "select min(ordername) from records where disabled=0 and ordername > :ordername and domain_id=:domain_id and ordername is not null"
+	dec["get-order-after-query"] = "select ordername, name from records where disabled=0 and ordername > :ordername and domain_id=:domain_id and ordername is not null order by 1 asc limit 1"
 	dec["get-order-last-query"] = "select ordername, name from records where disabled=0 and ordername != '' and domain_id=:domain_id and ordername is not null order by 1 desc limit 1"
 
 	dec["update-ordername-and-auth-query"] = "update records set ordername=:ordername,auth=:auth where domain_id=:domain_id and name=:qname and disabled=0"
@@ -79,7 +203,10 @@ func declareSQL() map[string]string {
 	dec["delete-names-query"] = "delete from records where domain_id=:domain_id and name=:qname"
 
 	dec["add-domain-key-query"] = "insert into cryptokeys (domain_id, flags, active, published, content) select id, :flags, :active, :published, :content from domains where name=:domain"
+	dec["add-domain-key-with-id-query"] = "insert into cryptokeys (id, domain_id, flags, active, published, content) select :key_id, id, :flags, :active, :published, :content from domains where name=:domain"
 	dec["get-last-inserted-key-id-query"] = "select last_insert_rowid()"
+	dec["list-all-domain-keys-content-query"] = "select id, content from cryptokeys where content is not null"
+	dec["update-domain-key-content-query"] = "update cryptokeys set content=:content where id=:key_id"
 	dec["list-domain-keys-query"] = "select cryptokeys.id, flags, active, published, content from domains, cryptokeys where cryptokeys.domain_id=domains.id and name=:domain"
 	dec["get-all-domain-metadata-query"] = "select kind,content from domains, domainmetadata where domainmetadata.domain_id=domains.id and name=:domain"
 	dec["get-domain-metadata-query"] = "select content from domains, domainmetadata where domainmetadata.domain_id=domains.id and name=:domain and domainmetadata.kind=:kind"
@@ -96,6 +223,7 @@ func declareSQL() map[string]string {
 	dec["set-tsig-key-query"] = "replace into tsigkeys (name,algorithm,secret) values(:key_name,:algorithm,:content)"
 	dec["delete-tsig-key-query"] = "delete from tsigkeys where name=:key_name"
 	dec["get-tsig-keys-query"] = "select name,algorithm, secret from tsigkeys"
+	dec["update-tsig-key-secret-query"] = "update tsigkeys set secret=:content where name=:key_name and algorithm=:algorithm"
 
 	dec["get-all-domains-query"] = "select domains.id, domains.name, records.content, domains.type, domains.master, domains.notified_serial, domains.last_check, domains.account from domains LEFT JOIN records ON records.domain_id=domains.id AND records.type='SOA' AND records.name=domains.name WHERE records.disabled=0 OR :include_disabled"
 
@@ -106,165 +234,91 @@ func declareSQL() map[string]string {
 	dec["search-records-query"] = record_query + " name LIKE :value ESCAPE '\\' OR content LIKE :value2 ESCAPE '\\' LIMIT :limit"
 	dec["search-comments-query"] = "SELECT domain_id,name,type,modified_at,account,comment FROM comments WHERE name LIKE :value ESCAPE '\\' OR comment LIKE :value2 ESCAPE '\\' LIMIT :limit"
 
+	dec["insert-change-query"] = "insert into changes (domain_id, operation, before, after, actor, created_at) values (:domain_id, :operation, :before, :after, :actor, :created_at)"
+	dec["list-changes-since-query"] = "select seq, domain_id, operation, before, after, actor, created_at from changes where seq > :seq and (:domain_id < 0 or domain_id = :domain_id) order by seq limit :limit"
+	dec["compact-changes-before-query"] = "delete from changes where created_at < :created_at"
+	dec["compact-changes-keep-query"] = "delete from changes where seq not in (select seq from changes order by seq desc limit :keep)"
+
+	dec["audit-orphan-records-query"] = "select domain_id, count(*) from records where domain_id is null or domain_id not in (select id from domains) group by domain_id"
+	dec["audit-orphan-comments-query"] = "select domain_id, count(*) from comments where domain_id not in (select id from domains) group by domain_id"
+	dec["audit-orphan-metadata-query"] = "select domain_id, count(*) from domainmetadata where domain_id not in (select id from domains) group by domain_id"
+	dec["audit-orphan-keys-query"] = "select domain_id, count(*) from cryptokeys where domain_id not in (select id from domains) group by domain_id"
+	dec["audit-empty-keys-query"] = "select cryptokeys.id, domains.name from cryptokeys join domains on cryptokeys.domain_id=domains.id where content is null or content=''"
+	dec["audit-duplicate-zones-query"] = "select id, name from domains where lower(name) in (select lower(name) from domains group by lower(name) having count(*) > 1) order by lower(name), id"
+	dec["delete-orphan-records-query"] = "delete from records where domain_id is null or domain_id not in (select id from domains)"
+	dec["delete-orphan-comments-query"] = "delete from comments where domain_id not in (select id from domains)"
+	dec["delete-orphan-metadata-query"] = "delete from domainmetadata where domain_id not in (select id from domains)"
+	dec["delete-orphan-keys-query"] = "delete from cryptokeys where domain_id not in (select id from domains)"
+	dec["delete-empty-keys-query"] = "delete from cryptokeys where content is null or content=''"
+
 	return dec
 }
 
 func (db *Sqlite) Close() {
-	_ = db.db.Close()
+	if db.queue != nil {
+		db.queue.close()
+	}
+	if db.reader != db.writer {
+		_ = db.reader.Close()
+	}
+	_ = db.writer.Close()
 }
 
 func (db *Sqlite) Query(stmt string, args ...interface{}) (storage.IResult, error) {
-	if _, ok := db.declare[stmt]; !ok {
-		return nil, stacktrace.New("Нет информации о запросе: " + stmt)
-	}
-	qs, names, err := sqlex.CompileNamedQuery(db.declare[stmt], db.bindType)
-	if err != nil {
-		return nil, stacktrace.Wrap(err)
-	}
-	arg, err := sqlex.ArgToMap(args...)
-	if err != nil {
-		return nil, stacktrace.Wrap(err)
-	}
+	return db.QueryContext(context.Background(), stmt, args...)
+}
 
-	parametrs := make([]interface{}, 0, len(names))
-	for _, name := range names {
-		if value, ok := arg[name]; !ok {
-			parametrs = append(parametrs, nil)
-		} else {
-			parametrs = append(parametrs, value)
-		}
+func (db *Sqlite) Exec(stmt string, args ...interface{}) (int, error) {
+	return db.ExecContext(context.Background(), stmt, args...)
+}
+
+// WriteStats is zero when the writer queue is off.
+func (db *Sqlite) WriteStats() storage.WriteStats {
+	if db.queue == nil {
+		return storage.WriteStats{}
 	}
+	return db.queue.Stats()
+}
 
-	rows, err := db.db.Query(qs, parametrs...)
-	return rows, stacktrace.New("No implement")
+func (db *Sqlite) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	if db.queue == nil {
+		return db.querier.ExecContext(ctx, stmt, args...)
+	}
+	return db.queue.do(ctx, func(ctx context.Context, q *querier) (int, error) {
+		return q.ExecContext(ctx, stmt, args...)
+	})
 }
 
-func (db *Sqlite) Exec(stmt string, args ...interface{}) (int, error) {
-	if _, ok := db.declare[stmt]; !ok {
-		return 0, stacktrace.New("Нет информации о запросе: " + stmt)
+func (db *Sqlite) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	if db.queue != nil {
+		return db.queue.do(ctx, func(ctx context.Context, q *querier) (int, error) {
+			return q.ExecBatchContext(ctx, stmt, batch)
+		})
 	}
-	qs, names, err := sqlex.CompileNamedQuery(db.declare[stmt], db.bindType)
+	t, err := db.Begin(ctx)
 	if err != nil {
-		return 0, stacktrace.Wrap(err)
+		return 0, err
 	}
-	arg, err := sqlex.ArgToMap(args...)
+	n, err := t.ExecBatchContext(ctx, stmt, batch)
 	if err != nil {
-		return 0, stacktrace.Wrap(err)
+		_ = t.Rollback()
+		return 0, err
 	}
+	return n, t.Commit()
+}
 
-	parametrs := make([]interface{}, 0, len(names))
-	for _, name := range names {
-		if value, ok := arg[name]; !ok {
-			parametrs = append(parametrs, nil)
-		} else {
-			parametrs = append(parametrs, value)
-		}
+// Begin returns a savepoint of the writer queue group transaction when the
+// queue is on, Commit then returns once the group is committed.
+func (db *Sqlite) Begin(ctx context.Context) (storage.ITx, error) {
+	if db.queue != nil {
+		return db.queue.begin(ctx)
 	}
-
-	rows, err := db.db.Exec(qs, parametrs...)
+	t, err := db.writer.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, stacktrace.Wrap(err)
+		return nil, stacktrace.Wrap(classify(err))
 	}
-	rowsAffected, err := rows.RowsAffected()
-	if err != nil {
-		return 0, stacktrace.Wrap(err)
-	}
-	return int(rowsAffected), nil
-}
-
-func (db *Sqlite) CreateTable() error {
-	_, err := db.db.Exec(`
-PRAGMA foreign_keys=OFF;
-BEGIN TRANSACTION;
-CREATE TABLE domains (
-  id                    INTEGER PRIMARY KEY,
-  name                  VARCHAR(255) NOT NULL COLLATE NOCASE,
-  master                VARCHAR(128) DEFAULT NULL,
-  last_check            INTEGER DEFAULT NULL,
-  type                  VARCHAR(6) NOT NULL,
-  notified_serial       INTEGER DEFAULT NULL,
-  account               VARCHAR(40) DEFAULT NULL
-);
-
-CREATE UNIQUE INDEX name_index ON domains(name);
-
-
-CREATE TABLE records (
-  id                    INTEGER PRIMARY KEY,
-  domain_id             INTEGER DEFAULT NULL,
-  name                  VARCHAR(255) DEFAULT NULL,
-  type                  VARCHAR(10) DEFAULT NULL,
-  content               VARCHAR(65535) DEFAULT NULL,
-  ttl                   INTEGER DEFAULT NULL,
-  prio                  INTEGER DEFAULT NULL,
-  disabled              BOOLEAN DEFAULT 0,
-  ordername             VARCHAR(255),
-  auth                  BOOL DEFAULT 1,
-  FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
-);
-
-CREATE INDEX records_lookup_idx ON records(name, type);
-CREATE INDEX records_lookup_id_idx ON records(domain_id, name, type);
-CREATE INDEX records_order_idx ON records(domain_id, ordername);
-
-
-CREATE TABLE supermasters (
-  ip                    VARCHAR(64) NOT NULL,
-  nameserver            VARCHAR(255) NOT NULL COLLATE NOCASE,
-  account               VARCHAR(40) NOT NULL
-);
-
-CREATE UNIQUE INDEX ip_nameserver_pk ON supermasters(ip, nameserver);
-
-
-CREATE TABLE comments (
-  id                    INTEGER PRIMARY KEY,
-  domain_id             INTEGER NOT NULL,
-  name                  VARCHAR(255) NOT NULL,
-  type                  VARCHAR(10) NOT NULL,
-  modified_at           INT NOT NULL,
-  account               VARCHAR(40) DEFAULT NULL,
-  comment               VARCHAR(65535) NOT NULL,
-  FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
-);
-
-CREATE INDEX comments_idx ON comments(domain_id, name, type);
-CREATE INDEX comments_order_idx ON comments (domain_id, modified_at);
-
-
-CREATE TABLE domainmetadata (
- id                     INTEGER PRIMARY KEY,
- domain_id              INT NOT NULL,
- kind                   VARCHAR(32) COLLATE NOCASE,
- content                TEXT,
- FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
-);
-
-CREATE INDEX domainmetaidindex ON domainmetadata(domain_id);
-
-
-CREATE TABLE cryptokeys (
- id                     INTEGER PRIMARY KEY,
- domain_id              INT NOT NULL,
- flags                  INT NOT NULL,
- active                 BOOL,
- published              BOOL DEFAULT 1,
- content                TEXT,
- FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
-);
-
-CREATE INDEX domainidindex ON cryptokeys(domain_id);
-
-
-CREATE TABLE tsigkeys (
- id                     INTEGER PRIMARY KEY,
- name                   VARCHAR(255) COLLATE NOCASE,
- algorithm              VARCHAR(50) COLLATE NOCASE,
- secret                 VARCHAR(255)
-);
-
-CREATE UNIQUE INDEX namealgoindex ON tsigkeys(name, algorithm);
-COMMIT;
-`)
-	return err
+	return &tx{
+		querier: querier{conn: t, stmts: db.stmts},
+		tx:      t,
+	}, nil
 }
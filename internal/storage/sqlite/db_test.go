@@ -0,0 +1,334 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFileDB(t *testing.T, opts ...Option) *Sqlite {
+	db := New(filepath.Join(t.TempDir(), "sql.db"), opts...)
+	t.Cleanup(db.Close)
+	assert.Equal(t, db.CreateTable(), nil)
+	return db
+}
+
+func TestSqlite_WAL(t *testing.T) {
+	db := newFileDB(t)
+	var mode string
+	assert.Equal(t, db.writer.QueryRow("PRAGMA journal_mode").Scan(&mode), nil)
+	assert.Equal(t, mode, "wal")
+	var timeout int
+	assert.Equal(t, db.reader.QueryRow("PRAGMA busy_timeout").Scan(&timeout), nil)
+	assert.Equal(t, timeout, 5000)
+
+	_, err := db.reader.Exec("DELETE FROM records")
+	assert.NotEqual(t, err, nil, "reader pool must be read-only")
+}
+
+func TestSqlite_ReadDuringWrite(t *testing.T) {
+	db := newFileDB(t, WithReaders(4))
+	ctx := context.Background()
+	_, err := db.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", "wal.test.")
+	assert.Equal(t, err, nil)
+
+	tx, err := db.Begin(ctx)
+	assert.Equal(t, err, nil)
+	for i := 0; i < 1000; i++ {
+		_, err = tx.ExecContext(ctx, "insert-record-query",
+			"qname", fmt.Sprintf("h%d.wal.test.", i),
+			"qtype", "A",
+			"content", "127.0.0.1",
+			"ttl", 300,
+			"domain_id", 1,
+			"disabled", false,
+			"auth", true,
+		)
+		assert.Equal(t, err, nil)
+	}
+
+	// readers proceed while the write transaction is open and see the last committed state
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			n, err := countRecords(ctx, db)
+			if err == nil && n != 0 {
+				err = fmt.Errorf("uncommitted rows visible: %d", n)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		assert.Equal(t, <-errs, nil)
+	}
+
+	assert.Equal(t, tx.Commit(), nil)
+	n, err := countRecords(ctx, db)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1000)
+}
+
+func countRecords(ctx context.Context, db *Sqlite) (int, error) {
+	rows, err := db.QueryContext(ctx, "list-query", "include_disabled", false, "domain_id", 1)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
+
+func TestSqlite_BackupRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sql.db")
+	db := New(path)
+	assert.Equal(t, db.CreateTable(), nil)
+	_, err := db.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", "backup.test.")
+	assert.Equal(t, err, nil)
+
+	snapshot, err := db.Backup(ctx, filepath.Join(t.TempDir(), "backups"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(snapshot.Checksum), 64)
+
+	_, err = db.ExecContext(ctx, "delete-domain-query", "domain", "backup.test.")
+	assert.Equal(t, err, nil)
+	db.Close()
+
+	assert.Equal(t, Restore(snapshot.Path, path), nil)
+	db = New(path)
+	defer db.Close()
+	assert.Equal(t, db.CreateTable(), nil)
+	rows, err := db.QueryContext(ctx, "get-domain-id", "domain", "backup.test.")
+	assert.Equal(t, err, nil)
+	assert.True(t, rows.Next())
+	assert.Equal(t, rows.Close(), nil)
+
+	assert.Equal(t, os.WriteFile(snapshot.Path+checksumSuffix, []byte("0000  x\n"), 0o640), nil)
+	assert.NotEqual(t, Restore(snapshot.Path, path), nil)
+}
+
+func TestSqlite_BackupNames(t *testing.T) {
+	db := newFileDB(t)
+	dir := filepath.Join(t.TempDir(), "backups")
+	snapshots := make(chan string, 4)
+	for i := 0; i < cap(snapshots); i++ {
+		go func() {
+			snapshot, err := db.Backup(context.Background(), dir)
+			if err != nil {
+				snapshots <- err.Error()
+				return
+			}
+			snapshots <- snapshot.Path
+		}()
+	}
+	paths := make(map[string]bool)
+	for i := 0; i < cap(snapshots); i++ {
+		path := <-snapshots
+		assert.Equal(t, Restore(path, filepath.Join(t.TempDir(), "restored.db")), nil, path)
+		paths[path] = true
+	}
+	assert.Equal(t, len(paths), cap(snapshots))
+	tmp, err := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(tmp), 0)
+}
+
+func TestSqlite_GroupCommit(t *testing.T) {
+	db := newFileDB(t, WithGroupCommit(20*time.Millisecond, 128))
+	ctx := context.Background()
+	_, err := db.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", "dup.test.")
+	assert.Equal(t, err, nil)
+
+	errs := make(chan error, 64)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			domain := fmt.Sprintf("z%d.test.", i)
+			if i == 0 {
+				domain = "dup.test."
+			}
+			_, err := db.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", domain)
+			errs <- err
+		}(i)
+	}
+	failed := 0
+	for i := 0; i < cap(errs); i++ {
+		if <-errs != nil {
+			failed++
+		}
+	}
+	// the duplicate fails alone, the rest of its group is committed
+	assert.Equal(t, failed, 1)
+	var n int
+	assert.Equal(t, db.writer.QueryRow("SELECT count(*) FROM domains").Scan(&n), nil)
+	assert.Equal(t, n, cap(errs))
+	stats := db.WriteStats()
+	assert.Equal(t, stats.Requests, uint64(cap(errs)+1))
+	assert.Less(t, stats.Groups, stats.Requests)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = db.ExecContext(canceled, "insert-zone-query", "type", "MASTER", "domain", "canceled.test.")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSqlite_GroupCommitTx(t *testing.T) {
+	db := newFileDB(t, WithGroupCommit(20*time.Millisecond, 128))
+	ctx := context.Background()
+
+	errs := make(chan error, 32)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			tx, err := db.Begin(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, err = tx.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", fmt.Sprintf("z%d.test.", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if i%2 == 1 {
+				errs <- tx.Rollback()
+				return
+			}
+			errs <- tx.Commit()
+		}(i)
+	}
+	for i := 0; i < cap(errs); i++ {
+		assert.Equal(t, <-errs, nil)
+	}
+	var n int
+	assert.Equal(t, db.writer.QueryRow("SELECT count(*) FROM domains").Scan(&n), nil)
+	assert.Equal(t, n, cap(errs)/2)
+	assert.Equal(t, db.WriteStats().Groups, uint64(cap(errs)))
+
+	// a transaction whose context is done is rolled back, the group goes on
+	canceled, cancel := context.WithCancel(ctx)
+	tx, err := db.Begin(canceled)
+	assert.Equal(t, err, nil)
+	_, err = tx.ExecContext(canceled, "insert-zone-query", "type", "MASTER", "domain", "canceled.test.")
+	assert.Equal(t, err, nil)
+	cancel()
+	_, err = db.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", "after.test.")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, tx.Commit(), nil)
+	assert.Equal(t, db.writer.QueryRow("SELECT count(*) FROM domains").Scan(&n), nil)
+	assert.Equal(t, n, cap(errs)/2+1)
+}
+
+func TestSqlite_GroupCommitTxOwnGroup(t *testing.T) {
+	db := newFileDB(t, WithGroupCommit(100*time.Millisecond, 128))
+	ctx := context.Background()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := db.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", "plain.test.")
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// the transaction arrives within the window of the plain write
+	tx, err := db.Begin(ctx)
+	assert.Equal(t, err, nil)
+	select {
+	case err := <-errs:
+		assert.Equal(t, err, nil)
+	case <-time.After(time.Second):
+		t.Fatal("plain write waits for the transaction")
+	}
+	_, err = tx.ExecContext(ctx, "insert-zone-query", "type", "MASTER", "domain", "tx.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tx.Commit(), nil)
+	var n int
+	assert.Equal(t, db.writer.QueryRow("SELECT count(*) FROM domains").Scan(&n), nil)
+	assert.Equal(t, n, 2)
+	assert.Equal(t, db.WriteStats().Groups, uint64(2))
+}
+
+// BenchmarkConcurrentWrites syncs every commit to disk, the cost group
+// commit shares between the writes of a group.
+func BenchmarkConcurrentWrites(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opt  Option
+	}{
+		{"direct", WithGroupCommit(0, 0)},
+		{"group", WithGroupCommit(0, 128)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			db := New(filepath.Join(b.TempDir(), "sql.db")+"?_synchronous=FULL", bc.opt)
+			defer db.Close()
+			if err := db.CreateTable(); err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+			var seq int64
+			var mu sync.Mutex
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					mu.Lock()
+					seq++
+					i := seq
+					mu.Unlock()
+					_, err := db.ExecContext(ctx, "insert-record-query",
+						"qname", fmt.Sprintf("h%d.bench.test.", i),
+						"qtype", "A",
+						"content", "127.0.0.1",
+						"ttl", 300,
+						"domain_id", 1,
+					)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			if stats := db.WriteStats(); stats.Groups > 0 {
+				b.ReportMetric(float64(stats.Requests)/float64(stats.Groups), "writes/group")
+			}
+		})
+	}
+}
+
+func TestSqlite_NameMigration(t *testing.T) {
+	ctx := context.Background()
+	db := New(filepath.Join(t.TempDir(), "sql.db"))
+	defer db.Close()
+	for version := 0; version < 2; version++ {
+		_, err := db.writer.Exec(migrations[version])
+		assert.Equal(t, err, nil)
+	}
+	_, err := db.writer.Exec("PRAGMA user_version = 2")
+	assert.Equal(t, err, nil)
+	_, err = db.writer.Exec("INSERT INTO records (domain_id, name, type, content) VALUES (1, 'WWW.Example.com.', 'A', '192.0.2.1'), (1, 'ns.example.com.', 'A', '192.0.2.2')")
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, db.CreateTable(), nil)
+	version, err := db.schemaVersion(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, version, SchemaVersion)
+	rows, err := db.QueryContext(ctx, "basic-query", "qtype", "A", "qname", "www.example.com.")
+	assert.Equal(t, err, nil)
+	defer rows.Close()
+	assert.True(t, rows.Next())
+	var content, qtype, name, display string
+	var ttl, prio, domainID interface{}
+	var disabled, auth bool
+	assert.Equal(t, rows.Scan(&content, &ttl, &prio, &qtype, &domainID, &disabled, &name, &auth, &display), nil)
+	assert.Equal(t, name, "www.example.com.")
+	assert.Equal(t, display, "WWW.Example.com.")
+	assert.False(t, rows.Next())
+}
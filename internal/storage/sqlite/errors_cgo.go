@@ -0,0 +1,23 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"errors"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/mattn/go-sqlite3"
+)
+
+// classify marks lock contention as transient.
+func classify(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrBusy, sqlite3.ErrLocked:
+			return storage.Transient(err)
+		}
+	}
+	return err
+}
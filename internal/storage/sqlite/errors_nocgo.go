@@ -0,0 +1,9 @@
+//go:build !cgo
+// +build !cgo
+
+package sqlite
+
+// classify passes errors through, without cgo there is no SQLite to fail.
+func classify(err error) error {
+	return err
+}
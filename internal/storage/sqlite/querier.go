@@ -0,0 +1,200 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	sqlex "github.com/ivan-bokov/go-pdns/internal/storage/sql"
+)
+
+// sqliteMaxVariables is SQLITE_MAX_VARIABLE_NUMBER of older SQLite builds,
+// the safe upper bound of bound parameters in one statement.
+const sqliteMaxVariables = 999
+
+type execer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type compiled struct {
+	query string
+	names []string
+}
+
+type compiledKey struct {
+	stmt string
+	rows int
+}
+
+type statements struct {
+	declare   map[string]string
+	bindType  int
+	batchSize int
+
+	mu    sync.RWMutex
+	cache map[compiledKey]*compiled
+}
+
+func newStatements(declare map[string]string, bindType int) *statements {
+	return &statements{
+		declare:   declare,
+		bindType:  bindType,
+		batchSize: 100,
+		cache:     make(map[compiledKey]*compiled),
+	}
+}
+
+func (st *statements) get(stmt string, rows int) (*compiled, error) {
+	key := compiledKey{stmt: stmt, rows: rows}
+	st.mu.RLock()
+	c, ok := st.cache[key]
+	st.mu.RUnlock()
+	if ok {
+		return c, nil
+	}
+	qs, ok := st.declare[stmt]
+	if !ok {
+		return nil, stacktrace.New("Нет информации о запросе: " + stmt)
+	}
+	var err error
+	if rows > 1 {
+		qs, err = sqlex.ExpandValues(qs, rows)
+		if err != nil {
+			return nil, stacktrace.Wrap(err)
+		}
+	}
+	c = new(compiled)
+	c.query, c.names, err = sqlex.CompileNamedQuery(qs, st.bindType)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	st.mu.Lock()
+	st.cache[key] = c
+	st.mu.Unlock()
+	return c, nil
+}
+
+// rowsPerBatch returns how many rows of stmt fit into one statement.
+func (st *statements) rowsPerBatch(stmt string) (int, error) {
+	c, err := st.get(stmt, 1)
+	if err != nil {
+		return 0, err
+	}
+	n := st.batchSize
+	if len(c.names) > 0 && sqliteMaxVariables/len(c.names) < n {
+		n = sqliteMaxVariables / len(c.names)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n, nil
+}
+
+func bind(names []string, params []interface{}, args ...interface{}) ([]interface{}, error) {
+	arg, err := sqlex.ArgToMap(args...)
+	if err != nil {
+		return nil, stacktrace.Wrap(err)
+	}
+	for _, name := range names {
+		if value, ok := arg[name]; !ok {
+			params = append(params, nil)
+		} else {
+			params = append(params, value)
+		}
+	}
+	return params, nil
+}
+
+type querier struct {
+	conn  execer
+	stmts *statements
+}
+
+func (q *querier) QueryContext(ctx context.Context, stmt string, args ...interface{}) (storage.IResult, error) {
+	c, err := q.stmts.get(stmt, 1)
+	if err != nil {
+		return nil, err
+	}
+	params, err := bind(c.names, make([]interface{}, 0, len(c.names)), args...)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.conn.QueryContext(ctx, c.query, params...)
+	if err != nil {
+		return nil, stacktrace.Wrap(classify(err))
+	}
+	return rows, nil
+}
+
+func (q *querier) ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	c, err := q.stmts.get(stmt, 1)
+	if err != nil {
+		return 0, err
+	}
+	params, err := bind(c.names, make([]interface{}, 0, len(c.names)), args...)
+	if err != nil {
+		return 0, err
+	}
+	return q.exec(ctx, c.query, params)
+}
+
+func (q *querier) ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error) {
+	perBatch, err := q.stmts.rowsPerBatch(stmt)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for len(batch) > 0 {
+		n := perBatch
+		if len(batch) < n {
+			n = len(batch)
+		}
+		c, err := q.stmts.get(stmt, n)
+		if err != nil {
+			return total, err
+		}
+		perRow := len(c.names) / n
+		params := make([]interface{}, 0, len(c.names))
+		for i, args := range batch[:n] {
+			params, err = bind(c.names[i*perRow:(i+1)*perRow], params, args...)
+			if err != nil {
+				return total, err
+			}
+		}
+		affected, err := q.exec(ctx, c.query, params)
+		total += affected
+		if err != nil {
+			return total, err
+		}
+		batch = batch[n:]
+	}
+	return total, nil
+}
+
+func (q *querier) exec(ctx context.Context, query string, params []interface{}) (int, error) {
+	res, err := q.conn.ExecContext(ctx, query, params...)
+	if err != nil {
+		return 0, stacktrace.Wrap(classify(err))
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, stacktrace.Wrap(err)
+	}
+	return int(rowsAffected), nil
+}
+
+type tx struct {
+	querier
+	tx *sql.Tx
+}
+
+func (t *tx) Commit() error {
+	return stacktrace.Wrap(classify(t.tx.Commit()))
+}
+
+func (t *tx) Rollback() error {
+	return stacktrace.Wrap(t.tx.Rollback())
+}
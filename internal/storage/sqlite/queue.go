@@ -0,0 +1,304 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+)
+
+type writeResult struct {
+	n   int
+	err error
+}
+
+type write struct {
+	ctx  context.Context
+	fn   func(ctx context.Context, q *querier) (int, error)
+	done chan writeResult
+	// tx is set for a transaction handed over to the caller
+	tx bool
+}
+
+// queue runs every write on one goroutine, in a group transaction that
+// takes the writes queued while it runs, or arriving within window after
+// the first one, and commits them together. Each write runs in a savepoint,
+// so a failed write is undone alone and learns its result at once; the
+// others learn theirs once the group is committed. A transaction lasts as
+// long as its caller wants, so it always gets a group of its own and never
+// holds back the writes of another group.
+type queue struct {
+	stats    storage.WriteStats
+	db       *sql.DB
+	stmts    *statements
+	window   time.Duration
+	maxGroup int
+
+	requests chan *write
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newQueue(db *sql.DB, stmts *statements, window time.Duration, maxGroup int) *queue {
+	q := &queue{
+		db:       db,
+		stmts:    stmts,
+		window:   window,
+		maxGroup: maxGroup,
+		requests: make(chan *write),
+		stop:     make(chan struct{}),
+	}
+	q.wg.Add(1)
+	go q.run()
+	return q
+}
+
+func (q *queue) close() {
+	close(q.stop)
+	q.wg.Wait()
+}
+
+func (q *queue) Stats() storage.WriteStats {
+	return storage.WriteStats{
+		Requests: atomic.LoadUint64(&q.stats.Requests),
+		Groups:   atomic.LoadUint64(&q.stats.Groups),
+	}
+}
+
+func (q *queue) enqueue(ctx context.Context, tx bool, fn func(ctx context.Context, q *querier) (int, error)) (*write, error) {
+	w := &write{ctx: ctx, fn: fn, done: make(chan writeResult, 1), tx: tx}
+	select {
+	case q.requests <- w:
+		return w, nil
+	case <-ctx.Done():
+		return nil, stacktrace.Wrap(ctx.Err())
+	case <-q.stop:
+		return nil, stacktrace.New("Database is closed")
+	}
+}
+
+// do waits for the result once the write is queued, even if ctx is done by
+// then, so the caller never misses a write that went through.
+func (q *queue) do(ctx context.Context, fn func(ctx context.Context, q *querier) (int, error)) (int, error) {
+	w, err := q.enqueue(ctx, false, fn)
+	if err != nil {
+		return 0, err
+	}
+	res := <-w.done
+	return res.n, res.err
+}
+
+var errRollback = errors.New("transaction rolled back")
+
+// begin hands a savepoint of a group of its own over to the caller until
+// it commits or rolls back. The savepoint is rolled back when ctx is done
+// before that.
+func (q *queue) begin(ctx context.Context) (storage.ITx, error) {
+	t := &queuedTx{
+		start: make(chan *querier, 1),
+		end:   make(chan error, 1),
+	}
+	w, err := q.enqueue(ctx, true, func(ctx context.Context, tq *querier) (int, error) {
+		t.start <- tq
+		select {
+		case err := <-t.end:
+			return 0, err
+		case <-ctx.Done():
+			t.finish()
+			return 0, stacktrace.Wrap(ctx.Err())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.w = w
+	select {
+	case tq := <-t.start:
+		t.querier = querier{conn: &guarded{t: t, conn: tq.conn}, stmts: tq.stmts}
+		return t, nil
+	case res := <-w.done:
+		return nil, res.err
+	}
+}
+
+func (q *queue) run() {
+	defer q.wg.Done()
+	for {
+		select {
+		case w := <-q.requests:
+			for w != nil {
+				w = q.commit(w)
+			}
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// next returns the write to add to the group, nil when the group is done.
+func (q *queue) next(n int, timeout <-chan time.Time) *write {
+	if n >= q.maxGroup {
+		return nil
+	}
+	if timeout == nil {
+		select {
+		case w := <-q.requests:
+			return w
+		default:
+			return nil
+		}
+	}
+	select {
+	case w := <-q.requests:
+		return w
+	case <-timeout:
+		return nil
+	}
+}
+
+// commit runs the group started by w. It returns the transaction that
+// arrived while the group was open, to run in the next group.
+func (q *queue) commit(w *write) *write {
+	var timeout <-chan time.Time
+	if q.window > 0 {
+		timer := time.NewTimer(q.window)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	atomic.AddUint64(&q.stats.Groups, 1)
+	atomic.AddUint64(&q.stats.Requests, 1)
+	t, err := q.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		w.done <- writeResult{err: stacktrace.Wrap(classify(err))}
+		return nil
+	}
+	tq := &querier{conn: t, stmts: q.stmts}
+	pending := make([]*write, 0, q.maxGroup)
+	results := make([]writeResult, 0, q.maxGroup)
+	for n := 1; ; n++ {
+		res, err := q.exec(t, tq, w)
+		if err != nil {
+			// the group transaction is unusable
+			_ = t.Rollback()
+			w.done <- writeResult{err: err}
+			for _, w := range pending {
+				w.done <- writeResult{err: err}
+			}
+			return nil
+		}
+		if res.err != nil {
+			w.done <- res
+		} else {
+			pending = append(pending, w)
+			results = append(results, res)
+		}
+		if w.tx {
+			w = nil
+			break
+		}
+		if w = q.next(n, timeout); w == nil || w.tx {
+			break
+		}
+		atomic.AddUint64(&q.stats.Requests, 1)
+	}
+	err = stacktrace.Wrap(classify(t.Commit()))
+	for i, w := range pending {
+		if err != nil {
+			results[i] = writeResult{err: err}
+		}
+		w.done <- results[i]
+	}
+	return w
+}
+
+// exec runs w in a savepoint, the error is about the group transaction.
+func (q *queue) exec(t *sql.Tx, tq *querier, w *write) (writeResult, error) {
+	var res writeResult
+	if err := w.ctx.Err(); err != nil {
+		res.err = stacktrace.Wrap(err)
+		return res, nil
+	}
+	if _, err := t.Exec("SAVEPOINT write"); err != nil {
+		return res, stacktrace.Wrap(classify(err))
+	}
+	res.n, res.err = w.fn(w.ctx, tq)
+	if res.err != nil {
+		if _, err := t.Exec("ROLLBACK TO write"); err != nil {
+			return res, stacktrace.Wrap(classify(err))
+		}
+	}
+	if _, err := t.Exec("RELEASE write"); err != nil {
+		return res, stacktrace.Wrap(classify(err))
+	}
+	return res, nil
+}
+
+// queuedTx is a savepoint of the group transaction.
+type queuedTx struct {
+	querier
+	w     *write
+	start chan *querier
+	end   chan error
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// finish stops the statements of t, waiting for a running one.
+func (t *queuedTx) finish() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.closed = true
+	return true
+}
+
+func (t *queuedTx) Commit() error {
+	if !t.finish() {
+		return stacktrace.Wrap(sql.ErrTxDone)
+	}
+	t.end <- nil
+	return (<-t.w.done).err
+}
+
+func (t *queuedTx) Rollback() error {
+	if !t.finish() {
+		return stacktrace.Wrap(sql.ErrTxDone)
+	}
+	t.end <- errRollback
+	if err := (<-t.w.done).err; !errors.Is(err, errRollback) {
+		return err
+	}
+	return nil
+}
+
+// guarded keeps a finished transaction off the group transaction.
+type guarded struct {
+	t    *queuedTx
+	conn execer
+}
+
+func (g *guarded) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	g.t.mu.Lock()
+	defer g.t.mu.Unlock()
+	if g.t.closed {
+		return nil, sql.ErrTxDone
+	}
+	return g.conn.QueryContext(ctx, query, args...)
+}
+
+func (g *guarded) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	g.t.mu.Lock()
+	defer g.t.mu.Unlock()
+	if g.t.closed {
+		return nil, sql.ErrTxDone
+	}
+	return g.conn.ExecContext(ctx, query, args...)
+}
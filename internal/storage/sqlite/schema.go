@@ -0,0 +1,190 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ivan-bokov/go-pdns/internal/stacktrace"
+)
+
+// SchemaVersion is kept in PRAGMA user_version. migrations[i] upgrades the
+// schema from version i to i+1, the first one also accepts a stock PowerDNS
+// gsqlite3 database.
+const SchemaVersion = 3
+
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS domains (
+  id                    INTEGER PRIMARY KEY,
+  name                  VARCHAR(255) NOT NULL COLLATE NOCASE,
+  master                VARCHAR(128) DEFAULT NULL,
+  last_check            INTEGER DEFAULT NULL,
+  type                  VARCHAR(6) NOT NULL,
+  notified_serial       INTEGER DEFAULT NULL,
+  account               VARCHAR(40) DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS name_index ON domains(name);
+
+
+CREATE TABLE IF NOT EXISTS records (
+  id                    INTEGER PRIMARY KEY,
+  domain_id             INTEGER DEFAULT NULL,
+  name                  VARCHAR(255) DEFAULT NULL,
+  type                  VARCHAR(10) DEFAULT NULL,
+  content               VARCHAR(65535) DEFAULT NULL,
+  ttl                   INTEGER DEFAULT NULL,
+  prio                  INTEGER DEFAULT NULL,
+  disabled              BOOLEAN DEFAULT 0,
+  ordername             VARCHAR(255),
+  auth                  BOOL DEFAULT 1,
+  FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS records_lookup_idx ON records(name, type);
+CREATE INDEX IF NOT EXISTS records_lookup_id_idx ON records(domain_id, name, type);
+CREATE INDEX IF NOT EXISTS records_order_idx ON records(domain_id, ordername);
+
+
+CREATE TABLE IF NOT EXISTS supermasters (
+  ip                    VARCHAR(64) NOT NULL,
+  nameserver            VARCHAR(255) NOT NULL COLLATE NOCASE,
+  account               VARCHAR(40) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ip_nameserver_pk ON supermasters(ip, nameserver);
+
+
+CREATE TABLE IF NOT EXISTS comments (
+  id                    INTEGER PRIMARY KEY,
+  domain_id             INTEGER NOT NULL,
+  name                  VARCHAR(255) NOT NULL,
+  type                  VARCHAR(10) NOT NULL,
+  modified_at           INT NOT NULL,
+  account               VARCHAR(40) DEFAULT NULL,
+  comment               VARCHAR(65535) NOT NULL,
+  FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comments_idx ON comments(domain_id, name, type);
+CREATE INDEX IF NOT EXISTS comments_order_idx ON comments (domain_id, modified_at);
+
+
+CREATE TABLE IF NOT EXISTS domainmetadata (
+ id                     INTEGER PRIMARY KEY,
+ domain_id              INT NOT NULL,
+ kind                   VARCHAR(32) COLLATE NOCASE,
+ content                TEXT,
+ FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS domainmetaidindex ON domainmetadata(domain_id);
+
+
+CREATE TABLE IF NOT EXISTS cryptokeys (
+ id                     INTEGER PRIMARY KEY,
+ domain_id              INT NOT NULL,
+ flags                  INT NOT NULL,
+ active                 BOOL,
+ published              BOOL DEFAULT 1,
+ content                TEXT,
+ FOREIGN KEY(domain_id) REFERENCES domains(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS domainidindex ON cryptokeys(domain_id);
+
+
+CREATE TABLE IF NOT EXISTS tsigkeys (
+ id                     INTEGER PRIMARY KEY,
+ name                   VARCHAR(255) COLLATE NOCASE,
+ algorithm              VARCHAR(50) COLLATE NOCASE,
+ secret                 VARCHAR(255)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS namealgoindex ON tsigkeys(name, algorithm);
+`,
+	`CREATE TABLE IF NOT EXISTS changes (
+  seq                   INTEGER PRIMARY KEY AUTOINCREMENT,
+  domain_id             INTEGER DEFAULT NULL,
+  operation             VARCHAR(32) NOT NULL,
+  before                TEXT DEFAULT NULL,
+  after                 TEXT DEFAULT NULL,
+  actor                 VARCHAR(255) DEFAULT NULL,
+  created_at            INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS changes_domain_idx ON changes(domain_id, seq);
+CREATE INDEX IF NOT EXISTS changes_created_idx ON changes(created_at);
+`,
+	// names are matched lowercase through the name indexes, the case they
+	// were written with moves to display_name
+	`ALTER TABLE records ADD COLUMN display_name VARCHAR(255) DEFAULT NULL;
+
+UPDATE records SET display_name=name, name=lower(name) WHERE name != lower(name);
+`,
+}
+
+func (db *Sqlite) CreateTable() error {
+	ctx := context.Background()
+	version, err := db.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return stacktrace.Newf("database schema version %d is newer than supported %d", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		tx, err := db.writer.BeginTx(ctx, nil)
+		if err != nil {
+			return stacktrace.Wrap(err)
+		}
+		if _, err = tx.Exec(migrations[version]); err == nil {
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return stacktrace.Newf("migration to schema version %d: %v", version+1, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return stacktrace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (db *Sqlite) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.writer.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	return version, stacktrace.Wrap(err)
+}
+
+// OpenMigrated snapshots the database at path into a temporary directory
+// and opens the snapshot migrated to SchemaVersion, so a database of an
+// older schema, such as a stock PowerDNS gsqlite3 one, is read without
+// being changed. cleanup closes the snapshot and deletes it.
+func OpenMigrated(ctx context.Context, path string, opts ...Option) (*Sqlite, func(), error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, stacktrace.Wrap(err)
+	}
+	dir, err := os.MkdirTemp("", "go-pdns-migrated-")
+	if err != nil {
+		return nil, nil, stacktrace.Wrap(err)
+	}
+	src := New(path, opts...)
+	snapshot, err := src.Backup(ctx, dir)
+	src.Close()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	db := New(snapshot.Path, opts...)
+	cleanup := func() {
+		db.Close()
+		_ = os.RemoveAll(dir)
+	}
+	if err = db.CreateTable(); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return db, cleanup, nil
+}
@@ -1,15 +1,54 @@
 package storage
 
+import (
+	"context"
+	"time"
+)
+
 type IStorage interface {
+	IQuerier
 	CreateTable() error
 	Query(stmt string, args ...interface{}) (IResult, error)
 	Exec(stmt string, args ...interface{}) (int, error)
+	Begin(ctx context.Context) (ITx, error)
 	Close()
 }
 
+type IQuerier interface {
+	QueryContext(ctx context.Context, stmt string, args ...interface{}) (IResult, error)
+	ExecContext(ctx context.Context, stmt string, args ...interface{}) (int, error)
+	// ExecBatchContext runs an insert statement for every element of batch,
+	// each element holding the same name/value pairs as ExecContext args.
+	ExecBatchContext(ctx context.Context, stmt string, batch [][]interface{}) (int, error)
+}
+
+type ITx interface {
+	IQuerier
+	Commit() error
+	Rollback() error
+}
+
 type IResult interface {
 	Next() bool
 	Err() error
 	Columns() ([]string, error)
 	Scan(dest ...interface{}) error
+	Close() error
+}
+
+// Snapshot describes a consistent copy of the database written by a backup.
+type Snapshot struct {
+	Path     string    `json:"path"`
+	Checksum string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
+
+// WriteStats counts the writes done by a writer queue grouping them into
+// transactions.
+type WriteStats struct {
+	// Requests counts transactions and writes outside a transaction
+	Requests uint64 `json:"requests"`
+	// Groups counts the transactions they were committed in
+	Groups uint64 `json:"groups"`
 }
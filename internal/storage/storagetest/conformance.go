@@ -0,0 +1,659 @@
+// Package storagetest holds the conformance suite every storage backend
+// must pass, so the service behaves the same whatever stores its data.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/ivan-bokov/go-pdns/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory returns an empty store for one test. Backends speaking named
+// statements are passed through catalog.New.
+type Factory func(t *testing.T) storage.Store
+
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Store)
+	}{
+		{"Zones", testZones},
+		{"ZoneList", testZoneList},
+		{"PreservedIDs", testPreservedIDs},
+		{"Lookup", testLookup},
+		{"NameCase", testNameCase},
+		{"List", testList},
+		{"Metadata", testMetadata},
+		{"Keys", testKeys},
+		{"TSIG", testTSIG},
+		{"Comments", testComments},
+		{"Supermasters", testSupermasters},
+		{"OrderName", testOrderName},
+		{"OrderNameAndAuth", testOrderNameAndAuth},
+		{"Commit", testCommit},
+		{"Rollback", testRollback},
+		{"Changes", testChanges},
+		{"ChangesGap", testChangesGap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory(t))
+		})
+	}
+}
+
+func createZone(t *testing.T, r *storage.Repositories, name string) int {
+	t.Helper()
+	ctx := context.Background()
+	if err := r.Zones.Create(ctx, &storage.Zone{Name: name, Kind: "NATIVE"}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := r.Zones.ID(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func insert(t *testing.T, r *storage.Repositories, rrs ...*storage.Record) {
+	t.Helper()
+	n, err := r.Records.Insert(context.Background(), rrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, len(rrs))
+}
+
+func contents(rrs []*storage.Record) []string {
+	list := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		list = append(list, rr.Content)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func testZones(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+
+	_, err := r.Zones.ID(ctx, "zone.test.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+	_, err = r.Zones.Get(ctx, "zone.test.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	err = r.Zones.Create(ctx, &storage.Zone{Name: "zone.test.", Kind: "SLAVE", Master: "192.0.2.1:53", Account: "ops"})
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, r.Zones.Create(ctx, &storage.Zone{Name: "zone.test.", Kind: "NATIVE"}), nil)
+
+	id, err := r.Zones.ID(ctx, "zone.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.Zones.SetNotified(ctx, id, 2022010101), nil)
+	assert.Equal(t, r.Zones.SetLastCheck(ctx, id, 1641000000), nil)
+
+	zone, err := r.Zones.Get(ctx, "zone.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, zone, &storage.Zone{
+		ID:             id,
+		Name:           "zone.test.",
+		Master:         "192.0.2.1:53",
+		LastCheck:      1641000000,
+		Kind:           "SLAVE",
+		NotifiedSerial: 2022010101,
+		Account:        "ops",
+	})
+	byID, err := r.Zones.ByID(ctx, id)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, byID, zone)
+	_, err = r.Zones.ByID(ctx, id+1000)
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+}
+
+func testZoneList(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	a := createZone(t, r, "a.test.")
+	b := createZone(t, r, "b.test.")
+	createZone(t, r, "nosoa.test.")
+	insert(t, r,
+		&storage.Record{DomainID: a, Name: "a.test.", Type: "SOA", Content: "ns.a.test. admin.a.test. 1 3600 600 86400 60", TTL: 3600, Auth: true},
+		&storage.Record{DomainID: b, Name: "b.test.", Type: "SOA", Content: "ns.b.test. admin.b.test. 2 3600 600 86400 60", TTL: 3600, Auth: true, Disabled: true},
+	)
+
+	soa := func(zones []*storage.Zone) map[string]string {
+		m := make(map[string]string)
+		for _, zone := range zones {
+			m[zone.Name] = zone.SOA
+		}
+		return m
+	}
+	zones, err := r.Zones.List(ctx, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, soa(zones), map[string]string{
+		"a.test.": "ns.a.test. admin.a.test. 1 3600 600 86400 60",
+	})
+	zones, err = r.Zones.List(ctx, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, soa(zones), map[string]string{
+		"a.test.":     "ns.a.test. admin.a.test. 1 3600 600 86400 60",
+		"b.test.":     "ns.b.test. admin.b.test. 2 3600 600 86400 60",
+		"nosoa.test.": "",
+	})
+}
+
+func testPreservedIDs(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+
+	zone := &storage.Zone{ID: 42, Name: "a.test.", Kind: "MASTER", LastCheck: 1641000000, NotifiedSerial: 7}
+	assert.Equal(t, r.Zones.Create(ctx, zone), nil)
+	assert.NotEqual(t, r.Zones.Create(ctx, &storage.Zone{ID: 42, Name: "b.test.", Kind: "NATIVE"}), nil)
+	got, err := r.Zones.Get(ctx, "a.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, got, zone)
+	// ids picked by the storage come after the preserved ones
+	assert.True(t, createZone(t, r, "c.test.") > 42)
+
+	n, err := r.Keys.Add(ctx, "a.test.", &storage.Key{ID: 9, Flags: 257, Active: true, Content: "ksk"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	_, err = r.Keys.Add(ctx, "a.test.", &storage.Key{ID: 9, Flags: 256, Content: "zsk"})
+	assert.NotEqual(t, err, nil)
+	_, err = r.Keys.Add(ctx, "a.test.", &storage.Key{Flags: 256, Content: "zsk"})
+	assert.Equal(t, err, nil)
+	keys, err := r.Keys.List(ctx, "a.test.")
+	assert.Equal(t, err, nil)
+	ids := make([]int, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+	sort.Ints(ids)
+	assert.Equal(t, len(ids), 2)
+	assert.Equal(t, ids[0], 9)
+	assert.True(t, ids[1] > 9)
+}
+
+func testLookup(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	a := createZone(t, r, "a.test.")
+	b := createZone(t, r, "b.test.")
+	insert(t, r,
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.1", TTL: 60, Auth: true},
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.2", TTL: 60, Auth: true},
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "AAAA", Content: "2001:db8::1", TTL: 60, Auth: true},
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.3", TTL: 60, Disabled: true},
+		&storage.Record{DomainID: a, Name: "mx.a.test.", Type: "MX", Content: "mail.a.test.", TTL: 300, Prio: 10},
+		&storage.Record{DomainID: b, Name: "www.a.test.", Type: "A", Content: "198.51.100.1", TTL: 60},
+	)
+
+	lookup := func(q storage.LookupQuery) []string {
+		rrs, err := r.Records.Lookup(ctx, q)
+		assert.Equal(t, err, nil)
+		return contents(rrs)
+	}
+	assert.Equal(t, lookup(storage.LookupQuery{Name: "www.a.test.", Type: "A", DomainID: -1}),
+		[]string{"192.0.2.1", "192.0.2.2", "198.51.100.1"})
+	assert.Equal(t, lookup(storage.LookupQuery{Name: "www.a.test.", Type: "A", DomainID: a}),
+		[]string{"192.0.2.1", "192.0.2.2"})
+	assert.Equal(t, lookup(storage.LookupQuery{Name: "www.a.test.", Type: "ANY", DomainID: a}),
+		[]string{"192.0.2.1", "192.0.2.2", "2001:db8::1"})
+	assert.Equal(t, lookup(storage.LookupQuery{Name: "www.a.test.", DomainID: -1}),
+		[]string{"192.0.2.1", "192.0.2.2", "198.51.100.1", "2001:db8::1"})
+	assert.Equal(t, lookup(storage.LookupQuery{Name: "none.a.test.", Type: "A", DomainID: -1}), []string{})
+
+	rrs, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "mx.a.test.", Type: "MX", DomainID: a})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rrs, []*storage.Record{{
+		DomainID: a,
+		Name:     "mx.a.test.",
+		Type:     "MX",
+		Content:  "mail.a.test.",
+		TTL:      300,
+		Prio:     10,
+	}})
+
+	rrs, err = r.Records.RRSet(ctx, a, "WWW.a.test.", "A")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, contents(rrs), []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"})
+	rrs, err = r.Records.RRSet(ctx, a, "none.a.test.", "A")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rrs, []*storage.Record{})
+}
+
+func testNameCase(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	a := createZone(t, r, "a.test.")
+	insert(t, r,
+		&storage.Record{DomainID: a, Name: "WWW.a.test.", Type: "A", Content: "192.0.2.1", TTL: 60, Auth: true},
+		&storage.Record{DomainID: a, Name: "mail.a.test.", DisplayName: "Mail.A.test.", Type: "A", Content: "192.0.2.2", TTL: 60, Auth: true},
+		&storage.Record{DomainID: a, Name: "ns.a.test.", Type: "A", Content: "192.0.2.3", TTL: 60, Auth: true},
+	)
+
+	rrs, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "www.A.TEST.", Type: "A", DomainID: a})
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(rrs), 1) {
+		assert.Equal(t, rrs[0].Name, "www.a.test.")
+		assert.Equal(t, rrs[0].DisplayName, "WWW.a.test.")
+	}
+	it, err := r.Records.List(ctx, a, false)
+	assert.Equal(t, err, nil)
+	names := make([]string, 0)
+	for it.Next() {
+		names = append(names, it.Record().Name+" "+it.Record().DisplayName)
+	}
+	assert.Equal(t, it.Err(), nil)
+	assert.Equal(t, it.Close(), nil)
+	assert.Equal(t, names, []string{"mail.a.test. Mail.A.test.", "ns.a.test. ", "www.a.test. WWW.a.test."})
+
+	n, err := r.Records.SetOrderNameAndAuth(ctx, a, "MAIL.a.test.", "A", "mail", false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	n, err = r.Records.DeleteRRSet(ctx, a, "Www.A.Test.", "A")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+}
+
+func testList(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	a := createZone(t, r, "a.test.")
+	insert(t, r,
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.1", TTL: 60, OrderName: "www", Auth: true},
+		&storage.Record{DomainID: a, Name: "a.test.", Type: "SOA", Content: "ns.a.test. admin.a.test. 1 3600 600 86400 60", TTL: 3600, Auth: true},
+		&storage.Record{DomainID: a, Name: "old.a.test.", Type: "A", Content: "192.0.2.9", TTL: 60, Disabled: true},
+		&storage.Record{DomainID: a, Name: "a.test.", Type: "NS", Content: "ns.a.test.", TTL: 3600, Auth: true},
+	)
+
+	list := func(includeDisabled bool) []*storage.Record {
+		it, err := r.Records.List(ctx, a, includeDisabled)
+		assert.Equal(t, err, nil)
+		defer it.Close()
+		rrs := make([]*storage.Record, 0)
+		for it.Next() {
+			rrs = append(rrs, it.Record())
+		}
+		assert.Equal(t, it.Err(), nil)
+		return rrs
+	}
+	rrs := list(false)
+	names := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		names = append(names, rr.Name+" "+rr.Type)
+	}
+	assert.Equal(t, names, []string{"a.test. NS", "a.test. SOA", "www.a.test. A"})
+	assert.Equal(t, rrs[2].OrderName, "www")
+	assert.Equal(t, rrs[2].Auth, true)
+	assert.Equal(t, len(list(true)), 4)
+
+	n, err := r.Records.DeleteRRSet(ctx, a, "old.a.test.", "A")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	n, err = r.Records.DeleteRRSet(ctx, a, "a.test.", "A")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 0)
+	assert.Equal(t, len(list(true)), 3)
+
+	n, err = r.Records.DeleteZone(ctx, a)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 3)
+	assert.Equal(t, len(list(true)), 0)
+}
+
+func testMetadata(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	createZone(t, r, "a.test.")
+
+	assert.Equal(t, r.Metadata.Set(ctx, "a.test.", "ALSO-NOTIFY", []string{"192.0.2.1", "192.0.2.2"}), nil)
+	assert.Equal(t, r.Metadata.Set(ctx, "a.test.", "SOA-EDIT", []string{"INCEPTION-INCREMENT"}), nil)
+	assert.Equal(t, r.Metadata.Set(ctx, "a.test.", "SOA-EDIT", []string{"EPOCH"}), nil)
+
+	values, err := r.Metadata.Get(ctx, "a.test.", "SOA-EDIT")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, values, []string{"EPOCH"})
+
+	meta, err := r.Metadata.GetAll(ctx, "a.test.")
+	assert.Equal(t, err, nil)
+	sort.Strings(meta["ALSO-NOTIFY"])
+	assert.Equal(t, meta, map[string][]string{
+		"ALSO-NOTIFY": {"192.0.2.1", "192.0.2.2"},
+		"SOA-EDIT":    {"EPOCH"},
+	})
+
+	assert.Equal(t, r.Metadata.Set(ctx, "a.test.", "ALSO-NOTIFY", nil), nil)
+	values, err = r.Metadata.Get(ctx, "a.test.", "ALSO-NOTIFY")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(values), 0)
+
+	assert.Equal(t, r.Metadata.Set(ctx, "none.test.", "SOA-EDIT", []string{"EPOCH"}), nil)
+	meta, err = r.Metadata.GetAll(ctx, "none.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(meta), 0)
+}
+
+func testKeys(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	createZone(t, r, "a.test.")
+
+	n, err := r.Keys.Add(ctx, "a.test.", &storage.Key{Flags: 257, Active: true, Published: true, Content: "ksk"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	n, err = r.Keys.Add(ctx, "a.test.", &storage.Key{Flags: 256, Active: false, Published: true, Content: "zsk"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	n, err = r.Keys.Add(ctx, "none.test.", &storage.Key{Flags: 256, Content: "lost"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 0)
+
+	keys, err := r.Keys.List(ctx, "a.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(keys), 2)
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Flags > keys[j].Flags
+	})
+	assert.NotEqual(t, keys[0].ID, keys[1].ID)
+	assert.Equal(t, keys[0].Content, "ksk")
+	assert.Equal(t, keys[0].Active, true)
+	assert.Equal(t, keys[1].Content, "zsk")
+	assert.Equal(t, keys[1].Active, false)
+	assert.Equal(t, keys[1].Published, true)
+
+	keys, err = r.Keys.List(ctx, "none.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(keys), 0)
+}
+
+func testTSIG(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+
+	_, err := r.TSIG.Get(ctx, "xfr.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	assert.Equal(t, r.TSIG.Set(ctx, &storage.TSIGKey{Name: "xfr.", Algorithm: "hmac-md5", Secret: "b2xk"}), nil)
+	assert.Equal(t, r.TSIG.Set(ctx, &storage.TSIGKey{Name: "xfr.", Algorithm: "hmac-sha256", Secret: "bmV3"}), nil)
+	assert.Equal(t, r.TSIG.Set(ctx, &storage.TSIGKey{Name: "notify.", Algorithm: "hmac-sha256", Secret: "bm90aWZ5"}), nil)
+
+	key, err := r.TSIG.Get(ctx, "xfr.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, key, &storage.TSIGKey{Name: "xfr.", Algorithm: "hmac-sha256", Secret: "bmV3"})
+
+	keys, err := r.TSIG.List(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(keys), 2)
+
+	n, err := r.TSIG.Delete(ctx, "xfr.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	n, err = r.TSIG.Delete(ctx, "xfr.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 0)
+}
+
+func testComments(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	a := createZone(t, r, "a.test.")
+
+	for _, c := range []*storage.Comment{
+		{DomainID: a, Name: "www.a.test.", Type: "A", ModifiedAt: 1, Account: "ops", Comment: "web"},
+		{DomainID: a, Name: "www.a.test.", Type: "A", ModifiedAt: 2, Comment: "still web"},
+		{DomainID: a, Name: "a.test.", Type: "NS", ModifiedAt: 3, Comment: "delegation"},
+	} {
+		assert.Equal(t, r.Comments.Insert(ctx, c), nil)
+	}
+	list, err := r.Comments.List(ctx, a)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(list), 3)
+
+	n, err := r.Comments.DeleteRRSet(ctx, a, "www.a.test.", "A")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 2)
+	list, err = r.Comments.List(ctx, a)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, list, []*storage.Comment{
+		{DomainID: a, Name: "a.test.", Type: "NS", ModifiedAt: 3, Comment: "delegation"},
+	})
+
+	n, err = r.Comments.DeleteZone(ctx, a)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+}
+
+func testSupermasters(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+
+	assert.Equal(t, r.Supermasters.Add(ctx, &storage.Supermaster{IP: "192.0.2.53", Nameserver: "ns1.test.", Account: "ops"}), nil)
+	assert.Equal(t, r.Supermasters.Add(ctx, &storage.Supermaster{IP: "192.0.2.54", Nameserver: "ns2.test.", Account: "ops"}), nil)
+	assert.NotEqual(t, r.Supermasters.Add(ctx, &storage.Supermaster{IP: "192.0.2.53", Nameserver: "NS1.test.", Account: "other"}), nil)
+
+	account, err := r.Supermasters.Account(ctx, "192.0.2.53", "NS1.TEST.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, account, "ops")
+	_, err = r.Supermasters.Account(ctx, "192.0.2.54", "ns1.test.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	list, err := r.Supermasters.List(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(list), 2)
+
+	n, err := r.Supermasters.Remove(ctx, "192.0.2.53", "ns1.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+}
+
+func testOrderName(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	a := createZone(t, r, "a.test.")
+	b := createZone(t, r, "b.test.")
+	insert(t, r,
+		&storage.Record{DomainID: a, Name: "a.test.", Type: "SOA", Content: "soa", Auth: true},
+		&storage.Record{DomainID: a, Name: "b.a.test.", Type: "A", Content: "192.0.2.1", OrderName: "b", Auth: true},
+		&storage.Record{DomainID: a, Name: "d.a.test.", Type: "A", Content: "192.0.2.2", OrderName: "d", Auth: true},
+		&storage.Record{DomainID: a, Name: "x.d.a.test.", Type: "A", Content: "192.0.2.3", OrderName: "d x", Auth: true},
+		&storage.Record{DomainID: a, Name: "z.a.test.", Type: "A", Content: "192.0.2.4", OrderName: "z", Disabled: true},
+		&storage.Record{DomainID: b, Name: "c.b.test.", Type: "A", Content: "192.0.2.5", OrderName: "c", Auth: true},
+	)
+
+	name := func(rr *storage.Record, err error) string {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "-"
+		}
+		assert.Equal(t, err, nil)
+		return rr.Name
+	}
+	assert.Equal(t, name(r.Records.OrderBefore(ctx, a, "c")), "b.a.test.")
+	assert.Equal(t, name(r.Records.OrderBefore(ctx, a, "d")), "d.a.test.")
+	assert.Equal(t, name(r.Records.OrderBefore(ctx, a, "a")), "-")
+	assert.Equal(t, name(r.Records.OrderAfter(ctx, a, "c")), "d.a.test.")
+	assert.Equal(t, name(r.Records.OrderAfter(ctx, a, "d")), "x.d.a.test.")
+	assert.Equal(t, name(r.Records.OrderAfter(ctx, a, "d x")), "-")
+	assert.Equal(t, name(r.Records.OrderFirst(ctx, a)), "b.a.test.")
+	assert.Equal(t, name(r.Records.OrderLast(ctx, a)), "x.d.a.test.")
+	assert.Equal(t, name(r.Records.OrderLast(ctx, b)), "c.b.test.")
+
+	rr, err := r.Records.OrderAfter(ctx, a, "b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rr.OrderName, "d")
+}
+
+func testOrderNameAndAuth(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	a := createZone(t, r, "a.test.")
+	insert(t, r,
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.1"},
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.2"},
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "TXT", Content: `"x"`, OrderName: "old", Auth: true},
+		&storage.Record{DomainID: a, Name: "www.a.test.", Type: "AAAA", Content: "2001:db8::1", Disabled: true},
+	)
+	list := func() map[string]string {
+		it, err := r.Records.List(ctx, a, true)
+		assert.Equal(t, err, nil)
+		defer it.Close()
+		got := make(map[string]string)
+		for it.Next() {
+			rr := it.Record()
+			got[rr.Name+" "+rr.Type+" "+rr.Content] = rr.OrderName
+			if rr.Auth {
+				got[rr.Name+" "+rr.Type+" "+rr.Content] += " auth"
+			}
+		}
+		assert.Equal(t, it.Err(), nil)
+		return got
+	}
+
+	n, err := r.Records.SetOrderNameAndAuth(ctx, a, "www.a.test.", "A", "www", true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 2)
+	assert.Equal(t, list(), map[string]string{
+		"www.a.test. A 192.0.2.1":      "www auth",
+		"www.a.test. A 192.0.2.2":      "www auth",
+		`www.a.test. TXT "x"`:          "old auth",
+		"www.a.test. AAAA 2001:db8::1": "",
+	})
+
+	n, err = r.Records.SetOrderNameAndAuth(ctx, a, "www.a.test.", "", "", false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 3)
+	assert.Equal(t, list()[`www.a.test. TXT "x"`], "")
+
+	assert.Equal(t, r.Records.InsertEmptyNonTerminal(ctx, a, "ent.a.test.", "ent", true), nil)
+	assert.Equal(t, list()["ent.a.test.  "], "ent auth")
+	n, err = r.Records.DeleteEmptyNonTerminal(ctx, a, "ent.a.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	n, err = r.Records.DeleteEmptyNonTerminal(ctx, a, "www.a.test.")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 0)
+}
+
+func testCommit(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	a := createZone(t, s.Repositories(), "a.test.")
+
+	tx, err := s.Begin(ctx)
+	assert.Equal(t, err, nil)
+	r := tx.Repositories()
+	insert(t, r, &storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.1", TTL: 60})
+	assert.Equal(t, r.Metadata.Set(ctx, "a.test.", "SOA-EDIT", []string{"EPOCH"}), nil)
+	// the transaction reads its own writes
+	rrs, err := r.Records.Lookup(ctx, storage.LookupQuery{Name: "www.a.test.", Type: "A", DomainID: a})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rrs), 1)
+	assert.Equal(t, tx.Commit(), nil)
+
+	rrs, err = s.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: "www.a.test.", Type: "A", DomainID: a})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rrs), 1)
+	values, err := s.Repositories().Metadata.Get(ctx, "a.test.", "SOA-EDIT")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, values, []string{"EPOCH"})
+}
+
+func testRollback(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	a := createZone(t, s.Repositories(), "a.test.")
+	insert(t, s.Repositories(), &storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.1", TTL: 60})
+
+	tx, err := s.Begin(ctx)
+	assert.Equal(t, err, nil)
+	r := tx.Repositories()
+	n, err := r.Records.DeleteZone(ctx, a)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	insert(t, r, &storage.Record{DomainID: a, Name: "www.a.test.", Type: "A", Content: "192.0.2.2", TTL: 60})
+	assert.Equal(t, r.Zones.Create(ctx, &storage.Zone{Name: "b.test.", Kind: "NATIVE"}), nil)
+	assert.Equal(t, tx.Rollback(), nil)
+
+	rrs, err := s.Repositories().Records.Lookup(ctx, storage.LookupQuery{Name: "www.a.test.", Type: "A", DomainID: a})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, contents(rrs), []string{"192.0.2.1"})
+	_, err = s.Repositories().Zones.ID(ctx, "b.test.")
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+}
+
+func testChanges(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	err := r.Changes.Append(ctx,
+		&storage.Change{DomainID: 1, Operation: "insert-record", After: []byte(`{"qname":"a"}`), Actor: "test", Time: 100},
+		&storage.Change{DomainID: 2, Operation: "insert-record", Time: 200},
+		&storage.Change{DomainID: -1, Operation: "set-tsig-key", Time: 300},
+	)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.Changes.Append(ctx, &storage.Change{DomainID: 1, Operation: "delete-zone", Before: []byte(`{"records":1}`), Time: 400}), nil)
+
+	all, err := r.Changes.Since(ctx, 0, -1, -1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(all), 4)
+	for i := 1; i < len(all); i++ {
+		assert.True(t, all[i].Seq > all[i-1].Seq)
+	}
+	assert.Equal(t, all[0].Actor, "test")
+	assert.Equal(t, string(all[0].After), `{"qname":"a"}`)
+	assert.Equal(t, all[2].DomainID, -1)
+
+	zone, err := r.Changes.Since(ctx, all[0].Seq, 1, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(zone), 1)
+	assert.Equal(t, zone[0].Operation, "delete-zone")
+	assert.Equal(t, string(zone[0].Before), `{"records":1}`)
+
+	limited, err := r.Changes.Since(ctx, 0, -1, 2)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(limited), 2)
+
+	n, err := r.Changes.DeleteBefore(ctx, 200)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	n, err = r.Changes.KeepLast(ctx, 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 2)
+	left, err := r.Changes.Since(ctx, 0, -1, -1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(left), 1)
+	assert.Equal(t, left[0].Seq, all[3].Seq)
+
+	// seq is never reused
+	assert.Equal(t, r.Changes.Append(ctx, &storage.Change{DomainID: 1, Operation: "set-fresh", Time: 500}), nil)
+	left, err = r.Changes.Since(ctx, 0, -1, -1)
+	assert.Equal(t, err, nil)
+	assert.True(t, left[1].Seq > all[3].Seq)
+}
+
+func testChangesGap(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	r := s.Repositories()
+	for _, created := range []int64{500, 500, 100, 500} {
+		assert.Equal(t, r.Changes.Append(ctx, &storage.Change{DomainID: 1, Operation: "set-fresh", Time: created}), nil)
+	}
+	all, err := r.Changes.Since(ctx, 0, -1, -1)
+	assert.Equal(t, err, nil)
+	n, err := r.Changes.DeleteBefore(ctx, 200)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+
+	// the seq of the third entry is missing among the last two kept
+	n, err = r.Changes.KeepLast(ctx, 2)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	left, err := r.Changes.Since(ctx, 0, -1, -1)
+	assert.Equal(t, err, nil)
+	if assert.Equal(t, len(left), 2) {
+		assert.Equal(t, left[0].Seq, all[1].Seq)
+		assert.Equal(t, left[1].Seq, all[3].Seq)
+	}
+	n, err = r.Changes.KeepLast(ctx, 5)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 0)
+}